@@ -1,6 +1,8 @@
 package swarmgo
 
 import (
+	"time"
+
 	"github.com/prathyushnallamothu/swarmgo/llm"
 )
 
@@ -15,14 +17,22 @@ type Agent struct {
 	Functions         []AgentFunction                                      // A list of functions the agent can perform.
 	Memory            *MemoryStore                                         // Memory store for the agent.
 	ParallelToolCalls bool                                                 // Whether to allow parallel tool calls.
+	InputGuardrails   []Guardrail                                          // Guardrails checking the latest user message before the agent sees it.
+	OutputGuardrails  []Guardrail                                          // Guardrails checking the agent's final reply before it is returned.
+	Handoffs          []Handoff                                            // Agents this agent can transfer the conversation to.
 }
 
 // AgentFunction represents a function that can be performed by an agent
 type AgentFunction struct {
-	Name        string                                                                            // The name of the function.
-	Description string                                                                            // Description of what the function does.
-	Parameters  map[string]interface{}                                                            // Parameters for the function.
-	Function    func(args map[string]interface{}, contextVariables map[string]interface{}) Result // The actual function implementation.
+	Name             string                                                                            // The name of the function.
+	Description      string                                                                            // Description of what the function does.
+	Parameters       map[string]interface{}                                                            // Parameters for the function.
+	Function         func(args map[string]interface{}, contextVariables map[string]interface{}) Result // The actual function implementation.
+	ContextFunction  ToolFunc                                                                          // Context-aware implementation, used instead of Function when set.
+	Timeout          time.Duration                                                                     // Maximum duration of a single call, 0 for no limit. A call that ignores its context is abandoned, still running, once it expires.
+	MaxRetries       int                                                                               // Number of times a failed call is retried. Calls with invalid arguments aren't retried.
+	RequiresApproval bool                                                                              // Whether Run pauses for approval before every call.
+	ApprovalPolicy   func(args map[string]interface{}, contextVariables map[string]interface{}) bool   // Decides per call whether Run pauses for approval, used instead of RequiresApproval when set.
 }
 
 // FunctionToDefinition converts an AgentFunction to a llm.Function
@@ -34,6 +44,17 @@ func FunctionToDefinition(af AgentFunction) llm.Function {
 	}
 }
 
+// snapshot returns a copy of the agent for a single run, so changes made to the
+// agent or its slices while the run is in progress don't affect it
+func (a *Agent) snapshot() *Agent {
+	snapshot := *a
+	snapshot.Functions = append([]AgentFunction(nil), a.Functions...)
+	snapshot.InputGuardrails = append([]Guardrail(nil), a.InputGuardrails...)
+	snapshot.OutputGuardrails = append([]Guardrail(nil), a.OutputGuardrails...)
+	snapshot.Handoffs = append([]Handoff(nil), a.Handoffs...)
+	return &snapshot
+}
+
 // NewAgent creates a new agent with initialized memory store
 func NewAgent(name, model string, provider llm.LLMProvider) *Agent {
 	return &Agent{
@@ -68,6 +89,18 @@ func (a *Agent) WithFunctions(functions []AgentFunction) *Agent {
 	return a
 }
 
+// WithInputGuardrails adds guardrails that check user input before it reaches the agent
+func (a *Agent) WithInputGuardrails(guardrails ...Guardrail) *Agent {
+	a.InputGuardrails = append(a.InputGuardrails, guardrails...)
+	return a
+}
+
+// WithOutputGuardrails adds guardrails that check the agent's final reply
+func (a *Agent) WithOutputGuardrails(guardrails ...Guardrail) *Agent {
+	a.OutputGuardrails = append(a.OutputGuardrails, guardrails...)
+	return a
+}
+
 // WithParallelToolCalls enables or disables parallel tool calls
 func (a *Agent) WithParallelToolCalls(enabled bool) *Agent {
 	a.ParallelToolCalls = enabled
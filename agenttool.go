@@ -0,0 +1,119 @@
+package swarmgo
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/prathyushnallamothu/swarmgo/llm"
+)
+
+// AgentToolConfig configures an agent wrapped as a tool of another agent
+type AgentToolConfig struct {
+	Name          string // Name of the tool, the agent's name in snake case by default
+	Description   string // Description of the tool, shown to the parent model
+	MaxTurns      int    // Turn limit of the nested run, DefaultMaxTurns when zero
+	ModelOverride string // Model used for the nested run instead of the agent's
+	MaxRetries    int    // Retries of invalid replies for NewStructuredAgentTool
+}
+
+// agentToolInput is what the parent model passes to an agent tool
+type agentToolInput struct {
+	Input string `json:"input" jsonschema:"description=Task or question for the agent, with all the context it needs"`
+}
+
+// NewAgentTool wraps agent as a tool. The parent model calls it with an input string,
+// the agent answers in its own Run loop with its own tools, and only its final answer
+// is returned as the tool's result. The nested run shares the parent's context, so it
+// is cancelled with it and reports to the same hooks with the parent's run ID as
+// ParentRunID, and its usage is added to the parent's Response. It gets a copy of the
+// context variables, so its changes stay isolated from the parent.
+func NewAgentTool(s *Swarm, agent *Agent, config AgentToolConfig) AgentFunction {
+	name, description := config.names(agent)
+	return NewTool(name, description, func(ctx context.Context, in agentToolInput, rc *RunContext) (string, error) {
+		response, err := s.Run(ctx, agent, []llm.Message{{Role: llm.RoleUser, Content: in.Input}},
+			copyContextVariables(rc.ContextVariables), config.ModelOverride, false, false, config.MaxTurns, true)
+		if err != nil {
+			return "", err
+		}
+		if response.StopReason != StopFinalMessage {
+			return "", fmt.Errorf("agent %s stopped without a final answer (%s)", agent.Name, response.StopReason)
+		}
+		return response.Messages[len(response.Messages)-1].Content, nil
+	})
+}
+
+// NewStructuredAgentTool wraps agent as a tool like NewAgentTool, returning its final
+// answer decoded into a T as with RunStructured
+func NewStructuredAgentTool[T any](s *Swarm, agent *Agent, config AgentToolConfig) AgentFunction {
+	name, description := config.names(agent)
+	return NewTool(name, description, func(ctx context.Context, in agentToolInput, rc *RunContext) (T, error) {
+		out, _, err := RunStructured[T](ctx, s, agent, []llm.Message{{Role: llm.RoleUser, Content: in.Input}},
+			copyContextVariables(rc.ContextVariables), config.ModelOverride, config.MaxTurns, config.MaxRetries)
+		return out, err
+	})
+}
+
+// names returns the tool name and description of an agent tool
+func (c AgentToolConfig) names(agent *Agent) (string, string) {
+	name := c.Name
+	if name == "" {
+		name = snakeCase(agent.Name)
+	}
+	description := c.Description
+	if description == "" {
+		description = fmt.Sprintf("Ask the %s agent to handle a task and return its answer.", agent.Name)
+	}
+	return name, description
+}
+
+// usageCollectorKey is the context key of the collector of a run's nested usage
+type usageCollectorKey struct{}
+
+// usageCollector gathers the usage of runs nested inside a run, e.g. agent tools
+// and agent guardrails, which may finish concurrently
+type usageCollector struct {
+	mu    sync.Mutex
+	usage RunUsage
+}
+
+// add records the usage of a nested run
+func (c *usageCollector) add(usage RunUsage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.usage.Merge(usage)
+}
+
+// drain returns the usage recorded so far and resets it
+func (c *usageCollector) drain() RunUsage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	usage := c.usage
+	c.usage = RunUsage{}
+	return usage
+}
+
+// addTurnUsage records the usage of model calls made outside a run's turns, e.g. to
+// summarize its conversation, on the collector of the run ctx belongs to, if any.
+// Turns without a request, such as those of failed calls, are skipped.
+func addTurnUsage(ctx context.Context, turns ...TurnUsage) {
+	collector, ok := ctx.Value(usageCollectorKey{}).(*usageCollector)
+	if !ok {
+		return
+	}
+	var usage RunUsage
+	for _, turn := range turns {
+		if turn.Requests > 0 {
+			usage.AddTurn(turn)
+		}
+	}
+	collector.add(usage)
+}
+
+// withUsageCollector returns a context collecting the usage of runs nested under it,
+// along with the collector of the enclosing run, if any
+func withUsageCollector(ctx context.Context) (context.Context, *usageCollector, *usageCollector) {
+	parent, _ := ctx.Value(usageCollectorKey{}).(*usageCollector)
+	collector := &usageCollector{}
+	return context.WithValue(ctx, usageCollectorKey{}, collector), collector, parent
+}
@@ -0,0 +1,203 @@
+package swarmgo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/prathyushnallamothu/swarmgo/llm"
+)
+
+var (
+	// ErrMissingApproval is returned by Resume when a pending tool call has no decision
+	ErrMissingApproval = errors.New("tool call has no approval decision")
+	// ErrApprovalRequired is returned by StreamingResponse, which can't pause, when the
+	// model calls a tool that requires approval
+	ErrApprovalRequired = errors.New("tool call requires approval, use Run and Resume")
+)
+
+// ApprovalAction is the application's decision about a tool call waiting for approval
+type ApprovalAction string
+
+const (
+	ApprovalApprove ApprovalAction = "approve" // Run the call as the model made it
+	ApprovalEdit    ApprovalAction = "edit"    // Run the call with ApprovalDecision.Args instead
+	ApprovalReject  ApprovalAction = "reject"  // Don't run the call and tell the model why
+)
+
+// ApprovalDecision answers one pending tool call
+type ApprovalDecision struct {
+	CallID string // ID of the tool call the decision is for
+	Action ApprovalAction
+	Args   map[string]interface{} // Replacement arguments for ApprovalEdit
+	Reason string                 // Reason sent to the model for ApprovalReject
+}
+
+// Approve approves a tool call as it was made
+func Approve(callID string) ApprovalDecision {
+	return ApprovalDecision{CallID: callID, Action: ApprovalApprove}
+}
+
+// ApproveWithArgs approves a tool call with edited arguments
+func ApproveWithArgs(callID string, args map[string]interface{}) ApprovalDecision {
+	return ApprovalDecision{CallID: callID, Action: ApprovalEdit, Args: args}
+}
+
+// Reject rejects a tool call, the reason is sent back to the model as the tool's result
+func Reject(callID, reason string) ApprovalDecision {
+	return ApprovalDecision{CallID: callID, Action: ApprovalReject, Reason: reason}
+}
+
+// ToolRejectedError is reported for a tool call the application rejected
+type ToolRejectedError struct {
+	Tool   string // Name of the rejected tool
+	Reason string // Reason given by the application
+}
+
+func (e *ToolRejectedError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("call to tool %s was rejected", e.Tool)
+	}
+	return fmt.Sprintf("call to tool %s was rejected: %s", e.Tool, e.Reason)
+}
+
+// PendingToolCall is a tool call waiting for approval
+type PendingToolCall struct {
+	ToolCall llm.ToolCall           `json:"tool_call"` // The call exactly as the model made it
+	Args     map[string]interface{} `json:"args"`      // The call's parsed arguments
+}
+
+// PendingApproval is the state of a run paused because the model called tools that
+// require approval. Pass it to Resume along with a decision for every pending call.
+type PendingApproval struct {
+	Agent            *Agent                 // Agent whose tool calls are waiting
+	Messages         []llm.Message          // Conversation so far, ending with the assistant message making the calls
+	ContextVariables map[string]interface{} // Context variables of the run
+	ToolCalls        []PendingToolCall      // Calls waiting for a decision, in call order
+	Turns            int                    // Model calls made before the pause
+
+	modelOverride string
+	stream        bool
+	debug         bool
+	maxTurns      int
+	opts          runOptions
+}
+
+// needsApproval reports whether a call to af with args must wait for approval
+func (af *AgentFunction) needsApproval(args map[string]interface{}, contextVariables map[string]interface{}) bool {
+	if af.ApprovalPolicy != nil {
+		return af.ApprovalPolicy(args, contextVariables)
+	}
+	return af.RequiresApproval
+}
+
+// pendingToolCalls returns the calls of a turn that must wait for approval. Calls with
+// arguments that don't parse or to unknown tools fail on their own and never wait.
+func pendingToolCalls(agent *Agent, toolCalls []llm.ToolCall, contextVariables map[string]interface{}) []PendingToolCall {
+	var pending []PendingToolCall
+	for _, tc := range toolCalls {
+		af := findFunction(agent, tc.Function.Name)
+		if af == nil {
+			continue
+		}
+		var args map[string]interface{}
+		if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
+			continue
+		}
+		if af.needsApproval(args, contextVariables) {
+			pending = append(pending, PendingToolCall{ToolCall: tc, Args: args})
+		}
+	}
+	return pending
+}
+
+// Resume continues a run paused for approval. Approved calls run, possibly with edited
+// arguments, rejected calls are answered with a tool result explaining the rejection,
+// and calls that didn't need approval run as usual. The run then continues with the same
+// model override, streaming, debug and turn limit settings. The returned Response starts
+// with the assistant message that made the calls, with any edited arguments applied.
+func (s *Swarm) Resume(ctx context.Context, pending *PendingApproval, decisions ...ApprovalDecision) (Response, error) {
+	if pending == nil || pending.Agent == nil {
+		return Response{}, ErrNilAgent
+	}
+	if len(pending.Messages) == 0 {
+		return Response{}, ErrEmptyMessages
+	}
+
+	byID := make(map[string]ApprovalDecision, len(decisions))
+	for _, d := range decisions {
+		byID[d.CallID] = d
+	}
+	for _, p := range pending.ToolCalls {
+		d, ok := byID[p.ToolCall.ID]
+		if !ok {
+			return Response{}, fmt.Errorf("%w: %s (%s)", ErrMissingApproval, p.ToolCall.ID, p.ToolCall.Function.Name)
+		}
+		switch d.Action {
+		case ApprovalApprove, ApprovalReject:
+		case ApprovalEdit:
+			if d.Args == nil {
+				return Response{}, fmt.Errorf("edit of tool call %s has no arguments", d.CallID)
+			}
+		default:
+			return Response{}, fmt.Errorf("unknown approval action %q for tool call %s", d.Action, d.CallID)
+		}
+	}
+
+	opts := pending.opts
+	opts.approvals = byID
+	opts.resumeTurns = pending.Turns
+	return s.runWithOptions(ctx, pending.Agent, pending.Messages, pending.ContextVariables, pending.modelOverride,
+		pending.stream, pending.debug, pending.maxTurns, true, opts)
+}
+
+// applyApprovals applies edited arguments to the tool calls of the last message in history,
+// which must be a copy owned by the run, and returns the calls to execute along with the
+// results answering the rejected ones
+func (s *Swarm) applyApprovals(
+	ctx context.Context,
+	history []llm.Message,
+	approvals map[string]ApprovalDecision,
+	debug bool,
+) ([]llm.ToolCall, map[string]ToolResult, map[string]llm.Message, error) {
+	message := &history[len(history)-1]
+	message.ToolCalls = append([]llm.ToolCall(nil), message.ToolCalls...)
+
+	var approved []llm.ToolCall
+	rejectedResults := make(map[string]ToolResult)
+	rejectedMessages := make(map[string]llm.Message)
+	for i := range message.ToolCalls {
+		tc := &message.ToolCalls[i]
+		d, ok := approvals[tc.ID]
+		switch {
+		case !ok || d.Action == ApprovalApprove:
+			approved = append(approved, *tc)
+		case d.Action == ApprovalEdit:
+			args, err := json.Marshal(d.Args)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to marshal edited arguments of tool call %s: %w", tc.ID, err)
+			}
+			tc.Function.Arguments = string(args)
+			approved = append(approved, *tc)
+		case d.Action == ApprovalReject:
+			s.log(ctx, debug, slog.LevelInfo, "tool call rejected",
+				"tool", tc.Function.Name, "tool_call_id", tc.ID, "reason", d.Reason)
+			err := &ToolRejectedError{Tool: tc.Function.Name, Reason: d.Reason}
+			var args map[string]interface{}
+			_ = json.Unmarshal([]byte(tc.Function.Arguments), &args)
+			result := ToolResult{
+				ToolName:   tc.Function.Name,
+				ToolCallID: tc.ID,
+				Args:       args,
+				Result:     Result{Success: false, Error: err},
+				ErrorKind:  ToolErrorRejected,
+			}
+			s.emit(ctx, RunEvent{Type: EventToolEnd, ToolCall: tc, ToolResult: &result, Err: err})
+			rejectedResults[tc.ID] = result
+			rejectedMessages[tc.ID] = newToolMessage(tc, formatToolError(err), true)
+		}
+	}
+	return approved, rejectedResults, rejectedMessages, nil
+}
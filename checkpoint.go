@@ -0,0 +1,374 @@
+package swarmgo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prathyushnallamothu/swarmgo/llm"
+)
+
+var (
+	// ErrCheckpointNotFound is returned by a CheckpointStore that has no checkpoint with the requested ID
+	ErrCheckpointNotFound = errors.New("checkpoint not found")
+	// ErrNoCheckpointStore is returned by ResumeRun when the Swarm has no checkpoint store
+	ErrNoCheckpointStore = errors.New("no checkpoint store set")
+	// ErrUnknownCheckpointAgent is returned by ResumeRun when the checkpoint's agent isn't among its agents
+	ErrUnknownCheckpointAgent = errors.New("checkpoint agent not found")
+)
+
+// Checkpoint is the state of an unfinished run, written after every model turn and every
+// tool result so the run can be continued with ResumeRun after a crash
+type Checkpoint struct {
+	ID               string                 `json:"id"`
+	AgentName        string                 `json:"agent_name"`         // Agent active at the checkpoint
+	Messages         []llm.Message          `json:"messages"`           // Conversation so far, including the run's input
+	InputMessages    int                    `json:"input_messages"`     // Number of leading Messages that were the run's input
+	PendingToolCalls []llm.ToolCall         `json:"pending_tool_calls"` // Calls of the last turn that have no result yet
+	ContextVariables map[string]interface{} `json:"context_variables"`  // Context variables as of the checkpoint
+	Turns            int                    `json:"turns"`              // Model calls made so far
+	UpdatedAt        time.Time              `json:"updated_at"`
+
+	// Settings the run continues with
+	ModelOverride  string              `json:"model_override,omitempty"`
+	Stream         bool                `json:"stream,omitempty"`
+	Debug          bool                `json:"debug,omitempty"`
+	MaxTurns       int                 `json:"max_turns"`
+	ExecuteTools   bool                `json:"execute_tools"`
+	ResponseFormat *llm.ResponseFormat `json:"response_format,omitempty"`
+}
+
+// clone returns a copy of the checkpoint that shares no slices or maps with it
+func (c *Checkpoint) clone() *Checkpoint {
+	cp := *c
+	cp.Messages = cloneMessages(c.Messages)
+	cp.PendingToolCalls = append([]llm.ToolCall(nil), c.PendingToolCalls...)
+	cp.ContextVariables = copyContextVariables(c.ContextVariables)
+	return &cp
+}
+
+// CheckpointStore persists the checkpoints of runs. Context variables must be
+// JSON-serializable for stores that encode them, and come back as decoded JSON.
+type CheckpointStore interface {
+	Load(ctx context.Context, id string) (*Checkpoint, error) // Returns ErrCheckpointNotFound if there is no such checkpoint
+	Save(ctx context.Context, checkpoint *Checkpoint) error
+	Delete(ctx context.Context, id string) error
+}
+
+// SetCheckpointStore makes every run of the Swarm checkpoint its progress to store. A run's
+// checkpoint is replaced as it progresses and deleted once the run returns without error.
+// Runs nested in a checkpointed run, e.g. agent tools, aren't checkpointed on their own:
+// the tool call running them is repeated on resume if it had not finished.
+func (s *Swarm) SetCheckpointStore(store CheckpointStore) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoints = store
+}
+
+// checkpointIDKey is the context key of the checkpoint ID set with WithCheckpointID
+type checkpointIDKey struct{}
+
+// WithCheckpointID returns a context whose run is checkpointed under id instead of its run ID,
+// so the caller knows which checkpoint to resume after a crash
+func WithCheckpointID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, checkpointIDKey{}, id)
+}
+
+// ResumeRun continues the run saved in a checkpoint. Tool calls whose results were
+// checkpointed aren't run again; the remaining calls of the last turn run before the
+// model is called again. The checkpoint's agent is looked up by name among agents and
+// the agents they hand off to. The returned Response covers everything the run added
+// to its input, including what happened before the checkpoint, while ToolResults and
+// Usage only cover the resumed part.
+func (s *Swarm) ResumeRun(ctx context.Context, checkpointID string, agents ...*Agent) (Response, error) {
+	s.mu.Lock()
+	store := s.checkpoints
+	s.mu.Unlock()
+	if store == nil {
+		return Response{}, ErrNoCheckpointStore
+	}
+
+	checkpoint, err := store.Load(ctx, checkpointID)
+	if err != nil {
+		return Response{}, fmt.Errorf("failed to load checkpoint %s: %w", checkpointID, err)
+	}
+	if checkpoint.InputMessages > len(checkpoint.Messages) {
+		return Response{}, fmt.Errorf("checkpoint %s has %d input messages but only %d messages",
+			checkpointID, checkpoint.InputMessages, len(checkpoint.Messages))
+	}
+	agent := findAgent(agents, checkpoint.AgentName)
+	if agent == nil {
+		return Response{}, fmt.Errorf("%w: %s", ErrUnknownCheckpointAgent, checkpoint.AgentName)
+	}
+
+	return s.runWithOptions(ctx, agent, checkpoint.Messages, checkpoint.ContextVariables, checkpoint.ModelOverride,
+		checkpoint.Stream, checkpoint.Debug, checkpoint.MaxTurns, checkpoint.ExecuteTools,
+		runOptions{responseFormat: checkpoint.ResponseFormat, checkpoint: checkpoint})
+}
+
+// checkpointerKey is the context key of the checkpointer of the current run
+type checkpointerKey struct{}
+
+// checkpointer writes the checkpoints of a run. Its methods do nothing on a nil checkpointer,
+// the checkpointer of runs that aren't checkpointed.
+type checkpointer struct {
+	store    CheckpointStore
+	settings Checkpoint // ID and settings of the run, copied into every checkpoint
+	swarm    *Swarm     // Swarm running the run, for logging
+
+	mu      sync.Mutex
+	current *Checkpoint // Latest checkpoint, nil until the first one is saved
+	err     error       // First failure to save a tool result's checkpoint
+}
+
+// startCheckpoints returns a context with the checkpointer of a new run, or nil when the run
+// isn't checkpointed. Runs nested in a checkpointed run get a context without checkpointer.
+func (s *Swarm) startCheckpoints(ctx context.Context, settings Checkpoint, resumed *Checkpoint) (context.Context, *checkpointer) {
+	s.mu.Lock()
+	store := s.checkpoints
+	s.mu.Unlock()
+	if store == nil {
+		return ctx, nil
+	}
+	if _, nested := ctx.Value(checkpointerKey{}).(*checkpointer); nested {
+		return context.WithValue(ctx, checkpointerKey{}, (*checkpointer)(nil)), nil
+	}
+
+	settings.ID = RunIDFromContext(ctx)
+	if resumed != nil {
+		settings.ID = resumed.ID
+	} else if id, _ := ctx.Value(checkpointIDKey{}).(string); id != "" {
+		settings.ID = id
+	}
+	c := &checkpointer{store: store, settings: settings, swarm: s}
+	if resumed != nil {
+		c.current = resumed.clone()
+	}
+	return context.WithValue(ctx, checkpointerKey{}, c), c
+}
+
+// checkpointerFrom returns the checkpointer of the run of ctx, or nil
+func checkpointerFrom(ctx context.Context) *checkpointer {
+	c, _ := ctx.Value(checkpointerKey{}).(*checkpointer)
+	return c
+}
+
+// save checkpoints the state of the run between turns, with the tool calls of the last turn
+// that still have to run
+func (c *checkpointer) save(
+	ctx context.Context,
+	agent string,
+	history []llm.Message,
+	initLen int,
+	pending []llm.ToolCall,
+	contextVariables map[string]interface{},
+	turns int,
+) error {
+	if c == nil {
+		return nil
+	}
+	checkpoint := c.settings
+	checkpoint.AgentName = agent
+	checkpoint.Messages = history
+	checkpoint.InputMessages = initLen
+	checkpoint.PendingToolCalls = pending
+	checkpoint.ContextVariables = contextVariables
+	checkpoint.Turns = turns
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current = checkpoint.clone()
+	return c.write(ctx)
+}
+
+// toolResult checkpoints the result of a tool call of the last turn. vars holds the context
+// variables the call ended with and base those it started with, so only its changes are applied.
+func (c *checkpointer) toolResult(ctx context.Context, message llm.Message, base, vars map[string]interface{}) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.current == nil {
+		return
+	}
+
+	for i, tc := range c.current.PendingToolCalls {
+		if tc.ID == message.ToolCallID {
+			c.current.PendingToolCalls = append(c.current.PendingToolCalls[:i:i], c.current.PendingToolCalls[i+1:]...)
+			break
+		}
+	}
+	// Parallel calls finish in any order, keep the results in call order
+	c.current.Messages = append(c.current.Messages, message)
+	orderToolResults(c.current.Messages)
+	mergeContextVariables(c.current.ContextVariables, base, vars)
+	if err := c.write(ctx); err != nil && c.err == nil {
+		c.err = err
+	}
+}
+
+// orderToolResults sorts the tool results following the last message making tool calls
+// into the order of its calls, in place. Results of other calls stay at the end.
+func orderToolResults(history []llm.Message) {
+	start := len(history)
+	for start > 0 && isToolResult(history[start-1]) {
+		start--
+	}
+	if start == 0 || len(history[start-1].ToolCalls) == 0 {
+		return
+	}
+	index := make(map[string]int, len(history[start-1].ToolCalls))
+	for i, tc := range history[start-1].ToolCalls {
+		index[tc.ID] = i
+	}
+	position := func(msg llm.Message) int {
+		if i, ok := index[msg.ToolCallID]; ok {
+			return i
+		}
+		return len(index)
+	}
+	results := history[start:]
+	sort.SliceStable(results, func(i, j int) bool { return position(results[i]) < position(results[j]) })
+}
+
+// failure returns the first failure to checkpoint a tool result
+func (c *checkpointer) failure() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// write saves the current checkpoint, the caller must hold c.mu
+func (c *checkpointer) write(ctx context.Context) error {
+	c.current.UpdatedAt = time.Now()
+	if err := c.store.Save(ctx, c.current.clone()); err != nil {
+		return fmt.Errorf("failed to save checkpoint %s: %w", c.current.ID, err)
+	}
+	return nil
+}
+
+// finish deletes the checkpoint of a run that returned without error
+func (c *checkpointer) finish(ctx context.Context, err error) {
+	if c == nil || err != nil {
+		return
+	}
+	if err := c.store.Delete(context.WithoutCancel(ctx), c.settings.ID); err != nil {
+		c.swarm.log(ctx, c.settings.Debug, slog.LevelWarn, "failed to delete checkpoint",
+			"checkpoint_id", c.settings.ID, "error", err)
+	}
+}
+
+// MemoryCheckpointStore keeps checkpoints in memory, e.g. for tests
+type MemoryCheckpointStore struct {
+	mu          sync.RWMutex
+	checkpoints map[string]*Checkpoint
+}
+
+// NewMemoryCheckpointStore creates an empty in-memory checkpoint store
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{checkpoints: make(map[string]*Checkpoint)}
+}
+
+// Load returns a copy of the checkpoint
+func (m *MemoryCheckpointStore) Load(ctx context.Context, id string) (*Checkpoint, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	checkpoint, ok := m.checkpoints[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrCheckpointNotFound, id)
+	}
+	return checkpoint.clone(), nil
+}
+
+// Save stores a copy of the checkpoint
+func (m *MemoryCheckpointStore) Save(ctx context.Context, checkpoint *Checkpoint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checkpoints[checkpoint.ID] = checkpoint.clone()
+	return nil
+}
+
+// Delete removes the checkpoint, if it exists
+func (m *MemoryCheckpointStore) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.checkpoints, id)
+	return nil
+}
+
+// FileCheckpointStore keeps each checkpoint in a JSON file named after its ID in a directory
+type FileCheckpointStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileCheckpointStore creates a checkpoint store writing to dir, creating it if needed
+func NewFileCheckpointStore(dir string) (*FileCheckpointStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+	return &FileCheckpointStore{dir: dir}, nil
+}
+
+// Load reads the checkpoint's file
+func (f *FileCheckpointStore) Load(ctx context.Context, id string) (*Checkpoint, error) {
+	path, err := jsonFilePath(f.dir, id)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	data, err := os.ReadFile(path)
+	f.mu.Unlock()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrCheckpointNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoint %s: %w", id, err)
+	}
+	return &checkpoint, nil
+}
+
+// Save writes the checkpoint's file, replacing it atomically
+func (f *FileCheckpointStore) Save(ctx context.Context, checkpoint *Checkpoint) error {
+	path, err := jsonFilePath(f.dir, checkpoint.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint %s: %w", checkpoint.ID, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return writeFileAtomic(path, data)
+}
+
+// Delete removes the checkpoint's file, if it exists
+func (f *FileCheckpointStore) Delete(ctx context.Context, id string) error {
+	path, err := jsonFilePath(f.dir, id)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
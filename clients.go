@@ -0,0 +1,238 @@
+package swarmgo
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/prathyushnallamothu/swarmgo/llm"
+)
+
+// providerAPIKeyEnv names the environment variable holding each provider's API key.
+// It is used when an agent needs a client for a provider the Swarm has no key for.
+var providerAPIKeyEnv = map[llm.LLMProvider]string{
+	llm.OpenAI:   "OPENAI_API_KEY",
+	llm.Claude:   "ANTHROPIC_API_KEY",
+	llm.Gemini:   "GEMINI_API_KEY",
+	llm.DeepSeek: "DEEPSEEK_API_KEY",
+}
+
+// ErrUnsupportedClientConfig is returned for an agent whose ClientConfig sets something
+// its provider's client can't apply
+var ErrUnsupportedClientConfig = errors.New("client config not supported by provider")
+
+// clientKey identifies the settings an LLM client was built with
+type clientKey struct {
+	provider           llm.LLMProvider
+	registered         bool // Client set with RegisterClient
+	authToken          string
+	baseURL            string
+	orgID              string
+	assistantVersion   string
+	httpClient         *http.Client
+	emptyMessagesLimit uint
+}
+
+// newClientKey returns the key of the client for provider and an agent's config, or an
+// error if the config sets anything the provider's client doesn't support
+func newClientKey(provider llm.LLMProvider, config *ClientConfig) (clientKey, error) {
+	key := clientKey{provider: provider}
+	if config == nil {
+		return key, nil
+	}
+	key.authToken = config.AuthToken
+	key.baseURL = config.BaseURL
+	key.orgID = config.OrgID
+	key.assistantVersion = config.AssistantVersion
+	key.httpClient = config.HTTPClient
+	key.emptyMessagesLimit = config.EmptyMessagesLimit
+
+	var unsupported []string
+	if config.APIVersion != "" {
+		unsupported = append(unsupported, "APIVersion")
+	}
+	if len(config.Options) > 0 {
+		unsupported = append(unsupported, "Options")
+	}
+	if provider != llm.OpenAI {
+		if key.orgID != "" {
+			unsupported = append(unsupported, "OrgID")
+		}
+		if key.assistantVersion != "" {
+			unsupported = append(unsupported, "AssistantVersion")
+		}
+		if key.emptyMessagesLimit != 0 {
+			unsupported = append(unsupported, "EmptyMessagesLimit")
+		}
+		if key.httpClient != nil && provider != llm.Claude {
+			unsupported = append(unsupported, "HTTPClient")
+		}
+		if key.baseURL != "" && provider != llm.Claude && provider != llm.Ollama {
+			unsupported = append(unsupported, "BaseURL")
+		}
+	}
+	if len(unsupported) > 0 {
+		return key, fmt.Errorf("%w: %s can't apply %s", ErrUnsupportedClientConfig, provider, strings.Join(unsupported, ", "))
+	}
+	return key, nil
+}
+
+// clientSettings returns the config an agent's client is built from. A config that only
+// maps model names, which modelFor applies, leaves the agent on its provider's usual client.
+func clientSettings(config *ClientConfig) *ClientConfig {
+	if config == nil || config.ModelMapperFunc == nil {
+		return config
+	}
+	if config.Provider == "" && config.AuthToken == "" && config.BaseURL == "" && config.OrgID == "" &&
+		config.APIVersion == "" && config.AssistantVersion == "" && config.HTTPClient == nil &&
+		config.EmptyMessagesLimit == 0 && len(config.Options) == 0 {
+		return nil
+	}
+	return config
+}
+
+// newLLMClient creates a client for one of the built-in providers with the settings of
+// key, writing its calls to logger
+func newLLMClient(key clientKey, apiKey string, logger *slog.Logger) (llm.LLM, error) {
+	client, err := newProviderClient(key, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	if setter, ok := client.(llm.LoggerSetter); ok {
+		setter.SetLogger(logger)
+	}
+	return client, nil
+}
+
+// newProviderClient creates a client for one of the built-in providers with the settings of key
+func newProviderClient(key clientKey, apiKey string) (llm.LLM, error) {
+	switch key.provider {
+	case llm.OpenAI:
+		return llm.NewOpenAILLMWithOptions(apiKey, llm.OpenAIOptions{
+			BaseURL:            key.baseURL,
+			OrgID:              key.orgID,
+			AssistantVersion:   key.assistantVersion,
+			HTTPClient:         key.httpClient,
+			EmptyMessagesLimit: key.emptyMessagesLimit,
+		}), nil
+	case llm.Gemini:
+		client, err := llm.NewGeminiLLM(apiKey)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	case llm.Claude:
+		return llm.NewClaudeLLMWithOptions(apiKey, llm.ClaudeOptions{
+			BaseURL:    key.baseURL,
+			HTTPClient: key.httpClient,
+		}), nil
+	case llm.Ollama:
+		var client *llm.OllamaLLM
+		var err error
+		if key.baseURL != "" {
+			client, err = llm.NewOllamaLLMWithURL(key.baseURL)
+		} else {
+			client, err = llm.NewOllamaLLM()
+		}
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	case llm.DeepSeek:
+		return llm.NewDeepSeekLLM(apiKey), nil
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProvider, key.provider)
+	}
+}
+
+// ownClients returns the clients the Swarm built itself, rather than those it was given.
+// s.mu must be held.
+func (s *Swarm) ownClients() []llm.LLM {
+	var clients []llm.LLM
+	if s.client != nil && s.provider != "" {
+		clients = append(clients, s.client)
+	}
+	for key, client := range s.clients {
+		if !key.registered {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+// RegisterClient sets the client used by agents whose Provider is provider and that
+// have no Config of their own, e.g. to plug a custom implementation into a handoff
+func (s *Swarm) RegisterClient(provider llm.LLMProvider, client llm.LLM) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.clients == nil {
+		s.clients = make(map[clientKey]llm.LLM)
+	}
+	s.clients[clientKey{provider: provider, registered: true}] = client
+}
+
+// clientFor returns the LLM client for an agent. Agents without their own Provider or
+// Config use the Swarm's client. Other agents get a client built from their provider
+// and Config on first use, shared by every agent with the same settings, so a run can hand off
+// between providers. A Swarm created with a custom implementation uses it for every
+// agent without a Config, unless a client was registered for the agent's provider.
+func (s *Swarm) clientFor(agent *Agent) (llm.LLM, error) {
+	config := clientSettings(agent.Config)
+	provider := agent.Provider
+	if config != nil && config.Provider != "" {
+		provider = config.Provider
+	}
+	if provider == "" {
+		provider = s.provider
+	}
+
+	if provider == "" && config != nil {
+		return nil, fmt.Errorf("%w: agent %s has a client config without a provider", ErrInvalidProvider, agent.Name)
+	}
+	key, err := newClientKey(provider, config)
+	if err != nil {
+		return nil, fmt.Errorf("agent %s: %w", agent.Name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if config == nil && agent.Provider != "" {
+		if client, ok := s.clients[clientKey{provider: provider, registered: true}]; ok {
+			return client, nil
+		}
+	}
+	if client, ok := s.clients[key]; ok {
+		return client, nil
+	}
+
+	if config == nil && (provider == s.provider || s.provider == "") {
+		if !s.IsInitialized() {
+			return nil, ErrLLMClientNotReady
+		}
+		return s.client, nil
+	}
+
+	apiKey := key.authToken
+	if apiKey == "" && provider == s.provider {
+		apiKey = s.apiKey
+	}
+	if apiKey == "" {
+		apiKey = os.Getenv(providerAPIKeyEnv[provider])
+	}
+
+	client, err := newLLMClient(key, apiKey, s.loggerLocked())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s client for agent %s: %w", provider, agent.Name, err)
+	}
+
+	if s.clients == nil {
+		s.clients = make(map[clientKey]llm.LLM)
+	}
+	s.clients[key] = client
+	return client, nil
+}
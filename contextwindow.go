@@ -0,0 +1,259 @@
+package swarmgo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/prathyushnallamothu/swarmgo/llm"
+)
+
+// ContextStrategy defines what Run does when a prompt exceeds the model's token limit
+type ContextStrategy int
+
+const (
+	ContextFail       ContextStrategy = iota // Return ErrMessageTooLong without calling the model
+	ContextDropOldest                        // Drop the oldest turns until the prompt fits
+	ContextSummarize                         // Replace the oldest turns with a summary written by Config.SummaryModel
+)
+
+// messageOverheadTokens approximates the tokens providers add around each message
+const messageOverheadTokens = 4
+
+// summaryPrompt instructs the model that condenses dropped turns
+const summaryPrompt = "Summarize the following conversation between a user and an AI assistant. " +
+	"Keep every fact, decision, tool result and open question needed to continue the conversation. " +
+	"Reply with the summary only."
+
+// countTokens counts the tokens of a text with the configured counter, or
+// estimates it at four characters per token when none was set
+func (s *Swarm) countTokens(text string) int {
+	if s.tokenCounter != nil {
+		return s.tokenCounter(text)
+	}
+	return (len(text) + 3) / 4
+}
+
+// countMessageTokens counts the tokens a message takes up in the prompt
+func (s *Swarm) countMessageTokens(msg llm.Message) int {
+	tokens := messageOverheadTokens + s.countTokens(msg.Content) + s.countTokens(msg.Name)
+	for _, tc := range msg.ToolCalls {
+		tokens += s.countTokens(tc.Function.Name) + s.countTokens(tc.Function.Arguments)
+	}
+	return tokens
+}
+
+// countRequestTokens counts the tokens of a request's messages and tool definitions
+func (s *Swarm) countRequestTokens(req llm.ChatCompletionRequest) int {
+	tokens := 0
+	for _, msg := range req.Messages {
+		tokens += s.countMessageTokens(msg)
+	}
+	if len(req.Tools) > 0 {
+		if data, err := json.Marshal(req.Tools); err == nil {
+			tokens += s.countTokens(string(data))
+		}
+	}
+	return tokens
+}
+
+// tokenLimitFor returns the prompt token limit of a model from Config.TokenLimits, also
+// matching dated or latest versions of a configured model, e.g. gpt-4o-2024-08-06 for
+// gpt-4o. Zero means the model has no known limit, so the prompt isn't checked.
+func (s *Swarm) tokenLimitFor(model string) int {
+	if limit, ok := s.config.TokenLimits[model]; ok {
+		return limit
+	}
+
+	var best string
+	for name := range s.config.TokenLimits {
+		if isModelVersion(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	return s.config.TokenLimits[best]
+}
+
+// isModelVersion reports whether model is a dated or latest version of the model name,
+// but not another model sharing its prefix, such as gpt-4-turbo for gpt-4
+func isModelVersion(model, name string) bool {
+	suffix, ok := strings.CutPrefix(model, name)
+	if !ok || suffix == "" {
+		return false
+	}
+	return suffix == "-latest" || (len(suffix) > 1 && suffix[0] == '-' && suffix[1] >= '0' && suffix[1] <= '9')
+}
+
+// fitContextWindow checks the request against the model's token limit, less the tokens
+// kept for the completion, and applies Config.ContextStrategy when it doesn't fit. Only the request is changed, the run's
+// history keeps every message.
+func (s *Swarm) fitContextWindow(ctx context.Context, client llm.LLM, req llm.ChatCompletionRequest) (llm.ChatCompletionRequest, error) {
+	limit := s.tokenLimitFor(req.Model)
+	if limit <= 0 {
+		return req, nil
+	}
+
+	// Keep room for the completion, which shares the window with the prompt
+	reserved := req.MaxTokens
+	if reserved <= 0 {
+		reserved = s.config.MaxTokens
+	}
+	limit -= max(reserved, 0)
+
+	tokens := s.countRequestTokens(req)
+	if tokens <= limit {
+		return req, nil
+	}
+
+	tooLong := fmt.Errorf("%w: prompt needs about %d tokens, %s allows %d leaving %d for the completion",
+		ErrMessageTooLong, tokens, req.Model, max(limit, 0), reserved)
+	if s.config.ContextStrategy == ContextFail {
+		return req, tooLong
+	}
+
+	// Split off the leading system messages, which are always kept
+	system := 0
+	for system < len(req.Messages) && req.Messages[system].Role == llm.RoleSystem {
+		system++
+	}
+	units := groupTurns(req.Messages[system:])
+
+	// Drop whole turns from the front, always keeping the latest one
+	dropped := 0
+	fitted := req
+	for tokens > limit {
+		if dropped >= len(units)-1 {
+			return req, tooLong
+		}
+		for _, msg := range units[dropped] {
+			tokens -= s.countMessageTokens(msg)
+		}
+		dropped++
+	}
+
+	var kept []llm.Message
+	for _, unit := range units[dropped:] {
+		kept = append(kept, unit...)
+	}
+	var removed []llm.Message
+	for _, unit := range units[:dropped] {
+		removed = append(removed, unit...)
+	}
+
+	messages := append([]llm.Message{}, req.Messages[:system]...)
+	if s.config.ContextStrategy == ContextSummarize {
+		summary, usage, err := s.summarizeMessages(ctx, client, req.Model, removed)
+		addTurnUsage(ctx, usage)
+		if err != nil {
+			return req, fmt.Errorf("failed to summarize conversation: %w", err)
+		}
+		// The summary joins the last leading system message, since some providers only
+		// keep one system prompt and a second one would replace the instructions
+		summary = "Summary of the earlier conversation:\n" + summary
+		if len(messages) == 0 {
+			messages = append(messages, llm.Message{Role: llm.RoleSystem, Content: summary})
+			tokens += s.countMessageTokens(messages[0])
+		} else {
+			last := &messages[len(messages)-1]
+			tokens -= s.countMessageTokens(*last)
+			if last.Content != "" {
+				summary = last.Content + "\n\n" + summary
+			}
+			last.Content = summary
+			tokens += s.countMessageTokens(*last)
+		}
+
+		// Make room for the summary by dropping more turns if needed. These are
+		// lost rather than summarized, since the summary can't grow any further.
+		for tokens > limit {
+			if len(kept) == 0 || len(units)-dropped <= 1 {
+				return req, tooLong
+			}
+			for _, msg := range units[dropped] {
+				tokens -= s.countMessageTokens(msg)
+			}
+			kept = kept[len(units[dropped]):]
+			dropped++
+		}
+	}
+
+	fitted.Messages = append(messages, kept...)
+	s.log(ctx, false, slog.LevelDebug, "dropped turns to fit the token limit",
+		"model", req.Model, "dropped", dropped, "limit", limit)
+	return fitted, nil
+}
+
+// groupTurns splits messages into units that must be kept or dropped together:
+// an assistant message with tool calls and the tool results answering it form one unit
+func groupTurns(messages []llm.Message) [][]llm.Message {
+	var units [][]llm.Message
+	for i := 0; i < len(messages); {
+		j := i + 1
+		if messages[i].Role == llm.RoleAssistant && len(messages[i].ToolCalls) > 0 {
+			for j < len(messages) && isToolResult(messages[j]) {
+				j++
+			}
+		} else if isToolResult(messages[i]) {
+			// Results whose call was already dropped go together
+			for j < len(messages) && isToolResult(messages[j]) {
+				j++
+			}
+		}
+		units = append(units, messages[i:j])
+		i = j
+	}
+	return units
+}
+
+// isToolResult reports whether a message carries the result of a tool call
+func isToolResult(msg llm.Message) bool {
+	return msg.Role == llm.RoleTool || msg.Role == llm.RoleFunction
+}
+
+// summarizeMessages asks Config.SummaryModel, or the request's model when none
+// is set, to condense messages into a short summary. The summary is requested from
+// the agent's client, so SummaryModel must be a model of the agent's provider. The
+// call's usage is returned as a summary made in the current turn of the run.
+func (s *Swarm) summarizeMessages(ctx context.Context, client llm.LLM, model string, messages []llm.Message) (string, TurnUsage, error) {
+	if s.config.SummaryModel != "" {
+		model = s.config.SummaryModel
+	}
+
+	var transcript strings.Builder
+	for _, msg := range messages {
+		switch {
+		case isToolResult(msg):
+			fmt.Fprintf(&transcript, "tool %s: %s\n", msg.Name, msg.Content)
+		case len(msg.ToolCalls) > 0:
+			for _, tc := range msg.ToolCalls {
+				fmt.Fprintf(&transcript, "%s called %s(%s)\n", msg.Role, tc.Function.Name, tc.Function.Arguments)
+			}
+			if msg.Content != "" {
+				fmt.Fprintf(&transcript, "%s: %s\n", msg.Role, msg.Content)
+			}
+		default:
+			fmt.Fprintf(&transcript, "%s: %s\n", msg.Role, msg.Content)
+		}
+	}
+
+	resp, err := s.createChatCompletion(ctx, client, llm.ChatCompletionRequest{
+		Model: model,
+		Messages: []llm.Message{
+			{Role: llm.RoleSystem, Content: summaryPrompt},
+			{Role: llm.RoleUser, Content: transcript.String()},
+		},
+	})
+	if err != nil {
+		return "", TurnUsage{}, err
+	}
+
+	info, _ := ctx.Value(runInfoKey{}).(runInfo)
+	usage := s.turnUsage(info.turn, info.agent, model, resp.Usage)
+	usage.Summary = true
+	if len(resp.Choices) == 0 {
+		return "", usage, ErrNoChoicesInResp
+	}
+	return resp.Choices[0].Message.Content, usage, nil
+}
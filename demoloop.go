@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"strings"
@@ -216,9 +217,6 @@ func RunDemoLoopWithConfig(client *Swarm, agent *Agent, config *DemoLoopConfig)
 			}
 
 			// Process the response and print it to the console
-			var lastAssistantMessage llm.Message
-			var functionMessages []llm.Message
-
 			// Display response messages
 			for _, msg := range response.Messages {
 				switch msg.Role {
@@ -231,15 +229,13 @@ func RunDemoLoopWithConfig(client *Swarm, agent *Agent, config *DemoLoopConfig)
 
 						printColoredText(config.ColorOutput, fmt.Sprintf("%s: ", name), "blue")
 						fmt.Println(msg.Content)
-						lastAssistantMessage = msg
 					}
-				case llm.RoleFunction:
+				case llm.RoleTool, llm.RoleFunction:
 					if config.ShowFunctionResults {
 						printColoredText(config.ColorOutput,
 							fmt.Sprintf("%s function result: ", msg.Name), "magenta")
 						fmt.Println(msg.Content)
 					}
-					functionMessages = append(functionMessages, msg)
 				}
 			}
 
@@ -256,10 +252,11 @@ func RunDemoLoopWithConfig(client *Swarm, agent *Agent, config *DemoLoopConfig)
 				}
 			}
 
-			// Add function results to history first, then the assistant message
-			messages = append(messages, functionMessages...)
-			if lastAssistantMessage.Content != "" {
-				messages = append(messages, lastAssistantMessage)
+			// Keep the turn's messages in order so tool results stay paired with their calls
+			messages = append(messages, response.Messages...)
+			if response.Handoff != nil && response.Handoff.Filtered {
+				// The next agent sees the history its handoff filter kept
+				messages = cloneMessages(response.Handoff.Messages)
 			}
 
 			// Handle agent transfer
@@ -271,7 +268,10 @@ func RunDemoLoopWithConfig(client *Swarm, agent *Agent, config *DemoLoopConfig)
 
 			// Save conversation history if enabled
 			if config.SaveHistory && config.HistoryFile != "" {
-				saveConversationHistory(messages, config.HistoryFile)
+				if err := saveConversationHistory(messages, config.HistoryFile); err != nil {
+					client.log(ctx, config.Debug, slog.LevelError, "failed to save conversation history",
+						"file", config.HistoryFile, "error", err)
+				}
 			}
 		}
 	}
@@ -308,15 +308,14 @@ func printColoredText(useColor bool, text, color string) {
 }
 
 // Helper function to save conversation history to a file
-func saveConversationHistory(messages []llm.Message, filePath string) {
+func saveConversationHistory(messages []llm.Message, filePath string) error {
 	data, err := json.MarshalIndent(messages, "", "  ")
 	if err != nil {
-		log.Printf("Error serializing conversation history: %v", err)
-		return
+		return fmt.Errorf("failed to serialize conversation history: %w", err)
 	}
 
-	err = os.WriteFile(filePath, data, 0644)
-	if err != nil {
-		log.Printf("Error saving conversation history: %v", err)
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write conversation history: %w", err)
 	}
+	return nil
 }
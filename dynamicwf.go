@@ -2,7 +2,6 @@ package swarmgo
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -66,6 +65,11 @@ Output your analysis in the following JSON format:
 	}
 }
 
+// Use adds middleware around every LLM call made while planning and running workflows
+func (dwc *DynamicWorkflowCreator) Use(middleware ...llm.Middleware) {
+	dwc.swarm.Use(middleware...)
+}
+
 // RegisterBaseAgent adds a pre-defined agent template
 func (dwc *DynamicWorkflowCreator) RegisterBaseAgent(name string, agent *Agent) {
 	dwc.baseAgents[name] = agent
@@ -103,24 +107,17 @@ func (dwc *DynamicWorkflowCreator) CreateWorkflowFromTask(ctx context.Context, u
 		{Role: llm.RoleUser, Content: fmt.Sprintf("Analyze the following task and design an optimal workflow: %s", userTask)},
 	}
 
-	response, err := dwc.swarm.Run(ctx, dwc.taskAnalyzer, messages, nil, dwc.plannerModel, false, false, 1, false)
+	spec, _, err := RunStructured[WorkflowSpec](ctx, dwc.swarm, dwc.taskAnalyzer, messages, nil, dwc.plannerModel, 1, 2)
 	if err != nil {
 		return nil, fmt.Errorf("error analyzing task: %w", err)
 	}
 
-	// Extract and parse the JSON specification
-	assistantMsg := response.Messages[len(response.Messages)-1].Content
-	spec, err := extractJSONSpec(assistantMsg)
-	if err != nil {
-		return nil, fmt.Errorf("error extracting workflow specification: %w", err)
-	}
-
 	// Validate the workflow specification
-	if err := validateWorkflowSpec(spec); err != nil {
+	if err := validateWorkflowSpec(&spec); err != nil {
 		return nil, fmt.Errorf("invalid workflow specification: %w", err)
 	}
 
-	return spec, nil
+	return &spec, nil
 }
 
 // BuildWorkflow creates a concrete Workflow instance from a WorkflowSpec
@@ -144,7 +141,8 @@ func (dwc *DynamicWorkflowCreator) BuildWorkflow(spec *WorkflowSpec) (*Workflow,
 	}
 
 	// Create the workflow with properly initialized fields
-	workflow := NewWorkflow(dwc.apiKey, dwc.provider, workflowType)
+	// Share the creator's Swarm so its middleware applies to the workflow
+	workflow := newWorkflowWithSwarm(dwc.swarm, workflowType)
 
 	// Create and add agents
 	for _, agentSpec := range spec.Agents {
@@ -205,28 +203,6 @@ func (dwc *DynamicWorkflowCreator) CreateAndExecuteWorkflow(ctx context.Context,
 	return result, nil
 }
 
-// Helper function to extract JSON from text
-func extractJSONSpec(text string) (*WorkflowSpec, error) {
-	var spec WorkflowSpec
-
-	// Find JSON content between braces
-	startIdx := strings.Index(text, "{")
-	endIdx := strings.LastIndex(text, "}")
-
-	if startIdx == -1 || endIdx == -1 || endIdx <= startIdx {
-		return nil, errors.New("could not find valid JSON in the response")
-	}
-
-	jsonContent := text[startIdx : endIdx+1]
-
-	// Unmarshal JSON
-	if err := json.Unmarshal([]byte(jsonContent), &spec); err != nil {
-		return nil, fmt.Errorf("error parsing JSON specification: %w", err)
-	}
-
-	return &spec, nil
-}
-
 // Helper function to validate a workflow specification
 func validateWorkflowSpec(spec *WorkflowSpec) error {
 	if spec.MainGoal == "" {
@@ -751,7 +751,7 @@ Be meticulous, knowledgeable, and patient-focused.`,
 		// Check last message for patient record retrieval
 		for i := len(messages) - 1; i >= 0; i-- {
 			msg := messages[i]
-			if msg.Role == llm.RoleFunction &&
+			if (msg.Role == llm.RoleTool || msg.Role == llm.RoleFunction) &&
 				msg.Name == "fetch_patient_record" &&
 				strings.Contains(msg.Content, "Retrieved patient record") {
 				return "nurse", nil // Move to nurse after patient record retrieved
@@ -924,7 +924,7 @@ Be meticulous, knowledgeable, and patient-focused.`,
 					fmt.Printf("%d. Patient: %s\n", i+1, msg.Content)
 				case llm.RoleAssistant:
 					fmt.Printf("%d. Clinic Staff: %s\n", i+1, msg.Content)
-				case llm.RoleFunction:
+				case llm.RoleTool, llm.RoleFunction:
 					fmt.Printf("%d. System: [%s] %s\n", i+1, msg.Name, msg.Content)
 				}
 			}
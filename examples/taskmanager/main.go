@@ -508,7 +508,7 @@ Be thorough and organized in your tracking.`,
 				// Look for function call evidence
 				for _, msg := range messages {
 					// Task creation evidence
-					if msg.Role == llm.RoleFunction &&
+					if (msg.Role == llm.RoleTool || msg.Role == llm.RoleFunction) &&
 						msg.Name == "create_task" &&
 						strings.Contains(msg.Content, "Task created") {
 						fmt.Println("Found task creation evidence in message history")
@@ -521,7 +521,7 @@ Be thorough and organized in your tracking.`,
 					}
 
 					// Task assignment evidence
-					if msg.Role == llm.RoleFunction &&
+					if (msg.Role == llm.RoleTool || msg.Role == llm.RoleFunction) &&
 						msg.Name == "assign_task" &&
 						strings.Contains(msg.Content, "assigned to") {
 						fmt.Println("Found task assignment evidence in message history")
@@ -534,7 +534,7 @@ Be thorough and organized in your tracking.`,
 					}
 
 					// Task report evidence
-					if msg.Role == llm.RoleFunction &&
+					if (msg.Role == llm.RoleTool || msg.Role == llm.RoleFunction) &&
 						msg.Name == "generate_report" &&
 						strings.Contains(msg.Content, "TASK REPORT") {
 						fmt.Println("Found task report evidence in message history")
@@ -93,7 +93,7 @@ func main() {
 	}
 
 	// Run the agent with tool execution enabled
-	response, err := client.Run(ctx, mathAgent, messages, nil, "", false, true, 1, true)
+	response, err := client.Run(ctx, mathAgent, messages, nil, "", false, true, 5, true)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		return
@@ -113,12 +113,13 @@ func main() {
 		fmt.Printf("\nTool: %s\n", result.ToolName)
 		fmt.Printf("Arguments: %v\n", result.Args)
 		fmt.Printf("Result: %v\n", result.Result.Data)
-		
-		// You can also check if the tool call was successful
-		if result.Result.Success {
+		fmt.Printf("Duration: %v\n", result.Duration)
+
+		// You can also check if the tool call was successful, and where it failed
+		if result.ErrorKind == swarmgo.ToolErrorNone {
 			fmt.Printf("Status: Success\n")
 		} else {
-			fmt.Printf("Status: Failed\nError: %v\n", result.Result.Error)
+			fmt.Printf("Status: Failed (%s)\nError: %v\n", result.ErrorKind, result.Result.Error)
 		}
 	}
 }
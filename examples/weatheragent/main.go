@@ -203,7 +203,7 @@ Remember, you have FULL ACCESS to both weather data and email functionality. Use
 		fmt.Print("Thinking...")
 
 		// Execute agent with our context variables
-		response, err := client.Run(ctx, weatherAgent, messages, contextVariables, "", false, false, 1, true)
+		response, err := client.Run(ctx, weatherAgent, messages, contextVariables, "", false, false, 5, true)
 
 		// Clear indicator
 		fmt.Print("\r           \r")
@@ -220,13 +220,15 @@ Remember, you have FULL ACCESS to both weather data and email functionality. Use
 			case llm.RoleAssistant:
 				if msg.Content != "" {
 					fmt.Printf("%s: %s\n", weatherAgent.Name, msg.Content)
-					messages = append(messages, msg)
 				}
-			case llm.RoleFunction:
+			case llm.RoleTool:
 				fmt.Printf("%s function result: %s\n", msg.Name, msg.Content)
-				messages = append(messages, msg)
 			}
 		}
+
+		// Keep tool calls and their results together in the history, and the flags the tools set
+		messages = append(messages, response.Messages...)
+		contextVariables = response.ContextVariables
 	}
 
 	fmt.Println("Goodbye!")
@@ -0,0 +1,216 @@
+package swarmgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/prathyushnallamothu/swarmgo/llm"
+)
+
+// ErrGuardrailTripped matches every GuardrailError with errors.Is
+var ErrGuardrailTripped = errors.New("guardrail tripped")
+
+// GuardrailStage identifies which side of a run a guardrail checks
+type GuardrailStage string
+
+const (
+	GuardrailStageInput  GuardrailStage = "input"  // The latest user message, before the agent sees it
+	GuardrailStageOutput GuardrailStage = "output" // The agent's final reply, before it is returned
+)
+
+// GuardrailAction is a guardrail's decision about the content it checked
+type GuardrailAction string
+
+const (
+	GuardrailAllow   GuardrailAction = "allow"   // The content passes unchanged
+	GuardrailBlock   GuardrailAction = "block"   // The run stops with a GuardrailError
+	GuardrailRewrite GuardrailAction = "rewrite" // The content is replaced with GuardrailResult.Content
+	GuardrailFlag    GuardrailAction = "flag"    // The content passes, but the decision is recorded
+)
+
+// GuardrailCheck is the content passed to a guardrail
+type GuardrailCheck struct {
+	Stage            GuardrailStage
+	Agent            *Agent                 // Agent the content is sent to or comes from
+	Content          string                 // Text of the checked message
+	Messages         []llm.Message          // Conversation up to and including the checked message
+	ContextVariables map[string]interface{} // Context variables of the run, must not be modified
+}
+
+// GuardrailResult is a guardrail's verdict
+type GuardrailResult struct {
+	Action  GuardrailAction
+	Reason  string // Why the guardrail decided so, recorded in the GuardrailEvent
+	Content string // Replacement content for GuardrailRewrite
+}
+
+// Guardrail checks the input or output of an agent. An error returned by Check means
+// the guardrail itself failed and stops the run; a verdict is reported through the result.
+type Guardrail interface {
+	Name() string
+	Check(ctx context.Context, check GuardrailCheck) (GuardrailResult, error)
+}
+
+// guardrailFunc implements Guardrail with a function
+type guardrailFunc struct {
+	name string
+	fn   func(ctx context.Context, check GuardrailCheck) (GuardrailResult, error)
+}
+
+// NewGuardrail creates a guardrail from a plain Go function
+func NewGuardrail(name string, fn func(ctx context.Context, check GuardrailCheck) (GuardrailResult, error)) Guardrail {
+	return &guardrailFunc{name: name, fn: fn}
+}
+
+func (g *guardrailFunc) Name() string { return g.name }
+
+func (g *guardrailFunc) Check(ctx context.Context, check GuardrailCheck) (GuardrailResult, error) {
+	return g.fn(ctx, check)
+}
+
+// guardrailVerdict is the structured reply expected from a guardrail agent
+type guardrailVerdict struct {
+	Action  string `json:"action" jsonschema:"enum=allow,enum=block,enum=rewrite,enum=flag,description=Decision about the content"`
+	Reason  string `json:"reason" jsonschema:"description=Short explanation of the decision"`
+	Content string `json:"content,omitempty" jsonschema:"description=Replacement content when the action is rewrite"`
+}
+
+// agentGuardrail asks an agent for a verdict
+type agentGuardrail struct {
+	name  string
+	swarm *Swarm
+	agent *Agent
+}
+
+// NewAgentGuardrail creates a guardrail that asks agent, typically running on a
+// cheaper model, to judge the content. The agent's instructions describe the policy;
+// it replies with an action, a reason and, for rewrites, the replacement content.
+func NewAgentGuardrail(name string, swarm *Swarm, agent *Agent) Guardrail {
+	return &agentGuardrail{name: name, swarm: swarm, agent: agent}
+}
+
+func (g *agentGuardrail) Name() string { return g.name }
+
+func (g *agentGuardrail) Check(ctx context.Context, check GuardrailCheck) (GuardrailResult, error) {
+	prompt := fmt.Sprintf("Check the following %s of the agent %q and decide whether to allow, block, rewrite or flag it.\n\n%s",
+		check.Stage, check.Agent.Name, check.Content)
+	verdict, _, err := RunStructured[guardrailVerdict](ctx, g.swarm, g.agent,
+		[]llm.Message{{Role: llm.RoleUser, Content: prompt}}, nil, "", 1, 1)
+	if err != nil {
+		return GuardrailResult{}, err
+	}
+
+	action := GuardrailAction(verdict.Action)
+	switch action {
+	case GuardrailAllow, GuardrailBlock, GuardrailRewrite, GuardrailFlag:
+	default:
+		return GuardrailResult{}, fmt.Errorf("unknown guardrail action %q", verdict.Action)
+	}
+	return GuardrailResult{Action: action, Reason: verdict.Reason, Content: verdict.Content}, nil
+}
+
+// GuardrailEvent records a guardrail that did anything but allow its content
+type GuardrailEvent struct {
+	Guardrail string // Name of the guardrail
+	Stage     GuardrailStage
+	Agent     string // Agent the content was sent to or came from
+	Turn      int    // Turn of the run, 0 for input guardrails
+	Action    GuardrailAction
+	Reason    string
+	Original  string // Content before a rewrite
+}
+
+// GuardrailError is returned when a guardrail blocks a run
+type GuardrailError struct {
+	Event GuardrailEvent
+}
+
+func (e *GuardrailError) Error() string {
+	return fmt.Sprintf("%s guardrail %s blocked agent %s: %s", e.Event.Stage, e.Event.Guardrail, e.Event.Agent, e.Event.Reason)
+}
+
+func (e *GuardrailError) Is(target error) bool {
+	return target == ErrGuardrailTripped
+}
+
+// applyGuardrails runs guardrails in order over history[index], rewriting it in place and
+// recording every non-allow verdict in response. Each guardrail sees the content left by
+// the previous one. A block stops at once with a GuardrailError.
+func (s *Swarm) applyGuardrails(
+	ctx context.Context,
+	stage GuardrailStage,
+	guardrails []Guardrail,
+	agent *Agent,
+	history []llm.Message,
+	index int,
+	contextVariables map[string]interface{},
+	response *Response,
+	debug bool,
+) error {
+	for _, g := range guardrails {
+		check := GuardrailCheck{
+			Stage:            stage,
+			Agent:            agent,
+			Content:          history[index].Content,
+			Messages:         history[:index+1],
+			ContextVariables: contextVariables,
+		}
+		result, err := g.Check(ctx, check)
+		if err != nil {
+			return fmt.Errorf("%s guardrail %s failed: %w", stage, g.Name(), err)
+		}
+		switch result.Action {
+		case "", GuardrailAllow:
+			continue
+		case GuardrailBlock, GuardrailRewrite, GuardrailFlag:
+		default:
+			return fmt.Errorf("%s guardrail %s returned unknown action %q", stage, g.Name(), result.Action)
+		}
+
+		event := GuardrailEvent{
+			Guardrail: g.Name(),
+			Stage:     stage,
+			Agent:     agent.Name,
+			Turn:      response.Turns,
+			Action:    result.Action,
+			Reason:    result.Reason,
+		}
+		if result.Action == GuardrailRewrite {
+			event.Original = history[index].Content
+			history[index].Content = result.Content
+		}
+		response.GuardrailEvents = append(response.GuardrailEvents, event)
+		s.emit(ctx, RunEvent{Type: EventGuardrail, Guardrail: &event})
+		s.log(ctx, debug, slog.LevelInfo, "guardrail triggered", "stage", stage,
+			"guardrail", g.Name(), "action", result.Action, "reason", result.Reason)
+
+		if result.Action == GuardrailBlock {
+			return &GuardrailError{Event: event}
+		}
+	}
+	return nil
+}
+
+// guardrailStop ends a run stopped by a guardrail. A block returns the response so far,
+// with the blocked reply left out of messages; a failing guardrail is like any other error.
+func guardrailStop(response Response, messages []llm.Message, err error) (Response, error) {
+	var tripped *GuardrailError
+	if !errors.As(err, &tripped) {
+		return Response{}, err
+	}
+	response.Messages = messages
+	response.StopReason = StopGuardrail
+	return response, err
+}
+
+// lastUserMessage returns the index of the latest user message, or -1 if there is none
+func lastUserMessage(messages []llm.Message) int {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == llm.RoleUser {
+			return i
+		}
+	}
+	return -1
+}
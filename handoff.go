@@ -0,0 +1,246 @@
+package swarmgo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/prathyushnallamothu/swarmgo/llm"
+)
+
+// Handoff lets an agent transfer the conversation to another agent through a generated
+// transfer_to_<name> tool
+type Handoff struct {
+	Agent         *Agent                 // Agent to hand off to
+	ToolName      string                 // Name of the generated tool, transfer_to_<agent name> by default
+	Description   string                 // Description of the generated tool, shown to the model
+	InputSchema   map[string]interface{} // Parameters the model passes along, e.g. a reason, none by default
+	HistoryFilter HistoryFilter          // What the next agent sees of the conversation, everything by default
+}
+
+// HandoffInput is what a history filter works on
+type HandoffInput struct {
+	Swarm     *Swarm                 // Swarm running the handoff
+	FromAgent *Agent                 // Agent handing off
+	ToAgent   *Agent                 // Agent taking over
+	Args      map[string]interface{} // Arguments of the handoff's tool call
+	History   []llm.Message          // Conversation up to and including the handoff's tool result
+}
+
+// HistoryFilter selects the messages the next agent continues with after a handoff
+type HistoryFilter func(ctx context.Context, input HandoffInput) ([]llm.Message, error)
+
+// HandoffRecord describes the handoff that ended a run
+type HandoffRecord struct {
+	FromAgent  string                 // Agent that handed off
+	ToAgent    string                 // Agent taking over, also set as Response.Agent
+	ToolName   string                 // Tool that made the handoff
+	ToolCallID string                 // ID of the tool call that made the handoff
+	Args       map[string]interface{} // Arguments of the tool call, e.g. the reason
+	Turn       int                    // Turn the handoff happened in
+	Messages   []llm.Message          // Conversation to continue the next agent with, after the history filter
+	Filtered   bool                   // Whether Messages went through the handoff's HistoryFilter
+}
+
+// WithHandoffs adds agents this agent can hand off to
+func (a *Agent) WithHandoffs(handoffs ...Handoff) *Agent {
+	a.Handoffs = append(a.Handoffs, handoffs...)
+	return a
+}
+
+// HandoffTo creates a handoff to agent with the default tool name and description
+func HandoffTo(agent *Agent) Handoff {
+	return Handoff{Agent: agent}
+}
+
+// toolName returns the name of the handoff's tool
+func (h *Handoff) toolName() string {
+	if h.ToolName != "" {
+		return h.ToolName
+	}
+	return "transfer_to_" + snakeCase(h.Agent.Name)
+}
+
+// function builds the tool that performs the handoff
+func (h *Handoff) function() AgentFunction {
+	description := h.Description
+	if description == "" {
+		description = fmt.Sprintf("Hand off the conversation to the %s agent.", h.Agent.Name)
+	}
+	parameters := h.InputSchema
+	if parameters == nil {
+		parameters = map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{},
+		}
+	}
+
+	target := h.Agent
+	return AgentFunction{
+		Name:        h.toolName(),
+		Description: description,
+		Parameters:  parameters,
+		Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+			return Result{
+				Success: true,
+				Data:    fmt.Sprintf("Transferred to %s.", target.Name),
+				Agent:   target,
+			}
+		},
+	}
+}
+
+// tools returns the agent's functions followed by the tools of its handoffs
+func (a *Agent) tools() []AgentFunction {
+	if len(a.Handoffs) == 0 {
+		return a.Functions
+	}
+	tools := make([]AgentFunction, 0, len(a.Functions)+len(a.Handoffs))
+	tools = append(tools, a.Functions...)
+	for i := range a.Handoffs {
+		if a.Handoffs[i].Agent != nil {
+			tools = append(tools, a.Handoffs[i].function())
+		}
+	}
+	return tools
+}
+
+// findHandoff returns the agent's handoff with the given tool name, or nil if it has none
+func findHandoff(agent *Agent, toolName string) *Handoff {
+	for i := range agent.Handoffs {
+		if agent.Handoffs[i].Agent != nil && agent.Handoffs[i].toolName() == toolName {
+			return &agent.Handoffs[i]
+		}
+	}
+	return nil
+}
+
+// recordHandoff describes the handoff made by toolResult, applying the history filter
+// of the agent's matching Handoff. Handoffs made by plain functions keep the full history.
+// The run ends with the handoff; RunSession and RunDemoLoop continue the next agent with
+// the record's Messages, callers of Run pass them on themselves.
+func (s *Swarm) recordHandoff(
+	ctx context.Context,
+	from *Agent,
+	toolResult ToolResult,
+	history []llm.Message,
+	turn int,
+) (*HandoffRecord, error) {
+	args, _ := toolResult.Args.(map[string]interface{})
+	record := &HandoffRecord{
+		FromAgent:  from.Name,
+		ToAgent:    toolResult.Result.Agent.Name,
+		ToolName:   toolResult.ToolName,
+		ToolCallID: toolResult.ToolCallID,
+		Args:       args,
+		Turn:       turn,
+		Messages:   cloneMessages(history),
+	}
+
+	h := findHandoff(from, toolResult.ToolName)
+	if h == nil || h.HistoryFilter == nil {
+		return record, nil
+	}
+	filtered, err := h.HistoryFilter(ctx, HandoffInput{
+		Swarm:     s,
+		FromAgent: from,
+		ToAgent:   toolResult.Result.Agent,
+		Args:      args,
+		History:   record.Messages,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("history filter of handoff to %s failed: %w", record.ToAgent, err)
+	}
+	record.Messages = filtered
+	record.Filtered = true
+	return record, nil
+}
+
+// KeepLastMessages keeps the last n messages, plus the tool call of any tool result
+// at the start so the next agent never sees a result without its call
+func KeepLastMessages(n int) HistoryFilter {
+	return func(ctx context.Context, input HandoffInput) ([]llm.Message, error) {
+		start := len(input.History) - n
+		if start < 0 {
+			start = 0
+		}
+		for start > 0 && isToolResult(input.History[start]) {
+			start--
+		}
+		return input.History[start:], nil
+	}
+}
+
+// StripToolCalls removes tool calls and their results, keeping the conversation's text
+func StripToolCalls(ctx context.Context, input HandoffInput) ([]llm.Message, error) {
+	var messages []llm.Message
+	for _, msg := range input.History {
+		if isToolResult(msg) {
+			continue
+		}
+		if len(msg.ToolCalls) > 0 {
+			if msg.Content == "" {
+				continue
+			}
+			msg.ToolCalls = nil
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// SummarizeHistory replaces the conversation with a summary written by Config.SummaryModel,
+// or the next agent's model when none is set. The latest user message is kept as it was.
+// The summary's usage counts towards the run making the handoff.
+func SummarizeHistory(ctx context.Context, input HandoffInput) ([]llm.Message, error) {
+	s := input.Swarm
+	client, err := s.clientFor(input.ToAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	summarized := input.History
+	var last []llm.Message
+	if i := lastUserMessage(input.History); i >= 0 {
+		last = []llm.Message{input.History[i]}
+		summarized = append(cloneMessages(input.History[:i]), input.History[i+1:]...)
+	}
+	summary, usage, err := s.summarizeMessages(ctx, client, s.modelFor(input.ToAgent, ""), summarized)
+	addTurnUsage(ctx, usage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize conversation: %w", err)
+	}
+
+	messages := []llm.Message{{
+		Role:    llm.RoleSystem,
+		Content: fmt.Sprintf("Summary of the conversation before %s handed it off:\n%s", input.FromAgent.Name, summary),
+	}}
+	return append(messages, last...), nil
+}
+
+// snakeCase converts an agent name such as "BillingAgent" or "Billing Agent" to "billing_agent"
+func snakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		switch {
+		case r > unicode.MaxASCII:
+			continue
+		case unicode.IsUpper(r):
+			if i > 0 && b.Len() > 0 && !strings.HasSuffix(b.String(), "_") &&
+				(unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+					(i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		default:
+			if b.Len() > 0 && !strings.HasSuffix(b.String(), "_") {
+				b.WriteByte('_')
+			}
+		}
+	}
+	return strings.TrimSuffix(b.String(), "_")
+}
@@ -0,0 +1,196 @@
+package swarmgo
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prathyushnallamothu/swarmgo/llm"
+)
+
+// RunEventType identifies a lifecycle event
+type RunEventType string
+
+const (
+	EventRunStart              RunEventType = "run_start"               // A run started
+	EventRunEnd                RunEventType = "run_end"                 // A run ended, RunResult or Err is set
+	EventLLMRequest            RunEventType = "llm_request"             // A request is about to be sent to the model
+	EventLLMResponse           RunEventType = "llm_response"            // The model answered a request, or failed to
+	EventToolStart             RunEventType = "tool_start"              // A tool call is about to run
+	EventToolEnd               RunEventType = "tool_end"                // A tool call finished
+	EventHandoff               RunEventType = "handoff"                 // The conversation was handed to another agent
+	EventRetry                 RunEventType = "retry"                   // A failed call is about to be retried
+	EventContextVariableChange RunEventType = "context_variable_change" // A tool set or deleted a context variable
+	EventWorkflowTransition    RunEventType = "workflow_transition"     // A workflow moved between agents
+	EventGraph                 RunEventType = "graph"                   // A graph fired one of its events
+	EventGuardrail             RunEventType = "guardrail"               // A guardrail blocked, rewrote or flagged content
+)
+
+// RunEvent describes something that happened during a run. Besides the common
+// fields, only the fields relevant to the event's Type are set.
+type RunEvent struct {
+	Type        RunEventType
+	RunID       string    // ID of the run, workflow or graph execution the event belongs to
+	ParentRunID string    // ID of the enclosing run, e.g. the graph execution running an agent node
+	Turn        int       // Turn of the run, or step of a workflow or graph, starting at 1
+	Agent       string    // Agent active when the event happened
+	Time        time.Time // When the event happened
+
+	Request    *llm.ChatCompletionRequest  // EventLLMRequest, EventLLMResponse
+	Response   *llm.ChatCompletionResponse // EventLLMResponse
+	ToolCall   *llm.ToolCall               // EventToolStart, EventToolEnd, EventRetry of a tool call
+	ToolResult *ToolResult                 // EventToolEnd
+	RunResult  *Response                   // EventRunEnd
+	Guardrail  *GuardrailEvent             // EventGuardrail
+
+	FromAgent string // EventHandoff, EventWorkflowTransition
+	ToAgent   string // EventHandoff, EventWorkflowTransition
+	Reason    string // EventWorkflowTransition
+
+	Attempt int           // EventRetry: number of the attempt about to be made, starting at 2
+	Delay   time.Duration // EventRetry: wait before the attempt
+
+	Key           string      // EventContextVariableChange
+	Value         interface{} // EventContextVariableChange: new value, nil when deleted
+	PreviousValue interface{} // EventContextVariableChange: value before the change, nil when added
+	Deleted       bool        // EventContextVariableChange: whether the variable was deleted
+
+	Name  string     // EventGraph: name of the graph event
+	State GraphState // EventGraph: state of the graph when the event fired
+
+	Err error // Error of a failed run, model call, tool call or retried attempt
+}
+
+// RunHooks observes the lifecycle events of runs. OnEvent is called synchronously,
+// from the goroutine of the tool call for parallel tool calls, so it must be safe
+// for concurrent use and should return quickly.
+type RunHooks interface {
+	OnEvent(ctx context.Context, event RunEvent)
+}
+
+// RunHooksFunc adapts a function to the RunHooks interface
+type RunHooksFunc func(ctx context.Context, event RunEvent)
+
+// OnEvent calls f
+func (f RunHooksFunc) OnEvent(ctx context.Context, event RunEvent) {
+	f(ctx, event)
+}
+
+// AddHooks registers hooks that observe every run of the Swarm
+func (s *Swarm) AddHooks(hooks ...RunHooks) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, hooks...)
+}
+
+// runHooksKey and runInfoKey are the context keys of per-call hooks and the current run
+type (
+	runHooksKey struct{}
+	runInfoKey  struct{}
+)
+
+// WithRunHooks returns a context that adds hooks to the runs it is passed to,
+// including runs nested inside them
+func WithRunHooks(ctx context.Context, hooks ...RunHooks) context.Context {
+	existing, _ := ctx.Value(runHooksKey{}).([]RunHooks)
+	combined := append(append([]RunHooks{}, existing...), hooks...)
+	return context.WithValue(ctx, runHooksKey{}, combined)
+}
+
+// runInfo identifies the run, turn and agent the events emitted under a context belong to
+type runInfo struct {
+	id     string
+	parent string
+	turn   int
+	agent  string
+}
+
+// startRunInfo returns a context for a new run with a fresh ID, nested under the
+// run of ctx if there is one
+func startRunInfo(ctx context.Context, agent string) context.Context {
+	info := runInfo{id: uuid.New().String(), agent: agent}
+	if parent, ok := ctx.Value(runInfoKey{}).(runInfo); ok {
+		info.parent = parent.id
+	}
+	return context.WithValue(ctx, runInfoKey{}, info)
+}
+
+// withTurn returns a context whose events belong to the given turn and agent
+func withTurn(ctx context.Context, turn int, agent string) context.Context {
+	info, _ := ctx.Value(runInfoKey{}).(runInfo)
+	info.turn = turn
+	info.agent = agent
+	return context.WithValue(ctx, runInfoKey{}, info)
+}
+
+// RunIDFromContext returns the ID of the run a context belongs to, e.g. inside a
+// tool or middleware, or an empty string outside of a run
+func RunIDFromContext(ctx context.Context) string {
+	info, _ := ctx.Value(runInfoKey{}).(runInfo)
+	return info.id
+}
+
+// emit sends an event to the Swarm's hooks and the hooks of ctx, filling in the
+// run ID, turn and agent from ctx where the event doesn't set them. A nil Swarm
+// only sends it to the hooks of ctx.
+func (s *Swarm) emit(ctx context.Context, event RunEvent) {
+	callHooks, _ := ctx.Value(runHooksKey{}).([]RunHooks)
+	var hooks []RunHooks
+	if s != nil {
+		s.mu.Lock()
+		hooks = s.hooks
+		s.mu.Unlock()
+	}
+	if len(hooks) == 0 && len(callHooks) == 0 {
+		return
+	}
+
+	info, _ := ctx.Value(runInfoKey{}).(runInfo)
+	if event.RunID == "" {
+		event.RunID = info.id
+		event.ParentRunID = info.parent
+	}
+	if event.Turn == 0 {
+		event.Turn = info.turn
+	}
+	if event.Agent == "" {
+		event.Agent = info.agent
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	for _, h := range hooks {
+		h.OnEvent(ctx, event)
+	}
+	for _, h := range callHooks {
+		h.OnEvent(ctx, event)
+	}
+}
+
+// emitContextVariableChanges reports the keys that differ between before and after, in key order
+func (s *Swarm) emitContextVariableChanges(ctx context.Context, before, after map[string]interface{}) {
+	keys := make([]string, 0, len(after)+len(before))
+	for k := range after {
+		keys = append(keys, k)
+	}
+	for k := range before {
+		if _, ok := after[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		old, existed := before[k]
+		value, exists := after[k]
+		switch {
+		case !exists:
+			s.emit(ctx, RunEvent{Type: EventContextVariableChange, Key: k, PreviousValue: old, Deleted: true})
+		case !existed || !reflect.DeepEqual(old, value):
+			s.emit(ctx, RunEvent{Type: EventContextVariableChange, Key: k, Value: value, PreviousValue: old})
+		}
+	}
+}
@@ -3,8 +3,11 @@ package llm
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
+	"log/slog"
+	"net/http"
+	"time"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/anthropics/anthropic-sdk-go/option"
@@ -15,6 +18,7 @@ import (
 
 // ClaudeLLM implements the LLM interface for Anthropic's Claude
 type ClaudeLLM struct {
+	callLogger
 	client *anthropic.Client
 }
 
@@ -25,67 +29,87 @@ func NewClaudeLLM(apiKey string) *ClaudeLLM {
 	return &ClaudeLLM{client: client}
 }
 
-// convertToClaudeMessages converts our generic Message type to Claude's message format
+// ClaudeOptions contains optional settings of a Claude client. Zero values keep the defaults.
+type ClaudeOptions struct {
+	BaseURL    string       // Base URL of the API, e.g. of a proxy
+	HTTPClient *http.Client // Client the requests are sent with
+	Logger     *slog.Logger // Logger the client writes its calls to, see SetLogger
+}
+
+// NewClaudeLLMWithOptions creates a new Claude LLM client with custom settings
+func NewClaudeLLMWithOptions(apiKey string, opts ClaudeOptions) *ClaudeLLM {
+	requestOptions := []option.RequestOption{option.WithAPIKey(apiKey)}
+	if opts.BaseURL != "" {
+		requestOptions = append(requestOptions, option.WithBaseURL(opts.BaseURL))
+	}
+	if opts.HTTPClient != nil {
+		requestOptions = append(requestOptions, option.WithHTTPClient(opts.HTTPClient))
+	}
+	client := &ClaudeLLM{client: anthropic.NewClient(requestOptions...)}
+	client.SetLogger(opts.Logger)
+	return client
+}
+
+// convertToClaudeMessages converts our generic Message type to Claude's message format.
+// Assistant tool calls become tool_use blocks, and the tool results that follow them
+// are grouped into a single user message of tool_result blocks keyed by tool call ID.
 func convertToClaudeMessages(messages []Message) []anthropic.MessageParam {
 	var claudeMessages []anthropic.MessageParam
+	var pendingResults []anthropic.ContentBlockParamUnion
 
-	// First pass: collect tool calls and their results
-	toolCallMap := make(map[string]string) // map[tool_id]result
-	for _, msg := range messages {
-		if msg.Role == RoleFunction {
-			toolCallMap[msg.Name] = msg.Content
+	// Tool calls seen so far, used to link legacy function results that only carry a name
+	callIDsByName := make(map[string][]string)
+
+	flushResults := func() {
+		if len(pendingResults) > 0 {
+			claudeMessages = append(claudeMessages, anthropic.NewUserMessage(pendingResults...))
+			pendingResults = nil
 		}
 	}
 
-	for i, msg := range messages {
+	for _, msg := range messages {
 		switch msg.Role {
 		case RoleSystem:
 			// Claude handles system messages differently - we'll add it as a system prompt
 			continue
+		case RoleTool, RoleFunction:
+			toolCallID := msg.ToolCallID
+			if toolCallID == "" {
+				ids := callIDsByName[msg.Name]
+				if len(ids) == 0 {
+					// A result we can't link to a tool_use block would be rejected
+					continue
+				}
+				toolCallID = ids[0]
+				callIDsByName[msg.Name] = ids[1:]
+			}
+			pendingResults = append(pendingResults,
+				anthropic.NewToolResultBlock(toolCallID, msg.Content, msg.IsError))
 		case RoleUser:
+			flushResults()
 			claudeMessages = append(claudeMessages, anthropic.NewUserMessage(anthropic.NewTextBlock(msg.Content)))
 		case RoleAssistant:
-			// Skip assistant messages that are the last message when there are tool calls
-			if len(msg.ToolCalls) > 0 && i == len(messages)-1 {
-				continue
+			flushResults()
+			var blocks []anthropic.ContentBlockParamUnion
+			if msg.Content != "" {
+				blocks = append(blocks, anthropic.NewTextBlock(msg.Content))
 			}
-
-			// If there are tool calls, we need to split this into multiple messages
-			if len(msg.ToolCalls) > 0 {
-				// First message: just the text content if any
-				if msg.Content != "" {
-					claudeMessages = append(claudeMessages, anthropic.NewAssistantMessage(anthropic.NewTextBlock(msg.Content)))
-				}
-
-				// Then for each tool call, create a tool use message
-				for _, tc := range msg.ToolCalls {
-					var args interface{}
-					if tc.Function.Arguments == "" {
-						args = map[string]interface{}{}
-					} else if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
-						continue
-					}
-					// Create the tool use message
-					toolMsg := anthropic.NewAssistantMessage(
-						anthropic.NewToolUseBlockParam(tc.ID, tc.Function.Name, args))
-					claudeMessages = append(claudeMessages, toolMsg)
-
-					// Add the tool result immediately after if available
-					if result, ok := toolCallMap[tc.Function.Name]; ok {
-						toolResult := anthropic.NewUserMessage(
-							anthropic.NewToolResultBlock(tc.ID, result, false))
-						claudeMessages = append(claudeMessages, toolResult)
-					}
+			for _, tc := range msg.ToolCalls {
+				var args interface{}
+				if tc.Function.Arguments == "" {
+					args = map[string]interface{}{}
+				} else if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
+					args = map[string]interface{}{}
 				}
-			} else {
-				// No tool calls, just add the content
-				claudeMessages = append(claudeMessages, anthropic.NewAssistantMessage(anthropic.NewTextBlock(msg.Content)))
+				blocks = append(blocks, anthropic.NewToolUseBlockParam(tc.ID, tc.Function.Name, args))
+				callIDsByName[tc.Function.Name] = append(callIDsByName[tc.Function.Name], tc.ID)
+			}
+			if len(blocks) > 0 {
+				claudeMessages = append(claudeMessages, anthropic.NewAssistantMessage(blocks...))
 			}
-		case RoleFunction:
-			// Function messages are handled with tool calls
-			continue
 		}
 	}
+	flushResults()
 
 	return claudeMessages
 }
@@ -141,6 +165,62 @@ func convertToClaudeTools(tools []Tool) []anthropic.ToolParam {
 	return claudeTools
 }
 
+// applyClaudeResponseFormat asks Claude for the response format through a tool whose input
+// schema is the format's schema. Claude is forced to call it unless the agent has tools
+// of its own, in which case it must call one of the tools.
+func applyClaudeResponseFormat(params *anthropic.MessageNewParams, req ChatCompletionRequest) {
+	format := req.ResponseFormat
+	if format == nil {
+		return
+	}
+
+	description := format.Description
+	if description == "" {
+		description = "Reply with your final answer as a JSON object."
+	}
+	tools := append(convertToClaudeTools(req.Tools), anthropic.ToolParam{
+		Name:        anthropic.F(format.Name),
+		Description: anthropic.F(description),
+		InputSchema: anthropic.F[interface{}](format.Schema),
+	})
+	params.Tools = anthropic.F(tools)
+
+	if len(req.Tools) == 0 {
+		params.ToolChoice = anthropic.F[anthropic.ToolChoiceUnionParam](anthropic.ToolChoiceToolParam{
+			Type: anthropic.F(anthropic.ToolChoiceToolTypeTool),
+			Name: anthropic.F(format.Name),
+		})
+	} else {
+		params.ToolChoice = anthropic.F[anthropic.ToolChoiceUnionParam](anthropic.ToolChoiceAnyParam{
+			Type: anthropic.F(anthropic.ToolChoiceAnyTypeAny),
+		})
+	}
+}
+
+// extractClaudeResponseFormat turns a call to the response format's tool into the
+// message content, so it reads like a plain JSON reply. Other tool calls made along
+// with it are dropped, as the reply is final and they would never get a result.
+func extractClaudeResponseFormat(msg Message, format *ResponseFormat) Message {
+	if format == nil {
+		return msg
+	}
+
+	for _, tc := range msg.ToolCalls {
+		if tc.Function.Name == format.Name {
+			msg.Content = tc.Function.Arguments
+			msg.ToolCalls = nil
+			return msg
+		}
+	}
+	return msg
+}
+
+// SupportsStructuredOutput reports that Claude honors ResponseFormat through forced tool use.
+// Only CreateChatCompletion applies it, streams ignore it.
+func (c *ClaudeLLM) SupportsStructuredOutput() bool {
+	return true
+}
+
 // convertFromClaudeMessage converts Claude's message type to our generic Message type
 func convertFromClaudeMessage(msg anthropic.Message) Message {
 	var content string
@@ -169,8 +249,33 @@ func convertFromClaudeMessage(msg anthropic.Message) Message {
 	}
 }
 
+// convertClaudeError converts errors from the Anthropic client into an APIError
+func convertClaudeError(err error) error {
+	var claudeErr *anthropic.Error
+	if !errors.As(err, &claudeErr) {
+		return err
+	}
+
+	// The body looks like {"type": "error", "error": {"type": "rate_limit_error", "message": "..."}}
+	var body struct {
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	_ = json.Unmarshal([]byte(claudeErr.JSON.RawJSON()), &body)
+
+	apiErr := NewAPIError(Claude, claudeErr.StatusCode, body.Error.Type, body.Error.Message, err)
+	if claudeErr.Response != nil {
+		apiErr.RetryAfter = retryAfterFromHeader(claudeErr.Response.Header)
+	}
+	return apiErr
+}
+
 // CreateChatCompletion implements the LLM interface for Claude
-func (c *ClaudeLLM) CreateChatCompletion(ctx context.Context, req ChatCompletionRequest) (ChatCompletionResponse, error) {
+func (c *ClaudeLLM) CreateChatCompletion(ctx context.Context, req ChatCompletionRequest) (_ ChatCompletionResponse, err error) {
+	defer func(start time.Time) { c.logCall(ctx, Claude, "chat completion", req.Model, start, err) }(time.Now())
+
 	// Extract system message if present
 	var systemPrompt string
 	var nonSystemMessages []Message
@@ -207,15 +312,16 @@ func (c *ClaudeLLM) CreateChatCompletion(ctx context.Context, req ChatCompletion
 	if req.Temperature > 0 {
 		claudeReq.Temperature = anthropic.F(float64(req.Temperature))
 	}
+	applyClaudeResponseFormat(&claudeReq, req)
 
 	// Make request to Claude API
 	resp, err := c.client.Messages.New(ctx, claudeReq)
 	if err != nil {
-		return ChatCompletionResponse{}, fmt.Errorf("claude API error: %v", err)
+		return ChatCompletionResponse{}, convertClaudeError(err)
 	}
 
 	// Convert response
-	message := convertFromClaudeMessage(*resp)
+	message := extractClaudeResponseFormat(convertFromClaudeMessage(*resp), req.ResponseFormat)
 
 	return ChatCompletionResponse{
 		ID: resp.ID,
@@ -233,7 +339,9 @@ func (c *ClaudeLLM) CreateChatCompletion(ctx context.Context, req ChatCompletion
 }
 
 // CreateChatCompletionStream implements the LLM interface for Claude streaming
-func (c *ClaudeLLM) CreateChatCompletionStream(ctx context.Context, req ChatCompletionRequest) (ChatCompletionStream, error) {
+func (c *ClaudeLLM) CreateChatCompletionStream(ctx context.Context, req ChatCompletionRequest) (_ ChatCompletionStream, err error) {
+	defer func(start time.Time) { c.logCall(ctx, Claude, "chat completion stream", req.Model, start, err) }(time.Now())
+
 	// Extract system message if present
 	var systemPrompt string
 	var nonSystemMessages []Message
@@ -293,7 +401,7 @@ type claudeStreamWrapper struct {
 func (w *claudeStreamWrapper) Recv() (ChatCompletionResponse, error) {
 	if !w.stream.Next() {
 		if err := w.stream.Err(); err != nil {
-			return ChatCompletionResponse{}, err
+			return ChatCompletionResponse{}, convertClaudeError(err)
 		}
 		return ChatCompletionResponse{}, io.EOF
 	}
@@ -0,0 +1,27 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestExtractClaudeResponseFormat tests that the response format's tool call becomes the
+// content of the reply, without the other tool calls made along with it
+func TestExtractClaudeResponseFormat(t *testing.T) {
+	format := &ResponseFormat{Name: "weather"}
+	lookup := ToolCall{ID: "toolu_1", Type: "function", Function: ToolCallFunction{Name: "lookup", Arguments: `{}`}}
+	answer := ToolCall{ID: "toolu_2", Type: "function", Function: ToolCallFunction{Name: "weather", Arguments: `{"temp": 21}`}}
+
+	msg := extractClaudeResponseFormat(Message{Role: RoleAssistant, ToolCalls: []ToolCall{lookup, answer}}, format)
+	assert.Equal(t, `{"temp": 21}`, msg.Content)
+	assert.Empty(t, msg.ToolCalls)
+
+	// Without the format's tool call the reply keeps its calls
+	msg = extractClaudeResponseFormat(Message{Role: RoleAssistant, ToolCalls: []ToolCall{lookup}}, format)
+	assert.Empty(t, msg.Content)
+	assert.Equal(t, []ToolCall{lookup}, msg.ToolCalls)
+
+	msg = extractClaudeResponseFormat(Message{Role: RoleAssistant, ToolCalls: []ToolCall{answer}}, nil)
+	assert.Equal(t, []ToolCall{answer}, msg.ToolCalls)
+}
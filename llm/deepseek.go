@@ -8,12 +8,14 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 )
 
 const deepseekAPIEndpoint = "https://api.deepseek.com/chat/completions"
 
 // DeepSeekLLM implements the LLM interface for DeepSeek
 type DeepSeekLLM struct {
+	callLogger
 	apiKey string
 	client *http.Client
 }
@@ -37,10 +39,11 @@ type deepseekMessage struct {
 // Convert Message to deepseekMessage
 func convertToDeepSeekMessage(msg Message) deepseekMessage {
 	dsMsg := deepseekMessage{
-		Role:      convertToDeepSeekRole(msg.Role),
-		Content:   msg.Content,
-		Name:      msg.Name,
-		ToolCalls: msg.ToolCalls,
+		Role:       convertToDeepSeekRole(msg.Role),
+		Content:    msg.Content,
+		Name:       msg.Name,
+		ToolCalls:  msg.ToolCalls,
+		ToolCallID: msg.ToolCallID,
 	}
 	return dsMsg
 }
@@ -48,10 +51,11 @@ func convertToDeepSeekMessage(msg Message) deepseekMessage {
 // Convert deepseekMessage to Message
 func convertFromDeepSeekMessage(msg deepseekMessage) Message {
 	return Message{
-		Role:      convertFromDeepSeekRole(msg.Role),
-		Content:   msg.Content,
-		Name:      msg.Name,
-		ToolCalls: msg.ToolCalls,
+		Role:       convertFromDeepSeekRole(msg.Role),
+		Content:    msg.Content,
+		Name:       msg.Name,
+		ToolCalls:  msg.ToolCalls,
+		ToolCallID: msg.ToolCallID,
 	}
 }
 
@@ -92,32 +96,34 @@ func convertToDeepSeekRole(role Role) string {
 
 func convertFromDeepSeekRole(role string) Role {
 	if role == "tool" {
-		return RoleFunction
+		return RoleTool
 	}
 	return Role(role)
 }
 
-// CreateChatCompletion implements the LLM interface for DeepSeek
-func (l *DeepSeekLLM) CreateChatCompletion(ctx context.Context, req ChatCompletionRequest) (ChatCompletionResponse, error) {
-	// Convert messages to DeepSeek format
+// convertToDeepSeekMessages converts our generic messages to DeepSeek's format, linking
+// each tool result to the call it answers and checking that every call was answered
+func convertToDeepSeekMessages(messages []Message) ([]deepseekMessage, error) {
 	var deepseekMessages []deepseekMessage
 	var lastToolCalls []ToolCall
 
-	for i, msg := range req.Messages {
-		if msg.Role == RoleFunction {
-			// For function responses, we need to find the corresponding tool call
-			var toolCallID string
-			for j := i - 1; j >= 0; j-- {
-				if req.Messages[j].Role == RoleAssistant && len(req.Messages[j].ToolCalls) > 0 {
-					for _, toolCall := range req.Messages[j].ToolCalls {
-						if toolCall.Function.Name == msg.Name {
-							toolCallID = toolCall.ID
+	for i, msg := range messages {
+		if msg.Role == RoleTool || msg.Role == RoleFunction {
+			toolCallID := msg.ToolCallID
+			if toolCallID == "" {
+				// Legacy function results only carry a name, so find the corresponding tool call
+				for j := i - 1; j >= 0; j-- {
+					if messages[j].Role == RoleAssistant && len(messages[j].ToolCalls) > 0 {
+						for _, toolCall := range messages[j].ToolCalls {
+							if toolCall.Function.Name == msg.Name {
+								toolCallID = toolCall.ID
+								break
+							}
+						}
+						if toolCallID != "" {
 							break
 						}
 					}
-					if toolCallID != "" {
-						break
-					}
 				}
 			}
 			if toolCallID == "" {
@@ -136,27 +142,33 @@ func (l *DeepSeekLLM) CreateChatCompletion(ctx context.Context, req ChatCompleti
 		}
 	}
 
-	// If the last message had tool calls but no responses, skip the follow-up
-	if len(lastToolCalls) > 0 {
-		hasAllResponses := true
-		for _, toolCall := range lastToolCalls {
-			found := false
-			for _, msg := range deepseekMessages {
-				if msg.Role == "tool" && msg.ToolCallID == toolCall.ID {
-					found = true
-					break
-				}
-			}
-			if !found {
-				hasAllResponses = false
+	// Every tool call of the last assistant message needs a response
+	for _, toolCall := range lastToolCalls {
+		found := false
+		for _, msg := range deepseekMessages {
+			if msg.Role == "tool" && msg.ToolCallID == toolCall.ID {
+				found = true
 				break
 			}
 		}
-		if !hasAllResponses {
-			return ChatCompletionResponse{}, fmt.Errorf("missing tool responses")
+		if !found {
+			return nil, fmt.Errorf("missing tool response for call %s", toolCall.ID)
 		}
 	}
 
+	return deepseekMessages, nil
+}
+
+// CreateChatCompletion implements the LLM interface for DeepSeek
+func (l *DeepSeekLLM) CreateChatCompletion(ctx context.Context, req ChatCompletionRequest) (_ ChatCompletionResponse, err error) {
+	defer func(start time.Time) { l.logCall(ctx, DeepSeek, "chat completion", req.Model, start, err) }(time.Now())
+
+	// Convert messages to DeepSeek format
+	deepseekMessages, err := convertToDeepSeekMessages(req.Messages)
+	if err != nil {
+		return ChatCompletionResponse{}, err
+	}
+
 	deepseekReq := deepseekRequest{
 		Model:            req.Model,
 		Messages:         deepseekMessages,
@@ -169,11 +181,6 @@ func (l *DeepSeekLLM) CreateChatCompletion(ctx context.Context, req ChatCompleti
 		Stop:             req.Stop,
 	}
 
-	// For follow-up responses after tool calls, disable tools to prevent loops
-	if len(req.Messages) > 0 && req.Messages[len(req.Messages)-1].Role == RoleFunction {
-		deepseekReq.Tools = nil
-	}
-
 	// Set default values if not provided
 	if deepseekReq.Temperature == 0 {
 		deepseekReq.Temperature = 0.7
@@ -206,7 +213,7 @@ func (l *DeepSeekLLM) CreateChatCompletion(ctx context.Context, req ChatCompleti
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return ChatCompletionResponse{}, fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(body))
+		return ChatCompletionResponse{}, newHTTPAPIError(DeepSeek, resp, body)
 	}
 
 	var deepseekResp deepseekResponse
@@ -279,7 +286,9 @@ func (s *deepseekStreamWrapper) Recv() (ChatCompletionResponse, error) {
 }
 
 // CreateChatCompletionStream implements the LLM interface for DeepSeek streaming
-func (l *DeepSeekLLM) CreateChatCompletionStream(ctx context.Context, req ChatCompletionRequest) (ChatCompletionStream, error) {
+func (l *DeepSeekLLM) CreateChatCompletionStream(ctx context.Context, req ChatCompletionRequest) (_ ChatCompletionStream, err error) {
+	defer func(start time.Time) { l.logCall(ctx, DeepSeek, "chat completion stream", req.Model, start, err) }(time.Now())
+
 	// Convert messages to DeepSeek format
 	var deepseekMessages []deepseekMessage
 	var lastToolCalls []ToolCall
@@ -352,11 +361,6 @@ func (l *DeepSeekLLM) CreateChatCompletionStream(ctx context.Context, req ChatCo
 		Stream:           true,
 	}
 
-	// For follow-up responses after tool calls, disable tools to prevent loops
-	if len(req.Messages) > 0 && req.Messages[len(req.Messages)-1].Role == RoleFunction {
-		deepseekReq.Tools = nil
-	}
-
 	// Set default values if not provided
 	if deepseekReq.Temperature == 0 {
 		deepseekReq.Temperature = 0.7
@@ -387,9 +391,9 @@ func (l *DeepSeekLLM) CreateChatCompletionStream(ctx context.Context, req ChatCo
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		resp.Body.Close()
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(body))
+		resp.Body.Close()
+		return nil, newHTTPAPIError(DeepSeek, resp, body)
 	}
 
 	return newDeepseekStreamWrapper(ctx, resp), nil
@@ -0,0 +1,155 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIError is returned by every provider when the LLM API rejects a request.
+// Callers should inspect it with errors.As rather than matching on the message.
+type APIError struct {
+	Provider   LLMProvider   // Provider that returned the error
+	StatusCode int           // HTTP status code, 0 if unknown
+	Code       string        // Provider-specific error code or type, e.g. "rate_limit_exceeded"
+	Message    string        // Human readable error message
+	Retryable  bool          // Whether the same request may succeed if retried
+	RetryAfter time.Duration // Delay requested by the server before retrying, 0 if none
+	Err        error         // Underlying SDK or transport error
+}
+
+// NewAPIError creates an APIError, deriving Retryable from the status code
+func NewAPIError(provider LLMProvider, statusCode int, code, message string, err error) *APIError {
+	return &APIError{
+		Provider:   provider,
+		StatusCode: statusCode,
+		Code:       code,
+		Message:    message,
+		Retryable:  isRetryableStatus(statusCode),
+		Err:        err,
+	}
+}
+
+func (e *APIError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s API error", e.Provider)
+	if e.StatusCode != 0 {
+		fmt.Fprintf(&b, " (status %d)", e.StatusCode)
+	}
+	if e.Code != "" {
+		fmt.Fprintf(&b, " [%s]", e.Code)
+	}
+	if e.Message != "" {
+		fmt.Fprintf(&b, ": %s", e.Message)
+	} else if e.Err != nil {
+		fmt.Fprintf(&b, ": %v", e.Err)
+	}
+	return b.String()
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// IsRateLimit reports whether the error was caused by rate limiting
+func (e *APIError) IsRateLimit() bool {
+	if e.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	code := strings.ToLower(e.Code)
+	return strings.Contains(code, "rate_limit") || code == "resource_exhausted"
+}
+
+// IsAuth reports whether the error was caused by missing or invalid credentials
+func (e *APIError) IsAuth() bool {
+	return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+}
+
+// isRetryableStatus reports whether a request that failed with the given HTTP status may succeed later
+func isRetryableStatus(statusCode int) bool {
+	switch {
+	case statusCode == 0:
+		// No status means the request never got a response, e.g. a network error
+		return true
+	case statusCode == http.StatusRequestTimeout,
+		statusCode == http.StatusConflict,
+		statusCode == http.StatusTooEarly,
+		statusCode == http.StatusTooManyRequests:
+		return true
+	case statusCode >= 500:
+		return true
+	default:
+		return false
+	}
+}
+
+// ParseRetryAfter parses a Retry-After header value, given either in seconds or as an HTTP date
+func ParseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// retryAfterFromHeader reads the retry delay from response headers, preferring
+// the millisecond precision header some providers send alongside Retry-After
+func retryAfterFromHeader(header http.Header) time.Duration {
+	if header == nil {
+		return 0
+	}
+	if ms, err := strconv.ParseFloat(header.Get("retry-after-ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	return ParseRetryAfter(header.Get("Retry-After"))
+}
+
+// newHTTPAPIError builds an APIError from a raw HTTP error response, decoding the
+// {"error": {"message", "type", "code"}} body shape shared by most providers
+func newHTTPAPIError(provider LLMProvider, resp *http.Response, body []byte) *APIError {
+	var payload struct {
+		Error json.RawMessage `json:"error"`
+	}
+	var detail struct {
+		Message string      `json:"message"`
+		Type    string      `json:"type"`
+		Code    interface{} `json:"code"`
+	}
+
+	message := strings.TrimSpace(string(body))
+	code := ""
+	if err := json.Unmarshal(body, &payload); err == nil && len(payload.Error) > 0 {
+		if err := json.Unmarshal(payload.Error, &detail); err == nil {
+			message = detail.Message
+			code = detail.Type
+			if detail.Code != nil {
+				code = fmt.Sprint(detail.Code)
+			}
+		} else {
+			// Some providers send the error as a plain string
+			var text string
+			if err := json.Unmarshal(payload.Error, &text); err == nil {
+				message = text
+			}
+		}
+	}
+
+	apiErr := NewAPIError(provider, resp.StatusCode, code, message, errors.New(resp.Status))
+	apiErr.RetryAfter = retryAfterFromHeader(resp.Header)
+	return apiErr
+}
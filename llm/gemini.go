@@ -3,15 +3,23 @@ package llm
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/google/generative-ai-go/genai"
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
 // GeminiLLM implements the LLM interface for Google's Gemini
 type GeminiLLM struct {
+	callLogger
 	client *genai.Client
 }
 
@@ -20,6 +28,7 @@ type GeminiOptions struct {
 	Model          string
 	HarmThreshold  genai.HarmBlockThreshold
 	SafetySettings []*genai.SafetySetting
+	Logger         *slog.Logger // Logger the client writes its calls to, see SetLogger
 }
 
 
@@ -33,82 +42,171 @@ func NewGeminiLLM(apiKey string, opts ...GeminiOptions) (*GeminiLLM, error) {
 	}
 
 
-	return &GeminiLLM{
+	gemini := &GeminiLLM{
 		client: client,
-	}, nil
+	}
+	for _, opt := range opts {
+		if opt.Logger != nil {
+			gemini.SetLogger(opt.Logger)
+		}
+	}
+	return gemini, nil
+}
+
+// grpcCodeToHTTPStatus maps the gRPC status codes Gemini returns to their HTTP equivalents
+var grpcCodeToHTTPStatus = map[string]int{
+	"Canceled":           499,
+	"Unknown":            http.StatusInternalServerError,
+	"InvalidArgument":    http.StatusBadRequest,
+	"FailedPrecondition": http.StatusBadRequest,
+	"OutOfRange":         http.StatusBadRequest,
+	"Unauthenticated":    http.StatusUnauthorized,
+	"PermissionDenied":   http.StatusForbidden,
+	"NotFound":           http.StatusNotFound,
+	"AlreadyExists":      http.StatusConflict,
+	"Aborted":            http.StatusConflict,
+	"ResourceExhausted":  http.StatusTooManyRequests,
+	"Internal":           http.StatusInternalServerError,
+	"DataLoss":           http.StatusInternalServerError,
+	"Unimplemented":      http.StatusNotImplemented,
+	"Unavailable":        http.StatusServiceUnavailable,
+	"DeadlineExceeded":   http.StatusGatewayTimeout,
+}
+
+// convertGeminiError converts errors from the Gemini client into an APIError
+func convertGeminiError(err error) error {
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		code := ""
+		if len(googleErr.Errors) > 0 {
+			code = googleErr.Errors[0].Reason
+		}
+		apiErr := NewAPIError(Gemini, googleErr.Code, code, googleErr.Message, err)
+		apiErr.RetryAfter = retryAfterFromHeader(googleErr.Header)
+		return apiErr
+	}
+
+	var gaxErr *apierror.APIError
+	if errors.As(err, &gaxErr) {
+		statusCode := gaxErr.HTTPCode()
+		code := gaxErr.Reason()
+		message := gaxErr.Error()
+		known := true
+		if st := gaxErr.GRPCStatus(); st != nil {
+			statusCode, known = grpcCodeToHTTPStatus[st.Code().String()]
+			if code == "" {
+				code = st.Code().String()
+			}
+			message = st.Message()
+		}
+		if statusCode < 0 {
+			statusCode = 0
+		}
+		apiErr := NewAPIError(Gemini, statusCode, code, message, err)
+		if !known {
+			// The API answered with a code we can't classify, so retrying blindly is unsafe
+			apiErr.Retryable = false
+		}
+		if info := gaxErr.Details().RetryInfo; info != nil {
+			apiErr.RetryAfter = info.GetRetryDelay().AsDuration()
+		}
+		return apiErr
+	}
+
+	return err
 }
 
-// convertToGeminiMessages converts our generic Message type to Gemini's content type
-func convertToGeminiMessages(messages []Message) []genai.Part {
-	var parts []genai.Part
+// convertToGeminiContents converts our generic Message type to Gemini's chat contents.
+// System messages are returned separately as the system instruction, assistant tool calls
+// become FunctionCall parts and tool results become FunctionResponse parts.
+func convertToGeminiContents(messages []Message) (*genai.Content, []*genai.Content) {
+	var system *genai.Content
+	var contents []*genai.Content
+
+	// Gemini links function responses by name, so remember which call each ID belongs to
+	toolNames := make(map[string]string)
+
+	appendParts := func(role string, parts ...genai.Part) {
+		if len(parts) == 0 {
+			return
+		}
+		// Consecutive turns from the same role, such as parallel tool results, share one content
+		if n := len(contents); n > 0 && contents[n-1].Role == role {
+			contents[n-1].Parts = append(contents[n-1].Parts, parts...)
+			return
+		}
+		contents = append(contents, &genai.Content{Role: role, Parts: parts})
+	}
 
 	for _, msg := range messages {
 		content := strings.TrimSpace(msg.Content)
-		if content == "" {
-			continue // Skip empty messages
-		}
 
 		switch msg.Role {
 		case RoleSystem:
-			parts = append(parts, genai.Text(fmt.Sprintf("[System]\n%s", content)))
-		case "function":
-			if msg.Name != "" {
-				parts = append(parts, genai.Text(fmt.Sprintf("[Function: %s]\n%s", msg.Name, content)))
-			} else {
-				parts = append(parts, genai.Text(fmt.Sprintf("[Function Result]\n%s", content)))
+			if content == "" {
+				continue
 			}
-		case RoleAssistant:
-			parts = append(parts, genai.Text(fmt.Sprintf("[Assistant]\n%s", content)))
+			if system == nil {
+				system = &genai.Content{}
+			}
+			system.Parts = append(system.Parts, genai.Text(content))
 		case RoleUser:
-			parts = append(parts, genai.Text(fmt.Sprintf("[User]\n%s", content)))
+			if content != "" {
+				appendParts("user", genai.Text(content))
+			}
+		case RoleAssistant:
+			var parts []genai.Part
+			if content != "" {
+				parts = append(parts, genai.Text(content))
+			}
+			for _, tc := range msg.ToolCalls {
+				args := make(map[string]interface{})
+				if tc.Function.Arguments != "" {
+					if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
+						args = make(map[string]interface{})
+					}
+				}
+				parts = append(parts, genai.FunctionCall{Name: tc.Function.Name, Args: args})
+				toolNames[tc.ID] = tc.Function.Name
+			}
+			appendParts("model", parts...)
+		case RoleTool, RoleFunction:
+			name := msg.Name
+			if toolName, ok := toolNames[msg.ToolCallID]; ok {
+				name = toolName
+			}
+			if name == "" {
+				continue
+			}
+			// Gemini expects an object, so wrap results that aren't one
+			response := make(map[string]interface{})
+			if err := json.Unmarshal([]byte(content), &response); err != nil {
+				response = map[string]interface{}{"result": msg.Content}
+			}
+			appendParts("user", genai.FunctionResponse{Name: name, Response: response})
 		}
 	}
 
-	return parts
+	return system, contents
 }
 
-// convertFromGeminiResponse converts Gemini's response to our generic Message type
-func convertFromGeminiResponse(resp *genai.GenerateContentResponse) Message {
-	if resp == nil || len(resp.Candidates) == 0 {
-		return Message{Role: RoleAssistant, Content: ""}
-	}
-
-	content := resp.Candidates[0].Content
-	if content == nil || len(content.Parts) == 0 {
+// convertFromGeminiCandidate converts a Gemini candidate to our generic Message type
+func convertFromGeminiCandidate(c *genai.Candidate) Message {
+	if c == nil || c.Content == nil {
 		return Message{Role: RoleAssistant, Content: ""}
 	}
 
 	var textParts []string
-	var toolCalls []ToolCall
-
-	for _, part := range content.Parts {
-		switch p := part.(type) {
-		case genai.Text:
-			text := string(p)
-			// Remove role prefixes if present
-			text = strings.TrimPrefix(text, "[Assistant]\n")
-			text = strings.TrimSpace(text)
-			if text != "" {
-				textParts = append(textParts, text)
-			}
-		case genai.FunctionCall:
-			args, err := json.Marshal(p.Args)
-			if err == nil {
-				toolCalls = append(toolCalls, ToolCall{
-					Type: "function",
-					Function: ToolCallFunction{
-						Name:      p.Name,
-						Arguments: string(args),
-					},
-				})
-			}
+	for _, part := range c.Content.Parts {
+		if t, ok := part.(genai.Text); ok {
+			textParts = append(textParts, string(t))
 		}
 	}
 
 	return Message{
 		Role:      RoleAssistant,
-		Content:   strings.Join(textParts, "\n"),
-		ToolCalls: toolCalls,
+		Content:   strings.Join(textParts, ""),
+		ToolCalls: convertFromGeminiToolCalls(c.Content.Parts),
 	}
 }
 
@@ -166,6 +264,49 @@ func convertToGeminiTools(tools []Tool) []*genai.Tool {
 	return geminiTools
 }
 
+// convertToGeminiSchema converts a JSON schema to Gemini's schema type, keeping the
+// subset Gemini understands: types, descriptions, enums, properties and array items
+func convertToGeminiSchema(schema map[string]interface{}) *genai.Schema {
+	result := &genai.Schema{}
+	if typ, ok := schema["type"].(string); ok {
+		result.Type = convertSchemaType(typ)
+	}
+	if desc, ok := schema["description"].(string); ok {
+		result.Description = desc
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		for _, v := range enum {
+			if str, ok := v.(string); ok {
+				result.Enum = append(result.Enum, str)
+			}
+		}
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		result.Items = convertToGeminiSchema(items)
+	}
+	if properties, ok := schema["properties"].(map[string]interface{}); ok {
+		result.Properties = make(map[string]*genai.Schema)
+		for name, prop := range properties {
+			if propMap, ok := prop.(map[string]interface{}); ok {
+				result.Properties[name] = convertToGeminiSchema(propMap)
+			}
+		}
+	}
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, r := range required {
+			if str, ok := r.(string); ok {
+				result.Required = append(result.Required, str)
+			}
+		}
+	}
+	return result
+}
+
+// SupportsStructuredOutput reports that Gemini natively honors ResponseFormat
+func (g *GeminiLLM) SupportsStructuredOutput() bool {
+	return true
+}
+
 // convertSchemaType converts a JSON Schema type to Gemini schema type
 func convertSchemaType(typ string) genai.Type {
 	switch typ {
@@ -186,14 +327,19 @@ func convertSchemaType(typ string) genai.Type {
 	}
 }
 
-// convertFromGeminiToolCalls converts Gemini's tool calls to our generic type
+// convertFromGeminiToolCalls converts Gemini's tool calls to our generic type.
+// Gemini doesn't assign call IDs, so a unique one is generated for each call.
 func convertFromGeminiToolCalls(parts []genai.Part) []ToolCall {
 	var calls []ToolCall
-	
+
 	for _, part := range parts {
 		if fc, ok := part.(genai.FunctionCall); ok {
-			args, _ := json.Marshal(fc.Args)
+			args, err := json.Marshal(fc.Args)
+			if err != nil {
+				continue
+			}
 			calls = append(calls, ToolCall{
+				ID:   NewToolCallID(),
 				Type: "function",
 				Function: ToolCallFunction{
 					Name:      fc.Name,
@@ -202,13 +348,13 @@ func convertFromGeminiToolCalls(parts []genai.Part) []ToolCall {
 			})
 		}
 	}
-	
+
 	return calls
 }
 
-// CreateChatCompletion implements the LLM interface for Gemini
-func (g *GeminiLLM) CreateChatCompletion(ctx context.Context, req ChatCompletionRequest) (ChatCompletionResponse, error) {
-	// Create model and configure settings
+// startChat configures a model for the request and returns a chat session holding the
+// conversation history, along with the parts of the final user or tool turn to send
+func (g *GeminiLLM) startChat(req ChatCompletionRequest) (*genai.ChatSession, []genai.Part, error) {
 	model := g.client.GenerativeModel(req.Model)
 
 	if req.Temperature > 0 {
@@ -220,126 +366,61 @@ func (g *GeminiLLM) CreateChatCompletion(ctx context.Context, req ChatCompletion
 	if req.MaxTokens > 0 {
 		model.SetMaxOutputTokens(int32(req.MaxTokens))
 	}
+	if len(req.Tools) > 0 {
+		model.Tools = convertToGeminiTools(req.Tools)
+	}
 
-	// Check if we're in a function calling cycle
-	inFunctionCall := false
-	if len(req.Messages) > 0 {
-		lastMsg := req.Messages[len(req.Messages)-1]
-		inFunctionCall = lastMsg.Role == "function"
+	system, contents := convertToGeminiContents(req.Messages)
+	model.SystemInstruction = system
+
+	if format := req.ResponseFormat; format != nil {
+		if len(req.Tools) == 0 {
+			model.ResponseMIMEType = "application/json"
+			model.ResponseSchema = convertToGeminiSchema(format.Schema)
+		} else {
+			// Gemini can't combine function calling with a response schema, so ask for it instead
+			schema, err := json.Marshal(format.Schema)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to marshal response schema: %w", err)
+			}
+			if model.SystemInstruction == nil {
+				model.SystemInstruction = &genai.Content{}
+			}
+			model.SystemInstruction.Parts = append(model.SystemInstruction.Parts, genai.Text(
+				"Reply with your final answer as a JSON object matching this JSON schema, without any other text: "+string(schema)))
+		}
 	}
 
-	// Only set tools if we're not in a function calling cycle
-	if len(req.Tools) > 0 && !inFunctionCall {
-		model.Tools = convertToGeminiTools(req.Tools)
+	if len(contents) == 0 || contents[len(contents)-1].Role != "user" {
+		return nil, nil, fmt.Errorf("gemini requires the last message to be from the user or a tool")
 	}
 
-	// Convert messages to Gemini format
-	parts := convertToGeminiMessages(req.Messages)
+	chat := model.StartChat()
+	chat.History = contents[:len(contents)-1]
+	return chat, contents[len(contents)-1].Parts, nil
+}
+
+// CreateChatCompletion implements the LLM interface for Gemini
+func (g *GeminiLLM) CreateChatCompletion(ctx context.Context, req ChatCompletionRequest) (_ ChatCompletionResponse, err error) {
+	defer func(start time.Time) { g.logCall(ctx, Gemini, "chat completion", req.Model, start, err) }(time.Now())
+
+	chat, parts, err := g.startChat(req)
+	if err != nil {
+		return ChatCompletionResponse{}, err
+	}
 
 	// Generate response
-	resp, err := model.GenerateContent(ctx, parts...)
+	resp, err := chat.SendMessage(ctx, parts...)
 	if err != nil {
-		return ChatCompletionResponse{}, fmt.Errorf("failed to generate content: %v", err)
+		return ChatCompletionResponse{}, fmt.Errorf("failed to generate content: %w", convertGeminiError(err))
 	}
 
 	// Convert response to our format
 	choices := make([]Choice, len(resp.Candidates))
 	for i, c := range resp.Candidates {
-		msg := Message{
-			Role:    RoleAssistant,
-			Content: "",
-		}
-
-		// Handle function calls and text separately
-		var textParts []string
-		for _, part := range c.Content.Parts {
-			switch p := part.(type) {
-			case genai.Text:
-				textParts = append(textParts, string(p))
-			case genai.FunctionCall:
-				if !inFunctionCall {
-					args, err := json.Marshal(p.Args)
-					if err != nil {
-						continue
-					}
-					msg.ToolCalls = append(msg.ToolCalls, ToolCall{
-						Type: "function",
-						Function: ToolCallFunction{
-							Name:      p.Name,
-							Arguments: string(args),
-						},
-					})
-				}
-			}
-		}
-		msg.Content = strings.Join(textParts, "")
-
-		// If we have function calls and we're not in a function calling cycle
-		if len(msg.ToolCalls) > 0 && !inFunctionCall {
-			// Create new messages array with original messages plus function results
-			var newMessages []Message
-			newMessages = append(newMessages, req.Messages...)
-			
-			// Add assistant's message with function calls
-			newMessages = append(newMessages, Message{
-				Role:      RoleAssistant,
-				Content:   msg.Content,
-				ToolCalls: msg.ToolCalls,
-			})
-
-			// Add function results
-			for _, toolCall := range msg.ToolCalls {
-				newMessages = append(newMessages, Message{
-					Role:    "function",
-					Content: toolCall.Function.Arguments,
-					Name:    toolCall.Function.Name,
-				})
-			}
-
-			// Create a new request for the final response
-			finalReq := ChatCompletionRequest{
-				Model:       req.Model,
-				Messages:    newMessages,
-				Temperature: req.Temperature,
-				TopP:       req.TopP,
-				MaxTokens:   req.MaxTokens,
-			}
-
-			// Create a new model instance for the final response
-			finalModel := g.client.GenerativeModel(finalReq.Model)
-			if finalReq.Temperature > 0 {
-				finalModel.SetTemperature(float32(finalReq.Temperature))
-			}
-			if finalReq.TopP > 0 {
-				finalModel.SetTopP(float32(finalReq.TopP))
-			}
-			if finalReq.MaxTokens > 0 {
-				finalModel.SetMaxOutputTokens(int32(finalReq.MaxTokens))
-			}
-
-			// Convert messages to Gemini format
-			finalParts := convertToGeminiMessages(newMessages)
-
-			// Get the final response
-			finalResp, err := finalModel.GenerateContent(ctx, finalParts...)
-			if err != nil {
-				return ChatCompletionResponse{}, fmt.Errorf("failed to generate final response: %v", err)
-			}
-
-			if len(finalResp.Candidates) > 0 {
-				var finalTextParts []string
-				for _, part := range finalResp.Candidates[0].Content.Parts {
-					if t, ok := part.(genai.Text); ok {
-						finalTextParts = append(finalTextParts, string(t))
-					}
-				}
-				msg.Content = strings.Join(finalTextParts, "")
-			}
-		}
-
 		choices[i] = Choice{
 			Index:        i,
-			Message:      msg,
+			Message:      convertFromGeminiCandidate(c),
 			FinishReason: string(c.FinishReason),
 		}
 	}
@@ -361,83 +442,25 @@ func (g *GeminiLLM) CreateChatCompletion(ctx context.Context, req ChatCompletion
 
 // geminiStreamWrapper wraps Gemini's stream to implement our ChatCompletionStream interface
 type geminiStreamWrapper struct {
-	iter              *genai.GenerateContentResponseIterator
-	client            *GeminiLLM
-	req               ChatCompletionRequest
-	ctx               context.Context
-	inFunctionCall    bool
-	currentToolCall   *ToolCall
-	toolCallBuffer    map[string]*ToolCall
+	iter *genai.GenerateContentResponseIterator
 }
 
 func (w *geminiStreamWrapper) Recv() (ChatCompletionResponse, error) {
 	// Get next response from iterator
 	resp, err := w.iter.Next()
 	if err != nil {
-		if w.inFunctionCall {
-			// If we're in a function call and hit the end, start a new stream for the response
-			w.inFunctionCall = false
-			w.iter = nil
-			return w.handleFunctionResponse()
+		if err == iterator.Done {
+			return ChatCompletionResponse{}, err
 		}
-		return ChatCompletionResponse{}, err
+		return ChatCompletionResponse{}, convertGeminiError(err)
 	}
 
+	// Gemini streams each function call whole, so chunks can be converted independently
 	choices := make([]Choice, len(resp.Candidates))
 	for i, c := range resp.Candidates {
-		msg := Message{
-			Role:    RoleAssistant,
-			Content: "",
-		}
-
-		// Handle function calls and text separately
-		var textParts []string
-		for _, part := range c.Content.Parts {
-			switch p := part.(type) {
-			case genai.Text:
-				textParts = append(textParts, string(p))
-			case genai.FunctionCall:
-				if !w.inFunctionCall {
-					args, err := json.Marshal(p.Args)
-					if err != nil {
-						continue
-					}
-
-					// Create or update tool call
-					toolCall, exists := w.toolCallBuffer[p.Name]
-					if !exists {
-						toolCall = &ToolCall{
-							ID:   p.Name, // Use function name as ID since Gemini doesn't provide one
-							Type: "function",
-							Function: ToolCallFunction{
-								Name:      p.Name,
-								Arguments: "",
-							},
-						}
-						w.toolCallBuffer[p.Name] = toolCall
-						w.currentToolCall = toolCall
-					}
-
-					// Update arguments
-					toolCall.Function.Arguments += string(args)
-
-					// Try to parse the arguments to verify if it's complete JSON
-					var parsedArgs map[string]interface{}
-					if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &parsedArgs); err == nil {
-						// Add to message's tool calls and remove from buffer
-						msg.ToolCalls = append(msg.ToolCalls, *toolCall)
-						delete(w.toolCallBuffer, p.Name)
-						w.currentToolCall = nil
-						w.inFunctionCall = true
-					}
-				}
-			}
-		}
-		msg.Content = strings.Join(textParts, "")
-
 		choices[i] = Choice{
 			Index:        i,
-			Message:      msg,
+			Message:      convertFromGeminiCandidate(c),
 			FinishReason: string(c.FinishReason),
 		}
 	}
@@ -447,90 +470,22 @@ func (w *geminiStreamWrapper) Recv() (ChatCompletionResponse, error) {
 	}, nil
 }
 
-func (w *geminiStreamWrapper) handleFunctionResponse() (ChatCompletionResponse, error) {
-	// Create new messages array with original messages plus function results
-	var newMessages []Message
-	newMessages = append(newMessages, w.req.Messages...)
-	
-	// Add the last assistant message with function calls
-	lastAssistantMsg := w.req.Messages[len(w.req.Messages)-1]
-	newMessages = append(newMessages, lastAssistantMsg)
-
-	// Add function results from tool calls
-	for _, toolCall := range lastAssistantMsg.ToolCalls {
-		newMessages = append(newMessages, Message{
-			Role:    "function",
-			Content: toolCall.Function.Arguments,
-			Name:    toolCall.Function.Name,
-		})
-	}
-
-	// Create a new model instance for the final response
-	model := w.client.client.GenerativeModel(w.req.Model)
-	if w.req.Temperature > 0 {
-		model.SetTemperature(float32(w.req.Temperature))
-	}
-	if w.req.TopP > 0 {
-		model.SetTopP(float32(w.req.TopP))
-	}
-	if w.req.MaxTokens > 0 {
-		model.SetMaxOutputTokens(int32(w.req.MaxTokens))
-	}
-
-	// Convert messages to Gemini format
-	parts := convertToGeminiMessages(newMessages)
-
-	// Start new stream for the response
-	w.iter = model.GenerateContentStream(w.ctx, parts...)
-	
-	// Get first response from new stream
-	return w.Recv()
-}
-
 func (w *geminiStreamWrapper) Close() error {
 	w.iter = nil
 	return nil
 }
 
 // CreateChatCompletionStream implements the LLM interface for Gemini streaming
-func (g *GeminiLLM) CreateChatCompletionStream(ctx context.Context, req ChatCompletionRequest) (ChatCompletionStream, error) {
-	// Create model and configure settings
-	model := g.client.GenerativeModel(req.Model)
+func (g *GeminiLLM) CreateChatCompletionStream(ctx context.Context, req ChatCompletionRequest) (_ ChatCompletionStream, err error) {
+	defer func(start time.Time) { g.logCall(ctx, Gemini, "chat completion stream", req.Model, start, err) }(time.Now())
 
-	if req.Temperature > 0 {
-		model.SetTemperature(float32(req.Temperature))
-	}
-	if req.TopP > 0 {
-		model.SetTopP(float32(req.TopP))
-	}
-	if req.MaxTokens > 0 {
-		model.SetMaxOutputTokens(int32(req.MaxTokens))
-	}
-
-	// Check if we're in a function calling cycle
-	inFunctionCall := false
-	if len(req.Messages) > 0 {
-		lastMsg := req.Messages[len(req.Messages)-1]
-		inFunctionCall = lastMsg.Role == "function" || (lastMsg.Role == RoleAssistant && len(lastMsg.ToolCalls) > 0)
-	}
-
-	// Only set tools if we're not in a function calling cycle
-	if len(req.Tools) > 0 && !inFunctionCall {
-		model.Tools = convertToGeminiTools(req.Tools)
+	chat, parts, err := g.startChat(req)
+	if err != nil {
+		return nil, err
 	}
 
-	// Convert messages to Gemini format
-	parts := convertToGeminiMessages(req.Messages)
-
 	// Generate streaming response
-	iter := model.GenerateContentStream(ctx, parts...)
-
 	return &geminiStreamWrapper{
-		iter:           iter,
-		client:         g,
-		req:            req,
-		ctx:            ctx,
-		inFunctionCall: inFunctionCall,
-		toolCallBuffer: make(map[string]*ToolCall),
+		iter: chat.SendMessageStream(ctx, parts...),
 	}, nil
 }
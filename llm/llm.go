@@ -2,6 +2,9 @@ package llm
 
 import (
 	"context"
+	"strings"
+
+	"github.com/google/uuid"
 )
 
 // Role represents the role of a message participant
@@ -29,12 +32,15 @@ const (
 	DeepSeek        LLMProvider = "DEEPSEEK"
 )
 
-// Message represents a single message in a chat conversation
+// Message represents a single message in a chat conversation.
+// Tool results use RoleTool and set ToolCallID to the ID of the call they answer.
 type Message struct {
-	Role      Role       `json:"role"`
-	Content   string     `json:"content"`
-	Name      string     `json:"name,omitempty"`
-	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	Role       Role       `json:"role"`
+	Content    string     `json:"content"`
+	Name       string     `json:"name,omitempty"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+	IsError    bool       `json:"is_error,omitempty"` // Whether a tool message reports a failed call
 }
 
 // ChatCompletionRequest represents a generic request for chat completion
@@ -51,6 +57,22 @@ type ChatCompletionRequest struct {
 	User             string    `json:"user,omitempty"`
 	Tools            []Tool    `json:"tools,omitempty"`
 	Stream           bool      `json:"stream,omitempty"`
+	// ResponseFormat asks for a reply that is a JSON object matching a schema.
+	// It is only sent to clients implementing StructuredOutputSupporter.
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+}
+
+// ResponseFormat describes the JSON object a model must reply with
+type ResponseFormat struct {
+	Name        string                 `json:"name"`                  // Name of the schema, letters, digits, _ and - only
+	Description string                 `json:"description,omitempty"` // What the object represents
+	Schema      map[string]interface{} `json:"schema"`                // JSON schema of the object
+}
+
+// StructuredOutputSupporter is implemented by clients that natively constrain replies
+// to a request's ResponseFormat, e.g. through OpenAI's response_format or Claude's forced tool use
+type StructuredOutputSupporter interface {
+	SupportsStructuredOutput() bool
 }
 
 // ChatCompletionResponse represents a generic response from chat completion
@@ -125,3 +147,8 @@ type ToolCallFunction struct {
 	Name      string `json:"name"`
 	Arguments string `json:"arguments"`
 }
+
+// NewToolCallID generates a unique tool call ID for providers that don't assign one
+func NewToolCallID() string {
+	return "call_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+}
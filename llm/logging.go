@@ -0,0 +1,38 @@
+package llm
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// LoggerSetter is implemented by the built-in clients, which write a record of every call
+// to the logger set: failed calls at warn level and completed calls at debug level
+type LoggerSetter interface {
+	SetLogger(logger *slog.Logger)
+}
+
+// callLogger holds the logger a client writes its calls to. Nothing is written until one is set.
+type callLogger struct {
+	logger atomic.Pointer[slog.Logger]
+}
+
+// SetLogger sets the logger the client writes its calls to, or turns logging off when nil
+func (l *callLogger) SetLogger(logger *slog.Logger) {
+	l.logger.Store(logger)
+}
+
+// logCall writes a record of a call to provider that started at start and ended with err
+func (l *callLogger) logCall(ctx context.Context, provider LLMProvider, call, model string, start time.Time, err error) {
+	logger := l.logger.Load()
+	if logger == nil {
+		return
+	}
+	if err != nil {
+		logger.WarnContext(ctx, call+" failed", "provider", provider, "model", model,
+			"duration", time.Since(start), "error", err)
+		return
+	}
+	logger.DebugContext(ctx, call, "provider", provider, "model", model, "duration", time.Since(start))
+}
@@ -0,0 +1,48 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestClientLogsCalls tests that a built-in client writes its calls to the logger it was given
+func TestClientLogsCalls(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if status != http.StatusOK {
+			w.Write([]byte(`{"error": {"message": "overloaded", "type": "server_error"}}`))
+			return
+		}
+		w.Write([]byte(`{"id": "1", "choices": [{"message": {"role": "assistant", "content": "Hi"}, "finish_reason": "stop"}]}`))
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	client := NewOpenAILLMWithOptions("key", OpenAIOptions{BaseURL: server.URL, Logger: logger})
+	req := ChatCompletionRequest{Model: "gpt-4o", Messages: []Message{{Role: RoleUser, Content: "Hi"}}}
+
+	_, err := client.CreateChatCompletion(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), `level=DEBUG msg="chat completion" provider=OPEN_AI model=gpt-4o`)
+
+	status = http.StatusServiceUnavailable
+	_, err = client.CreateChatCompletion(context.Background(), req)
+	assert.Error(t, err)
+	assert.Contains(t, buf.String(), `level=WARN msg="chat completion failed" provider=OPEN_AI model=gpt-4o`)
+
+	// Without a logger nothing is written
+	buf.Reset()
+	client.SetLogger(nil)
+	_, err = client.CreateChatCompletion(context.Background(), req)
+	assert.Error(t, err)
+	assert.Empty(t, buf.String())
+}
@@ -0,0 +1,98 @@
+package llm
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// CompletionFunc sends a chat completion request
+type CompletionFunc func(ctx context.Context, req ChatCompletionRequest) (ChatCompletionResponse, error)
+
+// StreamFunc opens a streaming chat completion
+type StreamFunc func(ctx context.Context, req ChatCompletionRequest) (ChatCompletionStream, error)
+
+// Middleware wraps an LLM to add behavior around its calls, such as logging,
+// redaction, caching, metrics or request mutation. It must return an LLM that
+// eventually calls next, unless it answers the request itself.
+type Middleware func(next LLM) LLM
+
+// Chain wraps client with middleware. The first middleware is the outermost,
+// so it sees each request first and each response last.
+func Chain(client LLM, middleware ...Middleware) LLM {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		client = middleware[i](client)
+	}
+	return client
+}
+
+// MiddlewareFuncs builds a Middleware from functions wrapping the sync and the
+// streaming path. A nil function passes calls on that path straight through.
+func MiddlewareFuncs(
+	complete func(next CompletionFunc) CompletionFunc,
+	stream func(next StreamFunc) StreamFunc,
+) Middleware {
+	return func(next LLM) LLM {
+		wrapped := &funcLLM{
+			complete: next.CreateChatCompletion,
+			stream:   next.CreateChatCompletionStream,
+		}
+		if complete != nil {
+			wrapped.complete = complete(wrapped.complete)
+		}
+		if stream != nil {
+			wrapped.stream = stream(wrapped.stream)
+		}
+		return wrapped
+	}
+}
+
+// WithLogging returns middleware that logs every call to logger: completed calls at debug
+// level with their model, duration and token usage, and failed calls at warn level.
+// Log records carry the attributes of the logger, e.g. one made with logger.With.
+func WithLogging(logger *slog.Logger) Middleware {
+	return MiddlewareFuncs(
+		func(next CompletionFunc) CompletionFunc {
+			return func(ctx context.Context, req ChatCompletionRequest) (ChatCompletionResponse, error) {
+				start := time.Now()
+				resp, err := next(ctx, req)
+				if err != nil {
+					logger.WarnContext(ctx, "chat completion failed", "model", req.Model,
+						"duration", time.Since(start), "error", err)
+					return resp, err
+				}
+				logger.DebugContext(ctx, "chat completion", "model", req.Model, "duration", time.Since(start),
+					"prompt_tokens", resp.Usage.PromptTokens, "completion_tokens", resp.Usage.CompletionTokens)
+				return resp, nil
+			}
+		},
+		func(next StreamFunc) StreamFunc {
+			return func(ctx context.Context, req ChatCompletionRequest) (ChatCompletionStream, error) {
+				start := time.Now()
+				stream, err := next(ctx, req)
+				if err != nil {
+					logger.WarnContext(ctx, "failed to open chat completion stream", "model", req.Model,
+						"duration", time.Since(start), "error", err)
+					return nil, err
+				}
+				logger.DebugContext(ctx, "opened chat completion stream", "model", req.Model,
+					"duration", time.Since(start))
+				return stream, nil
+			}
+		},
+	)
+}
+
+// funcLLM implements the LLM interface with plain functions
+type funcLLM struct {
+	complete CompletionFunc
+	stream   StreamFunc
+}
+
+func (f *funcLLM) CreateChatCompletion(ctx context.Context, req ChatCompletionRequest) (ChatCompletionResponse, error) {
+	return f.complete(ctx, req)
+}
+
+func (f *funcLLM) CreateChatCompletionStream(ctx context.Context, req ChatCompletionRequest) (ChatCompletionStream, error) {
+	return f.stream(ctx, req)
+}
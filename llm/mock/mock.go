@@ -0,0 +1,401 @@
+// Package mock provides a scriptable llm.LLM for unit testing agents without a provider.
+//
+// Replies are scripted in order with Enqueue, or tied to requests with When and predicates
+// over the request. Every request is recorded for later assertions:
+//
+//	m := mock.New()
+//	m.Enqueue(mock.ToolCalls(mock.Call("get_weather", `{"city": "Paris"}`)), mock.Text("It's sunny."))
+//	m.When(mock.LastUserMessageContains("refund"), mock.Text("Let me check."))
+//
+//	client := swarmgo.NewSwarmWithCustomProvider(m, swarmgo.DefaultConfig())
+package mock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prathyushnallamothu/swarmgo/llm"
+)
+
+// ErrUnexpectedRequest is wrapped by the error returned for a request no rule matches once
+// the queue is empty. The error is an *llm.APIError that isn't retryable, so a Swarm fails fast.
+var ErrUnexpectedRequest = errors.New("mock: no scripted response for request")
+
+// provider is the provider of the errors the mock makes up itself
+const provider llm.LLMProvider = "MOCK"
+
+// Response is a scripted answer to a request
+type Response struct {
+	Message   llm.Message   // Assistant message returned, or streamed in chunks
+	Usage     llm.Usage     // Token usage reported with the message
+	Err       error         // Error returned instead of the message
+	Latency   time.Duration // Delay before answering, cut short when the context is done
+	Chunks    []string      // Content of the stream's chunks, the content split after spaces by default
+	ChunkGap  time.Duration // Delay before each streamed chunk after the first
+	StreamErr error         // Error ending the stream after its chunks, instead of io.EOF
+}
+
+// Text returns a response with an assistant message
+func Text(content string) Response {
+	return Response{Message: llm.Message{Role: llm.RoleAssistant, Content: content}}
+}
+
+// ToolCalls returns a response with an assistant message calling tools
+func ToolCalls(calls ...llm.ToolCall) Response {
+	return Response{Message: llm.Message{Role: llm.RoleAssistant, ToolCalls: calls}}
+}
+
+// Call returns a call to the named tool with JSON arguments. Calls without an ID get
+// call_1, call_2 and so on, in the order the mock returns them.
+func Call(name, args string) llm.ToolCall {
+	return llm.ToolCall{Type: "function", Function: llm.ToolCallFunction{Name: name, Arguments: args}}
+}
+
+// Error returns a response failing with err
+func Error(err error) Response {
+	return Response{Err: err}
+}
+
+// WithLatency returns the response answered after a delay
+func (r Response) WithLatency(latency time.Duration) Response {
+	r.Latency = latency
+	return r
+}
+
+// WithChunks returns the response streamed in the given chunks of content
+func (r Response) WithChunks(chunks ...string) Response {
+	r.Chunks = chunks
+	return r
+}
+
+// WithChunkGap returns the response streamed with a delay between chunks
+func (r Response) WithChunkGap(gap time.Duration) Response {
+	r.ChunkGap = gap
+	return r
+}
+
+// WithStreamError returns the response with its stream ending in err after its chunks
+func (r Response) WithStreamError(err error) Response {
+	r.StreamErr = err
+	return r
+}
+
+// WithUsage returns the response reporting token usage
+func (r Response) WithUsage(usage llm.Usage) Response {
+	r.Usage = usage
+	return r
+}
+
+// Predicate selects the requests a rule answers
+type Predicate func(req llm.ChatCompletionRequest) bool
+
+// LastUserMessage matches requests whose last user message is content
+func LastUserMessage(content string) Predicate {
+	return func(req llm.ChatCompletionRequest) bool {
+		msg, ok := lastUserMessage(req)
+		return ok && msg.Content == content
+	}
+}
+
+// LastUserMessageContains matches requests whose last user message contains substr
+func LastUserMessageContains(substr string) Predicate {
+	return func(req llm.ChatCompletionRequest) bool {
+		msg, ok := lastUserMessage(req)
+		return ok && strings.Contains(msg.Content, substr)
+	}
+}
+
+// HasTool matches requests offering the named tool
+func HasTool(name string) Predicate {
+	return func(req llm.ChatCompletionRequest) bool {
+		for _, tool := range req.Tools {
+			if tool.Function != nil && tool.Function.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Model matches requests for the named model
+func Model(name string) Predicate {
+	return func(req llm.ChatCompletionRequest) bool {
+		return req.Model == name
+	}
+}
+
+// LastMessageRole matches requests whose last message has the given role, e.g. llm.RoleTool
+// to answer once tool results are in
+func LastMessageRole(role llm.Role) Predicate {
+	return func(req llm.ChatCompletionRequest) bool {
+		return len(req.Messages) > 0 && req.Messages[len(req.Messages)-1].Role == role
+	}
+}
+
+// All matches requests matching every predicate
+func All(predicates ...Predicate) Predicate {
+	return func(req llm.ChatCompletionRequest) bool {
+		for _, p := range predicates {
+			if !p(req) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// lastUserMessage returns the last user message of a request
+func lastUserMessage(req llm.ChatCompletionRequest) (llm.Message, bool) {
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == llm.RoleUser {
+			return req.Messages[i], true
+		}
+	}
+	return llm.Message{}, false
+}
+
+// Rule answers the requests matching its predicate with its responses, in order
+type Rule struct {
+	predicate Predicate
+	responses []Response
+	repeat    bool
+}
+
+// Repeatedly keeps answering with the rule's last response once the others are used up
+func (r *Rule) Repeatedly() *Rule {
+	r.repeat = true
+	return r
+}
+
+// LLM is a scriptable llm.LLM. It is safe for concurrent use.
+type LLM struct {
+	mu       sync.Mutex
+	rules    []*Rule
+	queue    []Response
+	requests []llm.ChatCompletionRequest
+	calls    int // Tool calls returned, numbering the generated IDs
+}
+
+// New creates a mock with nothing scripted
+func New() *LLM {
+	return &LLM{}
+}
+
+// Enqueue scripts responses answering requests in order, for requests no rule matches
+func (m *LLM) Enqueue(responses ...Response) *LLM {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queue = append(m.queue, responses...)
+	return m
+}
+
+// When adds a rule answering requests that match predicate with responses, in order. Rules
+// are tried in the order they were added, before the queue. A rule stops matching once its
+// responses are used up, unless it repeats.
+func (m *LLM) When(predicate Predicate, responses ...Response) *Rule {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rule := &Rule{predicate: predicate, responses: responses}
+	m.rules = append(m.rules, rule)
+	return rule
+}
+
+// Requests returns the requests received so far, in order
+func (m *LLM) Requests() []llm.ChatCompletionRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]llm.ChatCompletionRequest(nil), m.requests...)
+}
+
+// LastRequest returns the last request received, and false if there was none
+func (m *LLM) LastRequest() (llm.ChatCompletionRequest, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.requests) == 0 {
+		return llm.ChatCompletionRequest{}, false
+	}
+	return m.requests[len(m.requests)-1], true
+}
+
+// Remaining returns the number of queued and rule responses not used yet. The last response
+// of a repeating rule is never counted.
+func (m *LLM) Remaining() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	remaining := len(m.queue)
+	for _, rule := range m.rules {
+		remaining += len(rule.responses)
+		if rule.repeat && len(rule.responses) > 0 {
+			remaining--
+		}
+	}
+	return remaining
+}
+
+// next records req and returns the response scripted for it
+func (m *LLM) next(req llm.ChatCompletionRequest) (Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests = append(m.requests, req)
+
+	var response Response
+	found := false
+	for _, rule := range m.rules {
+		if len(rule.responses) == 0 || !rule.predicate(req) {
+			continue
+		}
+		response, found = rule.responses[0], true
+		if len(rule.responses) > 1 || !rule.repeat {
+			rule.responses = rule.responses[1:]
+		}
+		break
+	}
+	if !found && len(m.queue) > 0 {
+		response, found = m.queue[0], true
+		m.queue = m.queue[1:]
+	}
+	if !found {
+		last := ""
+		if msg, ok := lastUserMessage(req); ok {
+			last = msg.Content
+		}
+		return Response{}, &llm.APIError{
+			Provider: provider,
+			Code:     "unexpected_request",
+			Message:  fmt.Sprintf("no scripted response for request to model %q with last user message %q", req.Model, last),
+			Err:      ErrUnexpectedRequest,
+		}
+	}
+
+	// Number the tool calls without an ID, on a copy so a repeated response gets new IDs
+	if len(response.Message.ToolCalls) > 0 {
+		calls := append([]llm.ToolCall(nil), response.Message.ToolCalls...)
+		for i := range calls {
+			if calls[i].ID == "" {
+				m.calls++
+				calls[i].ID = fmt.Sprintf("call_%d", m.calls)
+			}
+		}
+		response.Message.ToolCalls = calls
+	}
+	return response, nil
+}
+
+// wait sleeps for d, returning early with the context's error when it is done
+func wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// CreateChatCompletion answers with the response scripted for req
+func (m *LLM) CreateChatCompletion(ctx context.Context, req llm.ChatCompletionRequest) (llm.ChatCompletionResponse, error) {
+	response, err := m.next(req)
+	if err != nil {
+		return llm.ChatCompletionResponse{}, err
+	}
+	if err := wait(ctx, response.Latency); err != nil {
+		return llm.ChatCompletionResponse{}, err
+	}
+	if response.Err != nil {
+		return llm.ChatCompletionResponse{}, response.Err
+	}
+	return llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: response.Message, FinishReason: finishReason(response.Message)}},
+		Usage:   response.Usage,
+	}, nil
+}
+
+// CreateChatCompletionStream streams the response scripted for req: its content in chunks,
+// then its tool calls in a single chunk
+func (m *LLM) CreateChatCompletionStream(ctx context.Context, req llm.ChatCompletionRequest) (llm.ChatCompletionStream, error) {
+	response, err := m.next(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := wait(ctx, response.Latency); err != nil {
+		return nil, err
+	}
+	if response.Err != nil {
+		return nil, response.Err
+	}
+
+	contents := response.Chunks
+	if contents == nil && response.Message.Content != "" {
+		contents = strings.SplitAfter(response.Message.Content, " ")
+	}
+	var chunks []llm.ChatCompletionResponse
+	for _, content := range contents {
+		chunks = append(chunks, llm.ChatCompletionResponse{
+			Choices: []llm.Choice{{Message: llm.Message{Content: content}}},
+		})
+	}
+	if len(response.Message.ToolCalls) > 0 {
+		chunks = append(chunks, llm.ChatCompletionResponse{
+			Choices: []llm.Choice{{Message: llm.Message{ToolCalls: response.Message.ToolCalls}}},
+		})
+	}
+	if len(chunks) > 0 {
+		chunks[0].Choices[0].Message.Role = llm.RoleAssistant
+		last := &chunks[len(chunks)-1]
+		last.Choices[0].FinishReason = finishReason(response.Message)
+		last.Usage = response.Usage
+	}
+	return &stream{ctx: ctx, chunks: chunks, gap: response.ChunkGap, err: response.StreamErr}, nil
+}
+
+// finishReason returns the finish reason a provider reports for message
+func finishReason(message llm.Message) string {
+	if len(message.ToolCalls) > 0 {
+		return "tool_calls"
+	}
+	return "stop"
+}
+
+// stream replays scripted chunks
+type stream struct {
+	ctx    context.Context
+	chunks []llm.ChatCompletionResponse
+	gap    time.Duration
+	err    error
+	sent   int
+	closed bool
+}
+
+func (s *stream) Recv() (llm.ChatCompletionResponse, error) {
+	if s.closed {
+		return llm.ChatCompletionResponse{}, errors.New("mock: stream closed")
+	}
+	if s.sent == len(s.chunks) {
+		if s.err != nil {
+			return llm.ChatCompletionResponse{}, s.err
+		}
+		return llm.ChatCompletionResponse{}, io.EOF
+	}
+	if s.sent > 0 {
+		if err := wait(s.ctx, s.gap); err != nil {
+			return llm.ChatCompletionResponse{}, err
+		}
+	}
+	chunk := s.chunks[s.sent]
+	s.sent++
+	return chunk, nil
+}
+
+func (s *stream) Close() error {
+	s.closed = true
+	return nil
+}
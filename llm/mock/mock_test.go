@@ -0,0 +1,165 @@
+package mock
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/prathyushnallamothu/swarmgo/llm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func userRequest(content string) llm.ChatCompletionRequest {
+	return llm.ChatCompletionRequest{Model: "test-model", Messages: []llm.Message{{Role: llm.RoleUser, Content: content}}}
+}
+
+// TestQueueAndRules tests that rules answer matching requests before the queue
+func TestQueueAndRules(t *testing.T) {
+	m := New()
+	m.Enqueue(ToolCalls(Call("get_weather", `{"city": "Paris"}`)), Text("Queued."))
+	m.When(LastUserMessageContains("refund"), Text("Refunds take 5 days.")).Repeatedly()
+	m.When(Model("other-model"), Text("Other.").WithUsage(llm.Usage{TotalTokens: 15}))
+	assert.Equal(t, 3, m.Remaining())
+
+	resp, err := m.CreateChatCompletion(context.Background(), userRequest("Weather in Paris?"))
+	require.NoError(t, err)
+	assert.Equal(t, "tool_calls", resp.Choices[0].FinishReason)
+	assert.Equal(t, "call_1", resp.Choices[0].Message.ToolCalls[0].ID)
+	assert.Equal(t, "get_weather", resp.Choices[0].Message.ToolCalls[0].Function.Name)
+
+	for i := 0; i < 2; i++ {
+		resp, err = m.CreateChatCompletion(context.Background(), userRequest("Where is my refund?"))
+		require.NoError(t, err)
+		assert.Equal(t, "Refunds take 5 days.", resp.Choices[0].Message.Content)
+	}
+
+	req := userRequest("Hi")
+	req.Model = "other-model"
+	resp, err = m.CreateChatCompletion(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "Other.", resp.Choices[0].Message.Content)
+	assert.Equal(t, 15, resp.Usage.TotalTokens)
+
+	// The used up rule no longer matches, so the queue answers
+	resp, err = m.CreateChatCompletion(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "Queued.", resp.Choices[0].Message.Content)
+	assert.Equal(t, "stop", resp.Choices[0].FinishReason)
+	assert.Equal(t, 0, m.Remaining())
+
+	requests := m.Requests()
+	assert.Len(t, requests, 5)
+	last, ok := m.LastRequest()
+	assert.True(t, ok)
+	assert.Equal(t, "other-model", last.Model)
+
+	_, err = m.CreateChatCompletion(context.Background(), userRequest("Hi"))
+	assert.ErrorIs(t, err, ErrUnexpectedRequest)
+	var apiErr *llm.APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.False(t, apiErr.Retryable)
+}
+
+// TestPredicates tests the request predicates
+func TestPredicates(t *testing.T) {
+	req := llm.ChatCompletionRequest{
+		Model: "test-model",
+		Messages: []llm.Message{
+			{Role: llm.RoleUser, Content: "Weather in Paris?"},
+			{Role: llm.RoleTool, Content: "Sunny"},
+		},
+		Tools: []llm.Tool{{Type: "function", Function: &llm.Function{Name: "get_weather"}}},
+	}
+	assert.True(t, LastUserMessage("Weather in Paris?")(req))
+	assert.False(t, LastUserMessage("Weather")(req))
+	assert.True(t, LastUserMessageContains("Paris")(req))
+	assert.True(t, HasTool("get_weather")(req))
+	assert.False(t, HasTool("get_time")(req))
+	assert.True(t, LastMessageRole(llm.RoleTool)(req))
+	assert.True(t, All(Model("test-model"), HasTool("get_weather"))(req))
+	assert.False(t, All(Model("test-model"), HasTool("get_time"))(req))
+}
+
+// TestRepeatedToolCallsGetNewIDs tests that a repeated response numbers its tool calls again
+func TestRepeatedToolCallsGetNewIDs(t *testing.T) {
+	m := New()
+	m.When(HasTool("get_weather"), ToolCalls(Call("get_weather", `{}`))).Repeatedly()
+	req := userRequest("Hi")
+	req.Tools = []llm.Tool{{Type: "function", Function: &llm.Function{Name: "get_weather"}}}
+
+	for _, id := range []string{"call_1", "call_2"} {
+		resp, err := m.CreateChatCompletion(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, id, resp.Choices[0].Message.ToolCalls[0].ID)
+	}
+}
+
+// TestErrorsAndLatency tests scripted errors and that latency is cut short by the context
+func TestErrorsAndLatency(t *testing.T) {
+	m := New()
+	m.Enqueue(
+		Error(llm.NewAPIError(llm.OpenAI, 400, "invalid_request", "bad request", nil)),
+		Text("Too late.").WithLatency(time.Second),
+	)
+
+	_, err := m.CreateChatCompletion(context.Background(), userRequest("Hi"))
+	var apiErr *llm.APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, 400, apiErr.StatusCode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = m.CreateChatCompletion(ctx, userRequest("Hi"))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+// TestStream tests that a streamed response is split into chunks, then ends with its tool calls
+func TestStream(t *testing.T) {
+	m := New()
+	m.Enqueue(
+		Response{Message: llm.Message{
+			Role:      llm.RoleAssistant,
+			Content:   "Let me check.",
+			ToolCalls: []llm.ToolCall{Call("get_weather", `{}`)},
+		}, Usage: llm.Usage{TotalTokens: 7}},
+		Text("ignored").WithChunks("It's ", "sunny.").WithStreamError(errors.New("connection reset")),
+	)
+
+	stream, err := m.CreateChatCompletionStream(context.Background(), userRequest("Hi"))
+	require.NoError(t, err)
+	var chunks []llm.ChatCompletionResponse
+	for {
+		chunk, err := stream.Recv()
+		if err != nil {
+			assert.ErrorIs(t, err, io.EOF)
+			break
+		}
+		chunks = append(chunks, chunk)
+	}
+	require.Len(t, chunks, 4)
+	assert.Equal(t, llm.RoleAssistant, chunks[0].Choices[0].Message.Role)
+	assert.Equal(t, "Let ", chunks[0].Choices[0].Message.Content)
+	assert.Equal(t, "check.", chunks[2].Choices[0].Message.Content)
+	assert.Equal(t, "call_1", chunks[3].Choices[0].Message.ToolCalls[0].ID)
+	assert.Equal(t, "tool_calls", chunks[3].Choices[0].FinishReason)
+	assert.Equal(t, 7, chunks[3].Usage.TotalTokens)
+	assert.NoError(t, stream.Close())
+
+	stream, err = m.CreateChatCompletionStream(context.Background(), userRequest("Hi"))
+	require.NoError(t, err)
+	var content string
+	for {
+		chunk, err := stream.Recv()
+		if err != nil {
+			assert.EqualError(t, err, "connection reset")
+			break
+		}
+		content += chunk.Choices[0].Message.Content
+	}
+	assert.Equal(t, "It's sunny.", content)
+}
@@ -3,15 +3,18 @@ package llm
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/url"
+	"time"
 
 	"github.com/ollama/ollama/api"
 )
 
 // OllamaLLM implements the LLM interface for Ollama
 type OllamaLLM struct {
+	callLogger
 	client *api.Client
 }
 
@@ -45,7 +48,7 @@ func convertToOllamaRole(role Role) string {
 // convertFromOllamaRole converts Ollama's role string to our Role type
 func convertFromOllamaRole(role string) Role {
 	if role == "tool" {
-		return RoleFunction
+		return RoleTool
 	}
 	return Role(role)
 }
@@ -159,7 +162,8 @@ func convertToOllamaToolCalls(toolCalls []ToolCall) []api.ToolCall {
 	return calls
 }
 
-// convertFromOllamaToolCalls converts Ollama's tool calls to our generic type
+// convertFromOllamaToolCalls converts Ollama's tool calls to our generic type.
+// Ollama doesn't assign call IDs, so a unique one is generated for each call.
 func convertFromOllamaToolCalls(toolCalls []api.ToolCall) []ToolCall {
 	if len(toolCalls) == 0 {
 		return nil
@@ -170,7 +174,7 @@ func convertFromOllamaToolCalls(toolCalls []api.ToolCall) []ToolCall {
 		// Convert api.ToolCallFunctionArguments to map[string]interface{}
 
 		calls[i] = ToolCall{
-			ID:   call.Function.Name, // Using name as ID since Ollama doesn't have a separate ID field
+			ID:   NewToolCallID(),
 			Type: "function",
 			Function: ToolCallFunction{
 				Name:      call.Function.Name,
@@ -181,8 +185,37 @@ func convertFromOllamaToolCalls(toolCalls []api.ToolCall) []ToolCall {
 	return calls
 }
 
+// convertOllamaError converts errors from the Ollama client into an APIError
+func convertOllamaError(err error) error {
+	var statusErr api.StatusError
+	if errors.As(err, &statusErr) {
+		return NewAPIError(Ollama, statusErr.StatusCode, "", statusErr.ErrorMessage, err)
+	}
+	return err
+}
+
+// applyOllamaResponseFormat sets the response format's schema as the request's format
+func applyOllamaResponseFormat(req *api.ChatRequest, format *ResponseFormat) error {
+	if format == nil {
+		return nil
+	}
+	schema, err := json.Marshal(format.Schema)
+	if err != nil {
+		return fmt.Errorf("failed to marshal response schema: %w", err)
+	}
+	req.Format = schema
+	return nil
+}
+
+// SupportsStructuredOutput reports that Ollama natively honors ResponseFormat
+func (o *OllamaLLM) SupportsStructuredOutput() bool {
+	return true
+}
+
 // CreateChatCompletion implements the LLM interface for Ollama
-func (o *OllamaLLM) CreateChatCompletion(ctx context.Context, req ChatCompletionRequest) (ChatCompletionResponse, error) {
+func (o *OllamaLLM) CreateChatCompletion(ctx context.Context, req ChatCompletionRequest) (_ ChatCompletionResponse, err error) {
+	defer func(start time.Time) { o.logCall(ctx, Ollama, "chat completion", req.Model, start, err) }(time.Now())
+
 	stream := false
 	ollamaReq := &api.ChatRequest{
 		Model:    req.Model,
@@ -191,11 +224,14 @@ func (o *OllamaLLM) CreateChatCompletion(ctx context.Context, req ChatCompletion
 		Tools:    convertToOllamaTools(req.Tools),
 		Options:  make(map[string]interface{}),
 	}
+	if err := applyOllamaResponseFormat(ollamaReq, req.ResponseFormat); err != nil {
+		return ChatCompletionResponse{}, err
+	}
 
 	var response ChatCompletionResponse
 	var finalMessage Message
 
-	err := o.client.Chat(ctx, ollamaReq, func(resp api.ChatResponse) error {
+	err = o.client.Chat(ctx, ollamaReq, func(resp api.ChatResponse) error {
 		if resp.Done {
 			finalMessage = Message{
 				Role:      convertFromOllamaRole(resp.Message.Role),
@@ -207,7 +243,7 @@ func (o *OllamaLLM) CreateChatCompletion(ctx context.Context, req ChatCompletion
 	})
 
 	if err != nil {
-		return ChatCompletionResponse{}, fmt.Errorf("Ollama chat completion failed: %w", err)
+		return ChatCompletionResponse{}, fmt.Errorf("Ollama chat completion failed: %w", convertOllamaError(err))
 	}
 
 	response.Choices = []Choice{
@@ -273,7 +309,7 @@ func (s *ollamaStreamWrapper) Recv() (ChatCompletionResponse, error) {
 	}
 
 	if err != nil {
-		return ChatCompletionResponse{}, fmt.Errorf("Ollama stream failed: %w", err)
+		return ChatCompletionResponse{}, fmt.Errorf("Ollama stream failed: %w", convertOllamaError(err))
 	}
 
 	return response, nil
@@ -284,7 +320,9 @@ func (s *ollamaStreamWrapper) Close() error {
 }
 
 // CreateChatCompletionStream implements the LLM interface for Ollama streaming
-func (o *OllamaLLM) CreateChatCompletionStream(ctx context.Context, req ChatCompletionRequest) (ChatCompletionStream, error) {
+func (o *OllamaLLM) CreateChatCompletionStream(ctx context.Context, req ChatCompletionRequest) (_ ChatCompletionStream, err error) {
+	defer func(start time.Time) { o.logCall(ctx, Ollama, "chat completion stream", req.Model, start, err) }(time.Now())
+
 	stream := true
 	ollamaReq := &api.ChatRequest{
 		Model:    req.Model,
@@ -293,6 +331,9 @@ func (o *OllamaLLM) CreateChatCompletionStream(ctx context.Context, req ChatComp
 		Tools:    convertToOllamaTools(req.Tools),
 		Options:  make(map[string]interface{}),
 	}
+	if err := applyOllamaResponseFormat(ollamaReq, req.ResponseFormat); err != nil {
+		return nil, err
+	}
 
 	return newOllamaStreamWrapper(ctx, o.client, ollamaReq), nil
 }
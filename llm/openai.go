@@ -6,12 +6,18 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
 
 	"github.com/sashabaranov/go-openai"
 )
 
 // OpenAILLM implements the LLM interface for OpenAI
 type OpenAILLM struct {
+	callLogger
 	client *openai.Client
 }
 
@@ -28,25 +34,85 @@ func NewOpenAILLMWithHost(apiKey string, host string) *OpenAILLM {
 	return &OpenAILLM{client: openAIClient}
 }
 
+// OpenAIOptions contains optional settings of an OpenAI client. Zero values keep the defaults.
+type OpenAIOptions struct {
+	BaseURL            string       // Base URL of the API, e.g. of an OpenAI compatible server
+	OrgID              string       // Organization the requests are made for
+	AssistantVersion   string       // Version of the Assistants API
+	HTTPClient         *http.Client // Client the requests are sent with
+	EmptyMessagesLimit uint         // Number of empty stream messages tolerated before the stream fails
+	Logger             *slog.Logger // Logger the client writes its calls to, see SetLogger
+}
+
+// NewOpenAILLMWithOptions creates a new OpenAI LLM client with custom settings
+func NewOpenAILLMWithOptions(apiKey string, opts OpenAIOptions) *OpenAILLM {
+	config := openai.DefaultConfig(apiKey)
+	if opts.BaseURL != "" {
+		config.BaseURL = opts.BaseURL
+	}
+	config.OrgID = opts.OrgID
+	if opts.AssistantVersion != "" {
+		config.AssistantVersion = opts.AssistantVersion
+	}
+	if opts.HTTPClient != nil {
+		config.HTTPClient = opts.HTTPClient
+	}
+	if opts.EmptyMessagesLimit > 0 {
+		config.EmptyMessagesLimit = opts.EmptyMessagesLimit
+	}
+	client := &OpenAILLM{client: openai.NewClientWithConfig(config)}
+	client.SetLogger(opts.Logger)
+	return client
+}
+
 // convertToOpenAIMessages converts our generic Message type to OpenAI's message type
 func convertToOpenAIMessages(messages []Message) []openai.ChatCompletionMessage {
 	openAIMessages := make([]openai.ChatCompletionMessage, len(messages))
 	for i, msg := range messages {
+		role := string(msg.Role)
+		// Legacy function results that answer a tool call are sent as tool messages
+		if msg.Role == RoleFunction && msg.ToolCallID != "" {
+			role = openai.ChatMessageRoleTool
+		}
+
 		openAIMessages[i] = openai.ChatCompletionMessage{
-			Role:    string(msg.Role),
-			Content: msg.Content,
-			Name:    msg.Name,
+			Role:       role,
+			Content:    msg.Content,
+			Name:       msg.Name,
+			ToolCalls:  convertToOpenAIToolCalls(msg.ToolCalls),
+			ToolCallID: msg.ToolCallID,
 		}
 	}
 	return openAIMessages
 }
 
+// convertToOpenAIToolCalls converts our generic tool calls to OpenAI's tool call type
+func convertToOpenAIToolCalls(toolCalls []ToolCall) []openai.ToolCall {
+	if len(toolCalls) == 0 {
+		return nil
+	}
+
+	calls := make([]openai.ToolCall, len(toolCalls))
+	for i, call := range toolCalls {
+		calls[i] = openai.ToolCall{
+			ID:   call.ID,
+			Type: openai.ToolTypeFunction,
+			Function: openai.FunctionCall{
+				Name:      call.Function.Name,
+				Arguments: call.Function.Arguments,
+			},
+		}
+	}
+	return calls
+}
+
 // convertFromOpenAIMessage converts OpenAI's message type to our generic Message type
 func convertFromOpenAIMessage(msg openai.ChatCompletionMessage) Message {
 	return Message{
-		Role:    Role(msg.Role),
-		Content: msg.Content,
-		Name:    msg.Name,
+		Role:       Role(msg.Role),
+		Content:    msg.Content,
+		Name:       msg.Name,
+		ToolCallID: msg.ToolCallID,
 	}
 }
 
@@ -97,8 +163,68 @@ func convertFromOpenAIToolCalls(toolCalls []openai.ToolCall) []ToolCall {
 	return calls
 }
 
+// openAIRetryHint matches the retry delay OpenAI embeds in rate limit messages
+var openAIRetryHint = regexp.MustCompile(`try again in (\d+(?:\.\d+)?)(ms|s)`)
+
+// convertOpenAIError converts errors from the OpenAI client into an APIError
+func convertOpenAIError(err error) error {
+	var openAIErr *openai.APIError
+	if errors.As(err, &openAIErr) {
+		code := openAIErr.Type
+		if openAIErr.Code != nil {
+			code = fmt.Sprint(openAIErr.Code)
+		}
+		apiErr := NewAPIError(OpenAI, openAIErr.HTTPStatusCode, code, openAIErr.Message, err)
+
+		// The client doesn't expose response headers, so fall back to the hint in the message
+		if m := openAIRetryHint.FindStringSubmatch(openAIErr.Message); m != nil {
+			if value, parseErr := strconv.ParseFloat(m[1], 64); parseErr == nil {
+				unit := time.Second
+				if m[2] == "ms" {
+					unit = time.Millisecond
+				}
+				apiErr.RetryAfter = time.Duration(value * float64(unit))
+			}
+		}
+		return apiErr
+	}
+
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return NewAPIError(OpenAI, reqErr.HTTPStatusCode, "", "", err)
+	}
+
+	return err
+}
+
+// convertToOpenAIResponseFormat converts our generic ResponseFormat to OpenAI's json_schema response format
+func convertToOpenAIResponseFormat(format *ResponseFormat) *openai.ChatCompletionResponseFormat {
+	if format == nil {
+		return nil
+	}
+	schema, err := json.Marshal(format.Schema)
+	if err != nil {
+		return nil
+	}
+	return &openai.ChatCompletionResponseFormat{
+		Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+		JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+			Name:        format.Name,
+			Description: format.Description,
+			Schema:      json.RawMessage(schema),
+		},
+	}
+}
+
+// SupportsStructuredOutput reports that OpenAI natively honors ResponseFormat
+func (o *OpenAILLM) SupportsStructuredOutput() bool {
+	return true
+}
+
 // CreateChatCompletion implements the LLM interface for OpenAI
-func (o *OpenAILLM) CreateChatCompletion(ctx context.Context, req ChatCompletionRequest) (ChatCompletionResponse, error) {
+func (o *OpenAILLM) CreateChatCompletion(ctx context.Context, req ChatCompletionRequest) (_ ChatCompletionResponse, err error) {
+	defer func(start time.Time) { o.logCall(ctx, OpenAI, "chat completion", req.Model, start, err) }(time.Now())
+
 	openAIReq := openai.ChatCompletionRequest{
 		Model:           req.Model,
 		Messages:        convertToOpenAIMessages(req.Messages),
@@ -109,11 +235,12 @@ func (o *OpenAILLM) CreateChatCompletion(ctx context.Context, req ChatCompletion
 		MaxTokens:       req.MaxTokens,
 		PresencePenalty: req.PresencePenalty,
 		Tools:           convertToOpenAITools(req.Tools),
+		ResponseFormat:  convertToOpenAIResponseFormat(req.ResponseFormat),
 	}
 
 	resp, err := o.client.CreateChatCompletion(ctx, openAIReq)
 	if err != nil {
-		return ChatCompletionResponse{}, err
+		return ChatCompletionResponse{}, convertOpenAIError(err)
 	}
 
 	choices := make([]Choice, len(resp.Choices))
@@ -158,11 +285,7 @@ func (w *openAIStreamWrapper) Recv() (ChatCompletionResponse, error) {
 		if err == io.EOF {
 			return ChatCompletionResponse{}, err
 		}
-		var openAIErr *openai.APIError
-		if errors.As(err, &openAIErr) {
-			return ChatCompletionResponse{}, fmt.Errorf("OpenAI API error: %s - %s", openAIErr.Code, openAIErr.Message)
-		}
-		return ChatCompletionResponse{}, fmt.Errorf("stream receive failed: %w", err)
+		return ChatCompletionResponse{}, fmt.Errorf("stream receive failed: %w", convertOpenAIError(err))
 	}
 
 	choices := make([]Choice, len(resp.Choices))
@@ -247,7 +370,9 @@ func (w *openAIStreamWrapper) Close() error {
 }
 
 // CreateChatCompletionStream implements the LLM interface for OpenAI streaming
-func (o *OpenAILLM) CreateChatCompletionStream(ctx context.Context, req ChatCompletionRequest) (ChatCompletionStream, error) {
+func (o *OpenAILLM) CreateChatCompletionStream(ctx context.Context, req ChatCompletionRequest) (_ ChatCompletionStream, err error) {
+	defer func(start time.Time) { o.logCall(ctx, OpenAI, "chat completion stream", req.Model, start, err) }(time.Now())
+
 	openAIReq := openai.ChatCompletionRequest{
 		Model:           req.Model,
 		Messages:        convertToOpenAIMessages(req.Messages),
@@ -258,16 +383,13 @@ func (o *OpenAILLM) CreateChatCompletionStream(ctx context.Context, req ChatComp
 		MaxTokens:       req.MaxTokens,
 		PresencePenalty: float32(req.PresencePenalty),
 		Tools:           convertToOpenAITools(req.Tools),
+		ResponseFormat:  convertToOpenAIResponseFormat(req.ResponseFormat),
 		Stream:          true,
 	}
 
 	stream, err := o.client.CreateChatCompletionStream(ctx, openAIReq)
 	if err != nil {
-		var openAIErr *openai.APIError
-		if errors.As(err, &openAIErr) {
-			return nil, fmt.Errorf("OpenAI API error: %s - %s", openAIErr.Code, openAIErr.Message)
-		}
-		return nil, fmt.Errorf("stream creation failed: %w", err)
+		return nil, fmt.Errorf("stream creation failed: %w", convertOpenAIError(err))
 	}
 
 	return newOpenAIStreamWrapper(stream), nil
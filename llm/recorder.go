@@ -0,0 +1,391 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrCassetteMismatch is wrapped by the error a replaying Recorder returns for a request
+// that matches no recorded interaction
+var ErrCassetteMismatch = errors.New("request does not match any recorded interaction")
+
+// replayProvider is the provider of the errors a Recorder makes up itself
+const replayProvider LLMProvider = "REPLAY"
+
+// RecorderMode decides whether a Recorder calls the wrapped LLM or answers from its cassette
+type RecorderMode string
+
+const (
+	RecorderRecord RecorderMode = "record" // Call the wrapped LLM and write every interaction to the cassette
+	RecorderReplay RecorderMode = "replay" // Answer from the cassette without calling the wrapped LLM
+)
+
+// Cassette holds the recorded interactions of a Recorder, in the order they were made
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is a recorded request and the answer it got
+type Interaction struct {
+	Request  ChatCompletionRequest    `json:"request"`
+	Stream   bool                     `json:"stream,omitempty"`   // Whether the request opened a stream
+	Response *ChatCompletionResponse  `json:"response,omitempty"` // Response of a request that didn't stream
+	Chunks   []ChatCompletionResponse `json:"chunks,omitempty"`   // Chunks received from a stream, in order
+	Error    *RecordedError           `json:"error,omitempty"`    // Error of the call, or of opening the stream
+
+	StreamError *RecordedError `json:"stream_error,omitempty"` // Error that ended the stream, nil for io.EOF
+}
+
+// RecordedError is an error stored in a cassette. API errors are restored as *APIError.
+type RecordedError struct {
+	Message    string      `json:"message"`
+	Provider   LLMProvider `json:"provider,omitempty"`
+	StatusCode int         `json:"status_code,omitempty"`
+	Code       string      `json:"code,omitempty"`
+	Retryable  bool        `json:"retryable,omitempty"`
+}
+
+// recordError converts an error for storage in a cassette
+func recordError(err error) *RecordedError {
+	if err == nil {
+		return nil
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return &RecordedError{
+			Message:    apiErr.Message,
+			Provider:   apiErr.Provider,
+			StatusCode: apiErr.StatusCode,
+			Code:       apiErr.Code,
+			Retryable:  apiErr.Retryable,
+		}
+	}
+	return &RecordedError{Message: err.Error()}
+}
+
+// err restores a recorded error
+func (e *RecordedError) err() error {
+	if e == nil {
+		return nil
+	}
+	if e.Provider == "" {
+		return errors.New(e.Message)
+	}
+	return &APIError{
+		Provider:   e.Provider,
+		StatusCode: e.StatusCode,
+		Code:       e.Code,
+		Message:    e.Message,
+		Retryable:  e.Retryable,
+	}
+}
+
+// Recorder is an LLM that records the requests sent to another LLM and their answers,
+// including streams, to a cassette file, and replays them from it later, so tests and
+// examples run offline and deterministically. A replayed request must match a recorded
+// one; each recorded interaction is answered once, in any order.
+type Recorder struct {
+	path string
+	mode RecorderMode
+	next LLM
+
+	// Match reports whether a request matches a recorded one. By default their JSON must be equal.
+	Match func(recorded, actual ChatCompletionRequest) bool
+
+	mu       sync.Mutex
+	cassette Cassette
+	used     []bool // Interactions already replayed
+}
+
+// NewRecorder creates a Recorder for the cassette at path. In RecorderRecord mode requests
+// are sent to next and the cassette is rewritten after every interaction; in RecorderReplay
+// mode the cassette must exist and next isn't used, so it may be nil.
+func NewRecorder(path string, mode RecorderMode, next LLM) (*Recorder, error) {
+	r := &Recorder{path: path, mode: mode, next: next}
+	switch mode {
+	case RecorderRecord:
+		if next == nil {
+			return nil, fmt.Errorf("recorder needs an LLM to record from")
+		}
+	case RecorderReplay:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cassette: %w", err)
+		}
+		if err := json.Unmarshal(data, &r.cassette); err != nil {
+			return nil, fmt.Errorf("failed to decode cassette %s: %w", path, err)
+		}
+		r.used = make([]bool, len(r.cassette.Interactions))
+	default:
+		return nil, fmt.Errorf("unknown recorder mode %q", mode)
+	}
+	return r, nil
+}
+
+// Remaining returns the number of recorded interactions that haven't been replayed,
+// e.g. to check that a test made every request it was recorded with
+func (r *Recorder) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	remaining := 0
+	for _, used := range r.used {
+		if !used {
+			remaining++
+		}
+	}
+	return remaining
+}
+
+// CreateChatCompletion records or replays a chat completion
+func (r *Recorder) CreateChatCompletion(ctx context.Context, req ChatCompletionRequest) (ChatCompletionResponse, error) {
+	if r.mode == RecorderReplay {
+		interaction, err := r.replay(req, false)
+		if err != nil {
+			return ChatCompletionResponse{}, err
+		}
+		if interaction.Error != nil || interaction.Response == nil {
+			return ChatCompletionResponse{}, interaction.Error.err()
+		}
+		return *interaction.Response, nil
+	}
+
+	resp, err := r.next.CreateChatCompletion(ctx, req)
+	interaction := Interaction{Request: req, Error: recordError(err)}
+	if err == nil {
+		interaction.Response = &resp
+	}
+	if saveErr := r.record(interaction); saveErr != nil {
+		return resp, saveErr
+	}
+	return resp, err
+}
+
+// CreateChatCompletionStream records or replays a streaming chat completion
+func (r *Recorder) CreateChatCompletionStream(ctx context.Context, req ChatCompletionRequest) (ChatCompletionStream, error) {
+	if r.mode == RecorderReplay {
+		interaction, err := r.replay(req, true)
+		if err != nil {
+			return nil, err
+		}
+		if interaction.Error != nil {
+			return nil, interaction.Error.err()
+		}
+		return &replayStream{chunks: interaction.Chunks, err: interaction.StreamError.err()}, nil
+	}
+
+	stream, err := r.next.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		if saveErr := r.record(Interaction{Request: req, Stream: true, Error: recordError(err)}); saveErr != nil {
+			return nil, saveErr
+		}
+		return nil, err
+	}
+	return &recordingStream{recorder: r, stream: stream, interaction: Interaction{Request: req, Stream: true}}, nil
+}
+
+// record appends an interaction to the cassette and writes it
+func (r *Recorder) record(interaction Interaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+
+	data, err := json.MarshalIndent(r.cassette, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode cassette: %w", err)
+	}
+	if err := os.WriteFile(r.path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return nil
+}
+
+// replay returns the first unused interaction matching req, or an error showing how req
+// differs from the closest unused one
+func (r *Recorder) replay(req ChatCompletionRequest, stream bool) (Interaction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	match := r.Match
+	if match == nil {
+		match = func(recorded, actual ChatCompletionRequest) bool {
+			return requestJSON(recorded) == requestJSON(actual)
+		}
+	}
+
+	actual := requestJSON(req)
+	closest, closestDiff, closestChanges := -1, "", 0
+	for i, interaction := range r.cassette.Interactions {
+		if r.used[i] || interaction.Stream != stream {
+			continue
+		}
+		if match(interaction.Request, req) {
+			r.used[i] = true
+			return interaction, nil
+		}
+		diff, changes := diffLines(requestJSON(interaction.Request), actual)
+		if closest < 0 || changes < closestChanges {
+			closest, closestDiff, closestChanges = i, diff, changes
+		}
+	}
+
+	kind := "request"
+	if stream {
+		kind = "stream request"
+	}
+	message := fmt.Sprintf("no unused %s left in cassette %s", kind, r.path)
+	if closest >= 0 {
+		message = fmt.Sprintf("%s differs from interaction %d of cassette %s (- recorded, + actual):\n%s",
+			kind, closest, r.path, closestDiff)
+	}
+	return Interaction{}, &APIError{
+		Provider: replayProvider,
+		Code:     "cassette_mismatch",
+		Message:  message,
+		Err:      ErrCassetteMismatch,
+	}
+}
+
+// requestJSON renders a request for comparison and diffs
+func requestJSON(req ChatCompletionRequest) string {
+	data, err := json.MarshalIndent(req, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("%+v", req)
+	}
+	return string(data)
+}
+
+// diffLines returns a line diff of recorded and actual, showing changed lines with two
+// lines of context, along with the number of changed lines
+func diffLines(recorded, actual string) (string, int) {
+	x, y := strings.Split(recorded, "\n"), strings.Split(actual, "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	type line struct {
+		op   byte
+		text string
+	}
+	var lines []line
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			lines = append(lines, line{' ', x[i]})
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, line{'-', x[i]})
+			i++
+		default:
+			lines = append(lines, line{'+', y[j]})
+			j++
+		}
+	}
+
+	const contextLines = 2
+	var b strings.Builder
+	changes, skipped := 0, false
+	for k, l := range lines {
+		if l.op != ' ' {
+			changes++
+		}
+		near := false
+		for d := max(0, k-contextLines); d <= min(len(lines)-1, k+contextLines); d++ {
+			if lines[d].op != ' ' {
+				near = true
+				break
+			}
+		}
+		if !near {
+			if !skipped {
+				b.WriteString("  ...\n")
+				skipped = true
+			}
+			continue
+		}
+		skipped = false
+		fmt.Fprintf(&b, "%c %s\n", l.op, l.text)
+	}
+	return b.String(), changes
+}
+
+// recordingStream passes a stream through and records its chunks once it ends or is closed
+type recordingStream struct {
+	recorder    *Recorder
+	stream      ChatCompletionStream
+	interaction Interaction
+	done        bool
+}
+
+func (s *recordingStream) Recv() (ChatCompletionResponse, error) {
+	chunk, err := s.stream.Recv()
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			s.interaction.StreamError = recordError(err)
+		}
+		if saveErr := s.finish(); saveErr != nil {
+			return chunk, saveErr
+		}
+		return chunk, err
+	}
+	s.interaction.Chunks = append(s.interaction.Chunks, chunk)
+	return chunk, nil
+}
+
+func (s *recordingStream) Close() error {
+	err := s.stream.Close()
+	if saveErr := s.finish(); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return err
+}
+
+// finish records the stream's interaction once
+func (s *recordingStream) finish() error {
+	if s.done {
+		return nil
+	}
+	s.done = true
+	return s.recorder.record(s.interaction)
+}
+
+// replayStream replays recorded chunks, then the recorded error or io.EOF
+type replayStream struct {
+	chunks []ChatCompletionResponse
+	err    error
+}
+
+func (s *replayStream) Recv() (ChatCompletionResponse, error) {
+	if len(s.chunks) == 0 {
+		if s.err != nil {
+			return ChatCompletionResponse{}, s.err
+		}
+		return ChatCompletionResponse{}, io.EOF
+	}
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return chunk, nil
+}
+
+func (s *replayStream) Close() error {
+	return nil
+}
@@ -0,0 +1,161 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptedLLM answers requests with its responses and streams, in order
+type scriptedLLM struct {
+	responses []ChatCompletionResponse
+	streams   [][]ChatCompletionResponse
+	err       error
+	calls     int
+}
+
+func (s *scriptedLLM) CreateChatCompletion(ctx context.Context, req ChatCompletionRequest) (ChatCompletionResponse, error) {
+	s.calls++
+	if s.err != nil {
+		return ChatCompletionResponse{}, s.err
+	}
+	resp := s.responses[0]
+	s.responses = s.responses[1:]
+	return resp, nil
+}
+
+func (s *scriptedLLM) CreateChatCompletionStream(ctx context.Context, req ChatCompletionRequest) (ChatCompletionStream, error) {
+	s.calls++
+	chunks := s.streams[0]
+	s.streams = s.streams[1:]
+	return &replayStream{chunks: chunks, err: s.err}, nil
+}
+
+// readStream returns the content of a stream's chunks and the error that ended it
+func readStream(t *testing.T, stream ChatCompletionStream) (string, error) {
+	t.Helper()
+	var content string
+	for {
+		chunk, err := stream.Recv()
+		if err != nil {
+			return content, err
+		}
+		content += chunk.Choices[0].Message.Content
+	}
+}
+
+func userRequest(content string) ChatCompletionRequest {
+	return ChatCompletionRequest{Model: "test-model", Messages: []Message{{Role: RoleUser, Content: content}}}
+}
+
+// TestRecorderReplaysInteractions tests that recorded completions and streams replay offline, in any order
+func TestRecorderReplaysInteractions(t *testing.T) {
+	cassette := filepath.Join(t.TempDir(), "weather.json")
+	next := &scriptedLLM{
+		responses: []ChatCompletionResponse{
+			{Choices: []Choice{{Message: Message{Role: RoleAssistant, Content: "It's sunny."}}}},
+			{Choices: []Choice{{Message: Message{Role: RoleAssistant, Content: "It's raining."}}}},
+		},
+		streams: [][]ChatCompletionResponse{{
+			{Choices: []Choice{{Message: Message{Role: RoleAssistant, Content: "It's "}}}},
+			{Choices: []Choice{{Message: Message{Content: "cloudy."}}}},
+		}},
+	}
+
+	recorder, err := NewRecorder(cassette, RecorderRecord, next)
+	require.NoError(t, err)
+	paris, err := recorder.CreateChatCompletion(context.Background(), userRequest("Weather in Paris?"))
+	require.NoError(t, err)
+	rome, err := recorder.CreateChatCompletion(context.Background(), userRequest("Weather in Rome?"))
+	require.NoError(t, err)
+	stream, err := recorder.CreateChatCompletionStream(context.Background(), userRequest("Weather in Oslo?"))
+	require.NoError(t, err)
+	content, err := readStream(t, stream)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, "It's cloudy.", content)
+
+	replayer, err := NewRecorder(cassette, RecorderReplay, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, replayer.Remaining())
+
+	stream, err = replayer.CreateChatCompletionStream(context.Background(), userRequest("Weather in Oslo?"))
+	require.NoError(t, err)
+	content, err = readStream(t, stream)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, "It's cloudy.", content)
+
+	resp, err := replayer.CreateChatCompletion(context.Background(), userRequest("Weather in Rome?"))
+	require.NoError(t, err)
+	assert.Equal(t, rome, resp)
+	resp, err = replayer.CreateChatCompletion(context.Background(), userRequest("Weather in Paris?"))
+	require.NoError(t, err)
+	assert.Equal(t, paris, resp)
+	assert.Equal(t, 0, replayer.Remaining())
+	assert.Equal(t, 3, next.calls)
+
+	// Each interaction is answered once
+	_, err = replayer.CreateChatCompletion(context.Background(), userRequest("Weather in Paris?"))
+	assert.ErrorIs(t, err, ErrCassetteMismatch)
+	assert.Contains(t, err.Error(), "no unused request left")
+}
+
+// TestRecorderReportsMismatch tests that a changed request is reported with a diff against the closest recorded one
+func TestRecorderReportsMismatch(t *testing.T) {
+	cassette := filepath.Join(t.TempDir(), "weather.json")
+	next := &scriptedLLM{responses: []ChatCompletionResponse{
+		{Choices: []Choice{{Message: Message{Role: RoleAssistant, Content: "It's sunny."}}}},
+	}}
+	recorder, err := NewRecorder(cassette, RecorderRecord, next)
+	require.NoError(t, err)
+	_, err = recorder.CreateChatCompletion(context.Background(), userRequest("Weather in Paris?"))
+	require.NoError(t, err)
+
+	replayer, err := NewRecorder(cassette, RecorderReplay, nil)
+	require.NoError(t, err)
+	_, err = replayer.CreateChatCompletion(context.Background(), userRequest("Weather in Rome?"))
+	assert.ErrorIs(t, err, ErrCassetteMismatch)
+	assert.Contains(t, err.Error(), `-       "content": "Weather in Paris?"`)
+	assert.Contains(t, err.Error(), `+       "content": "Weather in Rome?"`)
+
+	// A custom matcher can ignore what changes between runs
+	replayer.Match = func(recorded, actual ChatCompletionRequest) bool {
+		return recorded.Model == actual.Model
+	}
+	resp, err := replayer.CreateChatCompletion(context.Background(), userRequest("Weather in Rome?"))
+	require.NoError(t, err)
+	assert.Equal(t, "It's sunny.", resp.Choices[0].Message.Content)
+}
+
+// TestRecorderReplaysErrors tests that recorded API errors replay as *APIError
+func TestRecorderReplaysErrors(t *testing.T) {
+	cassette := filepath.Join(t.TempDir(), "errors.json")
+	next := &scriptedLLM{err: NewAPIError(OpenAI, 429, "rate_limit_exceeded", "slow down", nil)}
+	recorder, err := NewRecorder(cassette, RecorderRecord, next)
+	require.NoError(t, err)
+	_, err = recorder.CreateChatCompletion(context.Background(), userRequest("Hi"))
+	require.Error(t, err)
+
+	replayer, err := NewRecorder(cassette, RecorderReplay, nil)
+	require.NoError(t, err)
+	_, err = replayer.CreateChatCompletion(context.Background(), userRequest("Hi"))
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, 429, apiErr.StatusCode)
+	assert.Equal(t, "rate_limit_exceeded", apiErr.Code)
+	assert.Equal(t, "slow down", apiErr.Message)
+}
+
+// TestNewRecorderValidates tests the arguments NewRecorder rejects
+func TestNewRecorderValidates(t *testing.T) {
+	_, err := NewRecorder(filepath.Join(t.TempDir(), "cassette.json"), RecorderRecord, nil)
+	assert.Error(t, err)
+	_, err = NewRecorder(filepath.Join(t.TempDir(), "missing.json"), RecorderReplay, nil)
+	assert.Error(t, err)
+	_, err = NewRecorder(filepath.Join(t.TempDir(), "cassette.json"), "rewind", &scriptedLLM{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,178 @@
+package swarmgo
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"os"
+
+	"github.com/prathyushnallamothu/swarmgo/llm"
+)
+
+// LevelTrace is the slog level of LogTrace, for the most detailed records
+const LevelTrace = slog.LevelDebug - 4
+
+// defaultHandler writes text records to stderr for Swarms without a logger. The
+// levelHandler around it drops the records below the configured LogLevel.
+var defaultHandler slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: LevelTrace})
+
+// defaultLogLevel is the LogLevel of loggers without a config
+var defaultLogLevel = DefaultConfig().LogLevel
+
+// Level returns the lowest slog level written at this log level. Nothing is written at LogSilent.
+func (l LogLevel) Level() slog.Level {
+	switch l {
+	case LogSilent:
+		return slog.Level(math.MaxInt)
+	case LogError:
+		return slog.LevelError
+	case LogWarning:
+		return slog.LevelWarn
+	case LogInfo:
+		return slog.LevelInfo
+	case LogDebug:
+		return slog.LevelDebug
+	default:
+		return LevelTrace
+	}
+}
+
+// SetLogger sets the logger the Swarm and the clients it builds write to, instead of
+// Config.Logger or a text logger on stderr when nil. Records below Config.LogLevel are
+// dropped before they reach it, except that debug records are kept for calls made with
+// debug set or when Config.Debug is set.
+func (s *Swarm) SetLogger(logger *slog.Logger) {
+	s.mu.Lock()
+	if logger == nil && s.config != nil {
+		logger = s.config.Logger
+	}
+	s.logger = newLogger(s.config, logger)
+	logger = s.logger
+	clients := s.ownClients()
+	s.mu.Unlock()
+
+	for _, client := range clients {
+		if setter, ok := client.(llm.LoggerSetter); ok {
+			setter.SetLogger(logger)
+		}
+	}
+}
+
+// getLogger returns the logger the Swarm writes to
+func (s *Swarm) getLogger() *slog.Logger {
+	if s == nil {
+		return newLogger(nil, nil)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loggerLocked()
+}
+
+// loggerLocked returns the logger the Swarm writes to, made from its config on first
+// use. s.mu must be held.
+func (s *Swarm) loggerLocked() *slog.Logger {
+	if s.logger == nil {
+		var logger *slog.Logger
+		if s.config != nil {
+			logger = s.config.Logger
+		}
+		s.logger = newLogger(s.config, logger)
+	}
+	return s.logger
+}
+
+// log writes a record with the run fields of ctx, if the level is enabled for a call
+// made with the given debug flag
+func (s *Swarm) log(ctx context.Context, debug bool, level slog.Level, msg string, args ...any) {
+	if debug {
+		ctx = context.WithValue(ctx, debugKey{}, true)
+	}
+	logTo(ctx, s.getLogger(), level, msg, args...)
+}
+
+// logTo writes a record with the run fields of ctx to logger, if it is enabled for level
+func logTo(ctx context.Context, logger *slog.Logger, level slog.Level, msg string, args ...any) {
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	logger.Log(ctx, level, msg, append(logFields(ctx), args...)...)
+}
+
+// newLogger returns a logger writing to logger, or to stderr when nil, that drops the
+// records below the LogLevel of config
+func newLogger(config *Config, logger *slog.Logger) *slog.Logger {
+	handler := defaultHandler
+	if logger != nil {
+		handler = logger.Handler()
+	}
+	return slog.New(&levelHandler{handler: handler, config: config})
+}
+
+// debugKey is the context key marking records of calls made with debug set
+type debugKey struct{}
+
+// levelHandler passes the records at or above the LogLevel of a config to handler.
+// Debug records pass too when Config.Debug is set or the record's context is marked
+// with debugKey.
+type levelHandler struct {
+	handler slog.Handler
+	config  *Config
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	logLevel, debug := defaultLogLevel, false
+	if h.config != nil {
+		logLevel, debug = h.config.LogLevel, h.config.Debug
+	}
+	minLevel := logLevel.Level()
+	if (debug || ctx.Value(debugKey{}) != nil) && minLevel > slog.LevelDebug {
+		minLevel = slog.LevelDebug
+	}
+	return level >= minLevel && h.handler.Enabled(ctx, level)
+}
+
+func (h *levelHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.handler.Handle(ctx, record)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{handler: h.handler.WithAttrs(attrs), config: h.config}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{handler: h.handler.WithGroup(name), config: h.config}
+}
+
+// logAttrsKey is the context key of the fields added with withLogFields
+type logAttrsKey struct{}
+
+// withLogFields returns a context whose records carry the given fields, e.g. the model of
+// an LLM call or the tool of a tool call
+func withLogFields(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	combined := append(append([]slog.Attr{}, existing...), attrs...)
+	return context.WithValue(ctx, logAttrsKey{}, combined)
+}
+
+// logFields returns the fields of the records written under ctx: the run ID, turn and
+// agent of the run, then the fields added with withLogFields
+func logFields(ctx context.Context) []any {
+	var fields []any
+	if info, ok := ctx.Value(runInfoKey{}).(runInfo); ok {
+		fields = append(fields, slog.String("run_id", info.id))
+		if info.parent != "" {
+			fields = append(fields, slog.String("parent_run_id", info.parent))
+		}
+		if info.turn > 0 {
+			fields = append(fields, slog.Int("turn", info.turn))
+		}
+		if info.agent != "" {
+			fields = append(fields, slog.String("agent", info.agent))
+		}
+	}
+	attrs, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	for _, attr := range attrs {
+		fields = append(fields, attr)
+	}
+	return fields
+}
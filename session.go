@@ -0,0 +1,388 @@
+package swarmgo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prathyushnallamothu/swarmgo/llm"
+)
+
+var (
+	// ErrSessionNotFound is returned by a SessionStore that has no session with the requested ID
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrUnknownSessionAgent is returned by RunSession when the session's active agent isn't among its agents
+	ErrUnknownSessionAgent = errors.New("session agent not found")
+	// ErrSessionPendingApproval is returned by RunSession while the session waits for approval decisions
+	ErrSessionPendingApproval = errors.New("session is waiting for approval, use ResumeSession")
+	// ErrNoPendingApproval is returned by ResumeSession when the session isn't waiting for approval
+	ErrNoPendingApproval = errors.New("session has no pending approval")
+)
+
+// Session is a conversation kept between runs, so callers don't have to hold on to it
+type Session struct {
+	ID               string                 `json:"id"`
+	Messages         []llm.Message          `json:"messages"`          // Every message of the conversation, in order
+	History          []llm.Message          `json:"history,omitempty"` // What the active agent continues with after a filtered handoff, nil for all of Messages
+	AgentName        string                 `json:"agent_name"`        // Agent that handles the next message, empty for a new session
+	ContextVariables map[string]interface{} `json:"context_variables"` // Context variables as of the last run
+	Pending          *SessionApproval       `json:"pending,omitempty"` // Tool calls waiting for approval, nil when the session isn't paused
+	CreatedAt        time.Time              `json:"created_at"`
+	UpdatedAt        time.Time              `json:"updated_at"`
+}
+
+// SessionApproval is the state of a session paused for approval, enough to resume its run
+type SessionApproval struct {
+	Message   llm.Message       `json:"message"`    // Assistant message making the calls, not yet in Messages
+	ToolCalls []PendingToolCall `json:"tool_calls"` // Calls waiting for a decision, in call order
+	Turns     int               `json:"turns"`      // Model calls the run made before the pause
+}
+
+// NewSession creates an empty session, with a random ID when id is empty
+func NewSession(id string) *Session {
+	if id == "" {
+		id = uuid.New().String()
+	}
+	now := time.Now()
+	return &Session{
+		ID:               id,
+		ContextVariables: make(map[string]interface{}),
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+}
+
+// Append adds new messages to the session, and to the history of its active agent
+func (s *Session) Append(messages ...llm.Message) {
+	s.Messages = append(s.Messages, messages...)
+	if s.History != nil {
+		s.History = append(s.History, messages...)
+	}
+}
+
+// AgentHistory returns the conversation the session's active agent continues with: its
+// History after a handoff filtered the conversation, all of its Messages otherwise
+func (s *Session) AgentHistory() []llm.Message {
+	if s.History != nil {
+		return s.History
+	}
+	return s.Messages
+}
+
+// Record appends the messages of a run to the session and keeps its active agent and
+// context variables. After a handoff whose history filter changed the conversation, the
+// next agent continues with the filtered history while Messages keeps every message.
+func (s *Session) Record(response Response) {
+	s.Append(response.Messages...)
+	if response.Handoff != nil && response.Handoff.Filtered {
+		s.History = cloneMessages(response.Handoff.Messages)
+	}
+	if response.Agent != nil {
+		s.AgentName = response.Agent.Name
+	}
+	if response.ContextVariables != nil {
+		s.ContextVariables = response.ContextVariables
+	}
+	s.UpdatedAt = time.Now()
+}
+
+// clone returns a copy of the session that shares no slices or maps with it
+func (s *Session) clone() *Session {
+	c := *s
+	c.Messages = cloneMessages(s.Messages)
+	if s.History != nil {
+		c.History = cloneMessages(s.History)
+	}
+	c.ContextVariables = copyContextVariables(s.ContextVariables)
+	if s.Pending != nil {
+		pending := *s.Pending
+		pending.ToolCalls = append([]PendingToolCall(nil), s.Pending.ToolCalls...)
+		c.Pending = &pending
+	}
+	return &c
+}
+
+// SessionStore persists sessions. Messages are only ever appended to a session,
+// so stores may skip rewriting the ones they already hold. Context variables must
+// be JSON-serializable for stores that encode them, and come back as decoded JSON.
+type SessionStore interface {
+	Load(ctx context.Context, id string) (*Session, error) // Returns ErrSessionNotFound if there is no such session
+	Save(ctx context.Context, session *Session) error
+	Delete(ctx context.Context, id string) error
+}
+
+// LoadOrCreateSession loads a session from store, or creates a new one when it doesn't exist yet
+func LoadOrCreateSession(ctx context.Context, store SessionStore, id string) (*Session, error) {
+	session, err := store.Load(ctx, id)
+	if errors.Is(err, ErrSessionNotFound) {
+		return NewSession(id), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session %s: %w", id, err)
+	}
+	return session, nil
+}
+
+// RunSession continues the conversation of a session with new messages. The session is
+// loaded from store or created, the messages are run by the session's active agent, and
+// the run's messages, active agent and context variables are saved back. A new session
+// starts with the first of agents; the active agent is looked up by name among agents
+// and the agents they hand off to, so a handoff carries over to the next message.
+// A run stopping with StopPendingApproval leaves the calls waiting in Session.Pending;
+// continue it with ResumeSession before sending the session new messages.
+func (s *Swarm) RunSession(
+	ctx context.Context,
+	store SessionStore,
+	sessionID string,
+	agents []*Agent,
+	messages []llm.Message,
+	maxTurns int,
+) (Response, error) {
+	if len(agents) == 0 {
+		return Response{}, ErrNilAgent
+	}
+	session, err := LoadOrCreateSession(ctx, store, sessionID)
+	if err != nil {
+		return Response{}, err
+	}
+
+	if session.Pending != nil {
+		return Response{}, fmt.Errorf("%w: %s", ErrSessionPendingApproval, session.ID)
+	}
+	agent, err := session.activeAgent(agents)
+	if err != nil {
+		return Response{}, err
+	}
+
+	session.Append(messages...)
+	response, err := s.Run(ctx, agent, session.AgentHistory(), session.ContextVariables, "", false, false, maxTurns, true)
+	if err != nil {
+		return response, err
+	}
+	return response, s.saveSession(ctx, store, session, response)
+}
+
+// ResumeSession continues the run of a session paused for approval, with a decision for
+// every call in Session.Pending, and saves the outcome as RunSession does
+func (s *Swarm) ResumeSession(
+	ctx context.Context,
+	store SessionStore,
+	sessionID string,
+	agents []*Agent,
+	maxTurns int,
+	decisions ...ApprovalDecision,
+) (Response, error) {
+	if len(agents) == 0 {
+		return Response{}, ErrNilAgent
+	}
+	session, err := store.Load(ctx, sessionID)
+	if err != nil {
+		return Response{}, fmt.Errorf("failed to load session %s: %w", sessionID, err)
+	}
+	if session.Pending == nil {
+		return Response{}, fmt.Errorf("%w: %s", ErrNoPendingApproval, session.ID)
+	}
+	agent, err := session.activeAgent(agents)
+	if err != nil {
+		return Response{}, err
+	}
+
+	pending := &PendingApproval{
+		Agent:            agent,
+		Messages:         append(cloneMessages(session.AgentHistory()), session.Pending.Message),
+		ContextVariables: session.ContextVariables,
+		ToolCalls:        session.Pending.ToolCalls,
+		Turns:            session.Pending.Turns,
+		maxTurns:         maxTurns,
+	}
+	response, err := s.Resume(ctx, pending, decisions...)
+	if err != nil {
+		return response, err
+	}
+	session.Pending = nil
+	return response, s.saveSession(ctx, store, session, response)
+}
+
+// activeAgent returns the agent handling the session's next message
+func (s *Session) activeAgent(agents []*Agent) (*Agent, error) {
+	if s.AgentName == "" {
+		return agents[0], nil
+	}
+	agent := findAgent(agents, s.AgentName)
+	if agent == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSessionAgent, s.AgentName)
+	}
+	return agent, nil
+}
+
+// saveSession records a run in the session, keeping the calls of a run paused for
+// approval apart from the messages until they are decided, and saves it to store
+func (s *Swarm) saveSession(ctx context.Context, store SessionStore, session *Session, response Response) error {
+	session.Record(response)
+	if p := response.PendingApproval; response.StopReason == StopPendingApproval && p != nil {
+		session.Pending = &SessionApproval{
+			Message:   p.Messages[len(p.Messages)-1],
+			ToolCalls: p.ToolCalls,
+			Turns:     p.Turns,
+		}
+	}
+	if err := store.Save(ctx, session); err != nil {
+		return fmt.Errorf("failed to save session %s: %w", session.ID, err)
+	}
+	return nil
+}
+
+// findAgent looks up an agent by name among agents and the agents reachable through their handoffs
+func findAgent(agents []*Agent, name string) *Agent {
+	seen := make(map[*Agent]bool)
+	queue := append([]*Agent(nil), agents...)
+	for len(queue) > 0 {
+		agent := queue[0]
+		queue = queue[1:]
+		if agent == nil || seen[agent] {
+			continue
+		}
+		seen[agent] = true
+		if agent.Name == name {
+			return agent
+		}
+		for _, h := range agent.Handoffs {
+			queue = append(queue, h.Agent)
+		}
+	}
+	return nil
+}
+
+// MemorySessionStore keeps sessions in memory, e.g. for tests or a single process
+type MemorySessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+}
+
+// NewMemorySessionStore creates an empty in-memory session store
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]*Session)}
+}
+
+// Load returns a copy of the session
+func (m *MemorySessionStore) Load(ctx context.Context, id string) (*Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+	return session.clone(), nil
+}
+
+// Save stores a copy of the session
+func (m *MemorySessionStore) Save(ctx context.Context, session *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[session.ID] = session.clone()
+	return nil
+}
+
+// Delete removes the session, if it exists
+func (m *MemorySessionStore) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+// FileSessionStore keeps each session in a JSON file named after its ID in a directory
+type FileSessionStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileSessionStore creates a session store writing to dir, creating it if needed
+func NewFileSessionStore(dir string) (*FileSessionStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create session directory: %w", err)
+	}
+	return &FileSessionStore{dir: dir}, nil
+}
+
+// path returns the file of a session
+func (f *FileSessionStore) path(id string) (string, error) {
+	return jsonFilePath(f.dir, id)
+}
+
+// jsonFilePath returns the JSON file of a stored item in dir, rejecting IDs that would escape it
+func jsonFilePath(dir, id string) (string, error) {
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+		return "", fmt.Errorf("invalid ID %q", id)
+	}
+	return filepath.Join(dir, id+".json"), nil
+}
+
+// writeFileAtomic replaces the file at path with data through a temporary file
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load reads the session's file
+func (f *FileSessionStore) Load(ctx context.Context, id string) (*Session, error) {
+	path, err := f.path(id)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	data, err := os.ReadFile(path)
+	f.mu.Unlock()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to decode session %s: %w", id, err)
+	}
+	return &session, nil
+}
+
+// Save writes the session's file, replacing it atomically
+func (f *FileSessionStore) Save(ctx context.Context, session *Session) error {
+	path, err := f.path(session.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode session %s: %w", session.ID, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return writeFileAtomic(path, data)
+}
+
+// Delete removes the session's file, if it exists
+func (f *FileSessionStore) Delete(ctx context.Context, id string) error {
+	path, err := f.path(id)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
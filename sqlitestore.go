@@ -0,0 +1,186 @@
+package swarmgo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prathyushnallamothu/swarmgo/llm"
+)
+
+// sqliteSessionSchema creates the tables of SQLiteSessionStore
+const sqliteSessionSchema = `
+CREATE TABLE IF NOT EXISTS swarm_sessions (
+	id                TEXT PRIMARY KEY,
+	agent_name        TEXT NOT NULL,
+	context_variables TEXT NOT NULL,
+	history           TEXT,
+	pending           TEXT,
+	created_at        TIMESTAMP NOT NULL,
+	updated_at        TIMESTAMP NOT NULL
+);
+CREATE TABLE IF NOT EXISTS swarm_session_messages (
+	session_id TEXT NOT NULL REFERENCES swarm_sessions(id) ON DELETE CASCADE,
+	position   INTEGER NOT NULL,
+	message    TEXT NOT NULL,
+	PRIMARY KEY (session_id, position)
+);`
+
+// SQLiteSessionStore keeps sessions in a SQLite database, one row per message, so saving
+// a session only writes the messages added since it was last saved. The history of the
+// active agent after a filtered handoff and any calls waiting for approval are kept with
+// the session row. Open the database with
+// the mattn/go-sqlite3 driver:
+//
+//	import _ "github.com/mattn/go-sqlite3"
+//
+//	db, err := sql.Open("sqlite3", "sessions.db")
+type SQLiteSessionStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteSessionStore creates the session tables in db if they don't exist yet
+func NewSQLiteSessionStore(ctx context.Context, db *sql.DB) (*SQLiteSessionStore, error) {
+	if _, err := db.ExecContext(ctx, sqliteSessionSchema); err != nil {
+		return nil, fmt.Errorf("failed to create session tables: %w", err)
+	}
+	return &SQLiteSessionStore{db: db}, nil
+}
+
+// Load reads the session and its messages
+func (s *SQLiteSessionStore) Load(ctx context.Context, id string) (*Session, error) {
+	session := Session{ID: id}
+	var variables string
+	var history, pending sql.NullString
+	err := s.db.QueryRowContext(ctx,
+		`SELECT agent_name, context_variables, history, pending, created_at, updated_at FROM swarm_sessions WHERE id = ?`, id,
+	).Scan(&session.AgentName, &variables, &history, &pending, &session.CreatedAt, &session.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(variables), &session.ContextVariables); err != nil {
+		return nil, fmt.Errorf("failed to decode context variables of session %s: %w", id, err)
+	}
+	if history.Valid {
+		if err := json.Unmarshal([]byte(history.String), &session.History); err != nil {
+			return nil, fmt.Errorf("failed to decode history of session %s: %w", id, err)
+		}
+	}
+	if pending.Valid {
+		if err := json.Unmarshal([]byte(pending.String), &session.Pending); err != nil {
+			return nil, fmt.Errorf("failed to decode pending approval of session %s: %w", id, err)
+		}
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT message FROM swarm_session_messages WHERE session_id = ? ORDER BY position`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var msg llm.Message
+		if err := json.Unmarshal([]byte(data), &msg); err != nil {
+			return nil, fmt.Errorf("failed to decode message of session %s: %w", id, err)
+		}
+		session.Messages = append(session.Messages, msg)
+	}
+	return &session, rows.Err()
+}
+
+// Save writes the session and the messages that aren't stored yet in one transaction
+func (s *SQLiteSessionStore) Save(ctx context.Context, session *Session) error {
+	variables, err := json.Marshal(session.ContextVariables)
+	if err != nil {
+		return fmt.Errorf("failed to encode context variables of session %s: %w", session.ID, err)
+	}
+	var history sql.NullString
+	if session.History != nil {
+		data, err := json.Marshal(session.History)
+		if err != nil {
+			return fmt.Errorf("failed to encode history of session %s: %w", session.ID, err)
+		}
+		history = sql.NullString{String: string(data), Valid: true}
+	}
+	var pending sql.NullString
+	if session.Pending != nil {
+		data, err := json.Marshal(session.Pending)
+		if err != nil {
+			return fmt.Errorf("failed to encode pending approval of session %s: %w", session.ID, err)
+		}
+		pending = sql.NullString{String: string(data), Valid: true}
+	}
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = time.Now()
+	}
+	if session.UpdatedAt.IsZero() {
+		session.UpdatedAt = session.CreatedAt
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO swarm_sessions (id, agent_name, context_variables, history, pending, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			agent_name = excluded.agent_name,
+			context_variables = excluded.context_variables,
+			history = excluded.history,
+			pending = excluded.pending,
+			updated_at = excluded.updated_at`,
+		session.ID, session.AgentName, string(variables), history, pending, session.CreatedAt, session.UpdatedAt,
+	); err != nil {
+		return err
+	}
+
+	var stored int
+	if err := tx.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM swarm_session_messages WHERE session_id = ?`, session.ID,
+	).Scan(&stored); err != nil {
+		return err
+	}
+	for i := stored; i < len(session.Messages); i++ {
+		data, err := json.Marshal(session.Messages[i])
+		if err != nil {
+			return fmt.Errorf("failed to encode message of session %s: %w", session.ID, err)
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO swarm_session_messages (session_id, position, message) VALUES (?, ?, ?)`,
+			session.ID, i, string(data),
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Delete removes the session and its messages, if it exists
+func (s *SQLiteSessionStore) Delete(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM swarm_session_messages WHERE session_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM swarm_sessions WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
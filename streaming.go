@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"time"
 
 	"github.com/prathyushnallamothu/swarmgo/llm"
 )
@@ -35,21 +37,35 @@ func (s *Swarm) StreamingResponse(
 	modelOverride string,
 	handler StreamHandler,
 	debug bool,
+) error {
+	ctx = startRunInfo(ctx, agent.Name)
+	ctx = withTurn(ctx, 1, agent.Name)
+	s.emit(ctx, RunEvent{Type: EventRunStart})
+
+	err := s.streamingResponse(ctx, agent, messages, contextVariables, modelOverride, handler, debug)
+	s.emit(ctx, RunEvent{Type: EventRunEnd, Err: err})
+	return err
+}
+
+// streamingResponse implements StreamingResponse, once the run's ID is set on ctx
+func (s *Swarm) streamingResponse(
+	ctx context.Context,
+	agent *Agent,
+	messages []llm.Message,
+	contextVariables map[string]interface{},
+	modelOverride string,
+	handler StreamHandler,
+	debug bool,
 ) error {
 	if handler == nil {
 		handler = &DefaultStreamHandler{}
 	}
+	agent = agent.snapshot()
 
 	if contextVariables == nil {
 		contextVariables = make(map[string]interface{})
 	}
 
-	if debug {
-		fmt.Printf("Debug: Using model: %s\n", agent.Model)
-		fmt.Printf("Debug: Number of messages: %d\n", len(messages))
-		fmt.Printf("Debug: Number of tools: %d\n", len(agent.Functions))
-	}
-
 	// Prepare the initial system message with agent instructions
 	instructions := agent.Instructions
 	if agent.InstructionsFunc != nil {
@@ -64,11 +80,9 @@ func (s *Swarm) StreamingResponse(
 
 	// Build tool definitions
 	var tools []llm.Tool
-	for _, af := range agent.Functions {
+	for _, af := range agent.tools() {
 		def := FunctionToDefinition(af)
-		if debug {
-			fmt.Printf("Debug: Adding tool: %s\n", def.Name)
-		}
+		s.log(ctx, debug, LevelTrace, "adding tool", "tool", def.Name)
 		tools = append(tools, llm.Tool{
 			Type: "function",
 			Function: &llm.Function{
@@ -80,15 +94,10 @@ func (s *Swarm) StreamingResponse(
 	}
 
 	// Prepare the streaming request
-	model := agent.Model
-	if modelOverride != "" {
-		model = modelOverride
-	}
+	model := s.modelFor(agent, modelOverride)
 
-	if debug {
-		fmt.Printf("Debug: Final model: %s\n", model)
-		fmt.Printf("Debug: Creating stream with %d messages\n", len(allMessages))
-	}
+	s.log(ctx, debug, slog.LevelDebug, "creating stream",
+		"model", model, "messages", len(allMessages), "tools", len(tools))
 
 	req := llm.ChatCompletionRequest{
 		Model:    model,
@@ -97,11 +106,21 @@ func (s *Swarm) StreamingResponse(
 		Stream:   true,
 	}
 
-	stream, err := s.client.CreateChatCompletionStream(ctx, req)
+	client, err := s.clientFor(agent)
 	if err != nil {
-		if debug {
-			fmt.Printf("Debug: Stream creation error: %v\n", err)
-		}
+		handler.OnError(err)
+		return err
+	}
+
+	fitted, err := s.fitContextWindow(ctx, client, req)
+	if err != nil {
+		handler.OnError(err)
+		return err
+	}
+
+	stream, err := s.createChatCompletionStream(ctx, client, fitted)
+	if err != nil {
+		s.log(ctx, debug, slog.LevelError, "failed to create stream", "error", err)
 		handler.OnError(fmt.Errorf("failed to create chat completion stream: %v", err))
 		return err
 	}
@@ -117,18 +136,27 @@ func (s *Swarm) StreamingResponse(
 	toolCallsInProgress := make(map[string]*llm.ToolCall)
 	processedToolCalls := make(map[string]bool)
 
-	// createNewStream creates a new stream and handles errors
+	// createNewStream creates a new stream for the next turn and handles errors
+	turn := 1
 	createNewStream := func() error {
 		if err := stream.Close(); err != nil {
 			handler.OnError(fmt.Errorf("failed to close stream: %v", err))
 			return err
 		}
 
-		newStream, err := s.client.CreateChatCompletionStream(ctx, req)
+		turn++
+		ctx = withTurn(ctx, turn, agent.Name)
+
+		// The conversation grows with every tool result, so check it against the window again
+		fitted, err := s.fitContextWindow(ctx, client, req)
 		if err != nil {
-			if debug {
-				fmt.Printf("Debug: Error creating new stream: %v\n", err)
-			}
+			handler.OnError(err)
+			return err
+		}
+
+		newStream, err := s.createChatCompletionStream(ctx, client, fitted)
+		if err != nil {
+			s.log(ctx, debug, slog.LevelError, "failed to create stream after tool call", "error", err)
 			handler.OnError(fmt.Errorf("failed to create new stream after tool call: %v", err))
 			return err
 		}
@@ -145,6 +173,11 @@ func (s *Swarm) StreamingResponse(
 			response, err := stream.Recv()
 			if err != nil {
 				if err.Error() == "EOF" {
+					s.emit(ctx, RunEvent{
+						Type:     EventLLMResponse,
+						Request:  &req,
+						Response: &llm.ChatCompletionResponse{Choices: []llm.Choice{{Message: currentMessage}}},
+					})
 					handler.OnComplete(currentMessage)
 					return nil
 				}
@@ -155,9 +188,7 @@ func (s *Swarm) StreamingResponse(
 					}
 					continue
 				}
-				if debug {
-					fmt.Printf("Debug: Error receiving from stream: %v\n", err)
-				}
+				s.log(ctx, debug, slog.LevelError, "error receiving from stream", "error", err)
 				handler.OnError(fmt.Errorf("error receiving from stream: %v", err))
 				return err
 			}
@@ -177,24 +208,19 @@ func (s *Swarm) StreamingResponse(
 			// Handle tool calls
 			if len(choice.Message.ToolCalls) > 0 {
 				for _, toolCall := range choice.Message.ToolCalls {
-					if debug {
-						fmt.Printf("Debug: Processing tool call: ID=%s Name=%s\n",
-							toolCall.ID, toolCall.Function.Name)
-					}
+					s.log(ctx, debug, LevelTrace, "received tool call chunk",
+						"tool_call_id", toolCall.ID, "tool", toolCall.Function.Name)
 
 					// Skip empty tool calls
 					if toolCall.ID == "" {
-						if debug {
-							fmt.Printf("Debug: Skipping empty tool call ID\n")
-						}
+						s.log(ctx, debug, LevelTrace, "skipping tool call chunk without ID")
 						continue
 					}
 
 					// Skip if we've already processed this tool call
 					if processedToolCalls[toolCall.ID] {
-						if debug {
-							fmt.Printf("Debug: Skipping already processed tool call: %s\n", toolCall.ID)
-						}
+						s.log(ctx, debug, LevelTrace, "skipping already processed tool call",
+							"tool_call_id", toolCall.ID)
 						continue
 					}
 
@@ -210,50 +236,34 @@ func (s *Swarm) StreamingResponse(
 							},
 						}
 						toolCallsInProgress[toolCall.ID] = inProgress
-						if debug {
-							fmt.Printf("Debug: Created new tool call: %s, Name: %s\n",
-								toolCall.ID, toolCall.Function.Name)
-						}
+						s.log(ctx, debug, LevelTrace, "created tool call",
+							"tool_call_id", toolCall.ID, "tool", toolCall.Function.Name)
 					}
 
 					// Update function name if provided
 					if toolCall.Function.Name != "" && inProgress.Function.Name == "" {
 						inProgress.Function.Name = toolCall.Function.Name
-						if debug {
-							fmt.Printf("Debug: Updated function name for tool call %s: %s\n",
-								toolCall.ID, toolCall.Function.Name)
-						}
+						s.log(ctx, debug, LevelTrace, "updated tool call name",
+							"tool_call_id", toolCall.ID, "tool", toolCall.Function.Name)
 					}
 
-					if debug {
-						fmt.Printf("Debug: Updated arguments for tool call %s: %s\n",
-							toolCall.ID, toolCall.Function.Arguments)
-					}
+					s.log(ctx, debug, LevelTrace, "updated tool call arguments",
+						"tool_call_id", toolCall.ID, "arguments", toolCall.Function.Arguments)
 
 					// Try to parse the arguments to verify it's complete JSON
 					var args map[string]interface{}
 
 					if toolCall.Function.Arguments != "" {
-						if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &args); err == nil {
-							if debug {
-								fmt.Printf("Debug: Valid JSON arguments for tool call %s: %v\n",
-									toolCall.ID, args)
-							}
-						} else if debug {
-							fmt.Printf("Debug: Incomplete JSON for tool call %s: %v\n", toolCall.ID, err)
+						if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &args); err != nil {
+							s.log(ctx, debug, LevelTrace, "incomplete tool call arguments",
+								"tool_call_id", toolCall.ID, "error", err)
 						}
 					}
 
 					// Only execute if we haven't processed this tool call yet
 					if !processedToolCalls[toolCall.ID] {
 						// Find and execute the corresponding function
-						var fn *AgentFunction
-						for _, f := range agent.Functions {
-							if f.Name == inProgress.Function.Name {
-								fn = &f
-								break
-							}
-						}
+						fn := findFunction(agent, inProgress.Function.Name)
 
 						if fn == nil {
 							err := fmt.Errorf("unknown function: %s", inProgress.Function.Name)
@@ -261,26 +271,56 @@ func (s *Swarm) StreamingResponse(
 							continue
 						}
 
-						if debug {
-							fmt.Printf("Debug: Executing function %s with args: %v\n",
-								inProgress.Function.Name, args)
+						// Streams can't pause, so sensitive tools are never run from them
+						if fn.needsApproval(args, contextVariables) {
+							err := fmt.Errorf("%w: %s", ErrApprovalRequired, fn.Name)
+							handler.OnError(err)
+							return err
 						}
 
-						// Execute the function
-						result := fn.Function(args, contextVariables)
+						// Execute the function through the tool middleware, stopping if the stream is cancelled
+						toolCtx := withLogFields(ctx, slog.String("tool", fn.Name), slog.String("tool_call_id", inProgress.ID))
+						s.log(toolCtx, debug, slog.LevelDebug, "processing tool call", "args", args)
+						s.emit(ctx, RunEvent{Type: EventToolStart, ToolCall: inProgress})
+						before := copyContextVariables(contextVariables)
+						start := time.Now()
+						result, calledArgs, err := s.invokeTool(ctx, ToolInvocation{
+							Agent:            agent,
+							Function:         fn,
+							CallID:           inProgress.ID,
+							Args:             args,
+							ContextVariables: contextVariables,
+						})
+						if err != nil {
+							s.log(toolCtx, debug, slog.LevelError, "tool call aborted", "error", err)
+							s.emit(ctx, RunEvent{Type: EventToolEnd, ToolCall: inProgress, Err: err})
+							handler.OnError(err)
+							return err
+						}
+						s.emit(ctx, RunEvent{
+							Type:     EventToolEnd,
+							ToolCall: inProgress,
+							ToolResult: &ToolResult{
+								ToolName:   fn.Name,
+								ToolCallID: inProgress.ID,
+								Args:       calledArgs,
+								Result:     result,
+								Duration:   time.Since(start),
+								ErrorKind:  toolErrorKind(result.Error),
+							},
+							Err: result.Error,
+						})
+						s.emitContextVariableChanges(ctx, before, contextVariables)
 
 						// Create function response message
 						var resultContent string
 						if result.Error != nil {
-							resultContent = fmt.Sprintf("Error: %v", result.Error)
-							if debug {
-								fmt.Printf("Debug: Function execution error: %v\n", result.Error)
-							}
+							resultContent = formatToolError(result.Error)
+							s.log(toolCtx, debug, slog.LevelWarn, "tool returned an error",
+								"kind", toolErrorKind(result.Error), "error", result.Error)
 						} else {
-							resultContent = fmt.Sprintf("%v", result.Data)
-							if debug {
-								fmt.Printf("Debug: Function execution success: %v\n", result.Data)
-							}
+							resultContent = formatToolContent(result.Data)
+							s.log(toolCtx, debug, slog.LevelDebug, "tool call succeeded")
 						}
 
 						// Mark as processed and clean up
@@ -291,31 +331,22 @@ func (s *Swarm) StreamingResponse(
 						currentMessage.ToolCalls = append(currentMessage.ToolCalls, *inProgress)
 						handler.OnToolCall(*inProgress)
 
-						// Add function response message
-						functionMessage := llm.Message{
-							Role:    llm.RoleFunction,
-							Content: resultContent,
-							Name:    inProgress.Function.Name,
-						}
+						// Add the tool message answering this call
+						functionMessage := newToolMessage(inProgress, resultContent, result.Error != nil)
 
 						// Add messages and create new stream
 						allMessages = append(allMessages, currentMessage)
 						allMessages = append(allMessages, functionMessage)
 						req.Messages = allMessages
 
-						if debug {
-							fmt.Printf("Debug: Added function response message: %s = %s\n",
-								functionMessage.Name, functionMessage.Content)
-						}
+						s.log(toolCtx, debug, LevelTrace, "added tool result message", "content", functionMessage.Content)
 
 						if err := createNewStream(); err != nil {
 							handler.OnError(fmt.Errorf("failed to create new stream after tool call: %v", err))
 							return err
 						}
 
-						if debug {
-							fmt.Printf("Debug: Created new stream after tool call, messages count: %d\n", len(allMessages))
-						}
+						s.log(ctx, debug, slog.LevelDebug, "created stream after tool call", "messages", len(allMessages))
 
 						// Reset current message for new response
 						currentMessage = llm.Message{
@@ -0,0 +1,159 @@
+package swarmgo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/prathyushnallamothu/swarmgo/llm"
+)
+
+// ErrInvalidStructuredOutput is returned by RunStructured when no reply of the model matched the schema
+var ErrInvalidStructuredOutput = errors.New("model did not return valid structured output")
+
+// structuredOutputPrompt asks models without a native structured output mode for JSON matching a schema
+const structuredOutputPrompt = "Reply with your final answer as a JSON object matching this JSON schema, without any other text:\n%s"
+
+// invalidSchemaChars matches the characters providers don't accept in a schema name
+var invalidSchemaChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// RunStructured runs agent like Run and decodes its final reply into a T, which must be
+// a struct type. The JSON schema of T is sent through the provider's native structured
+// output mode where it has one, and added to the instructions otherwise. A reply that
+// isn't a JSON object matching T is sent back to the model along with the validation
+// error, up to maxRetries times, after which ErrInvalidStructuredOutput is returned.
+// The Response covers every attempt.
+func RunStructured[T any](
+	ctx context.Context,
+	s *Swarm,
+	agent *Agent,
+	messages []llm.Message,
+	contextVariables map[string]interface{},
+	modelOverride string,
+	maxTurns int,
+	maxRetries int,
+) (T, Response, error) {
+	var out T
+	format, err := responseFormatFor(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return out, Response{}, err
+	}
+	if contextVariables == nil {
+		contextVariables = make(map[string]interface{})
+	}
+
+	history := cloneMessages(messages)
+	combined := Response{Agent: agent, ContextVariables: contextVariables}
+	var lastErr error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		response, err := s.runWithOptions(ctx, combined.Agent, history, contextVariables, modelOverride,
+			false, false, maxTurns, true, runOptions{responseFormat: format})
+		combined.GuardrailEvents = append(combined.GuardrailEvents, response.GuardrailEvents...)
+		if err != nil {
+			return out, combined, err
+		}
+		contextVariables = response.ContextVariables
+		combined.ContextVariables = contextVariables
+
+		combined.Messages = append(combined.Messages, response.Messages...)
+		combined.ToolResults = append(combined.ToolResults, response.ToolResults...)
+		combined.Agent = response.Agent
+		combined.StopReason = response.StopReason
+		combined.Turns += response.Turns
+		combined.Usage.Merge(response.Usage)
+		history = append(history, response.Messages...)
+
+		if response.StopReason != StopFinalMessage {
+			return out, combined, fmt.Errorf("%w: run stopped without a final reply (%s)",
+				ErrInvalidStructuredOutput, response.StopReason)
+		}
+
+		reply := response.Messages[len(response.Messages)-1].Content
+		if lastErr = decodeStructuredOutput(reply, format.Schema, &out); lastErr == nil {
+			return out, combined, nil
+		}
+
+		// Ask the model to correct its reply
+		retry := llm.Message{
+			Role: llm.RoleUser,
+			Content: fmt.Sprintf("Your reply did not match the required JSON schema: %v. "+
+				"Reply again with only the corrected JSON object.", lastErr),
+		}
+		history = append(history, retry)
+		combined.Messages = append(combined.Messages, retry)
+	}
+
+	return out, combined, fmt.Errorf("%w after %d attempts: %v", ErrInvalidStructuredOutput, maxRetries+1, lastErr)
+}
+
+// responseFormatFor builds the response format describing values of type t
+func responseFormatFor(t reflect.Type) (*llm.ResponseFormat, error) {
+	schema, err := reflectParameters(t)
+	if err != nil {
+		return nil, fmt.Errorf("structured output: %w", err)
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	name := invalidSchemaChars.ReplaceAllString(t.Name(), "_")
+	if name == "" {
+		name = "response"
+	}
+	return &llm.ResponseFormat{Name: name, Schema: schema}, nil
+}
+
+// applyResponseFormat requests the response format through the client's native structured
+// output mode, or by adding the schema to the system message when it has none
+func applyResponseFormat(req llm.ChatCompletionRequest, client llm.LLM, format *llm.ResponseFormat) (llm.ChatCompletionRequest, error) {
+	if format == nil {
+		return req, nil
+	}
+	if supporter, ok := client.(llm.StructuredOutputSupporter); ok && supporter.SupportsStructuredOutput() {
+		req.ResponseFormat = format
+		return req, nil
+	}
+
+	schema, err := json.Marshal(format.Schema)
+	if err != nil {
+		return req, fmt.Errorf("failed to marshal response schema: %w", err)
+	}
+	instruction := fmt.Sprintf(structuredOutputPrompt, schema)
+
+	// Providers differ in how they treat several system messages, so extend the first one
+	messages := make([]llm.Message, 0, len(req.Messages)+1)
+	if len(req.Messages) > 0 && req.Messages[0].Role == llm.RoleSystem {
+		system := req.Messages[0]
+		system.Content += "\n\n" + instruction
+		messages = append(messages, system)
+		messages = append(messages, req.Messages[1:]...)
+	} else {
+		messages = append(messages, llm.Message{Role: llm.RoleSystem, Content: instruction})
+		messages = append(messages, req.Messages...)
+	}
+	req.Messages = messages
+	return req, nil
+}
+
+// decodeStructuredOutput validates a reply against the schema and decodes it into target.
+// Replies wrapped in a markdown code block are accepted.
+func decodeStructuredOutput(reply string, schema map[string]interface{}, target interface{}) error {
+	reply = strings.TrimSpace(reply)
+	if strings.HasPrefix(reply, "```") {
+		reply = strings.TrimPrefix(reply, "```")
+		if newline := strings.Index(reply, "\n"); newline >= 0 {
+			reply = reply[newline+1:]
+		}
+		reply = strings.TrimSpace(strings.TrimSuffix(reply, "```"))
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(reply), &raw); err != nil {
+		return fmt.Errorf("reply is not a JSON object: %v", err)
+	}
+	return decodeToolArgs(raw, schema, target)
+}
@@ -5,8 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-	"strings"
+	"log/slog"
+	"reflect"
+	"sync"
 	"time"
 
 	"github.com/prathyushnallamothu/swarmgo/llm"
@@ -22,12 +23,25 @@ var (
 	ErrMessageTooLong    = errors.New("message exceeds maximum token limit")
 )
 
+// DefaultMaxTurns is the turn limit used by Run when maxTurns is zero or less
+const DefaultMaxTurns = 10
+
 // Swarm represents the main structure
 type Swarm struct {
 	client       llm.LLM
+	provider     llm.LLMProvider  // Provider of the default client, empty for custom implementations
+	apiKey       string           // API key of the default client
 	tokenCounter func(string) int // Optional token counter function
 	initialized  bool             // Flag to check if Swarm is properly initialized
 	config       *Config          // Configuration settings
+
+	mu             sync.Mutex            // Guards clients, middleware, hooks and the logger
+	clients        map[clientKey]llm.LLM // Clients for agents with their own provider or config
+	middleware     []llm.Middleware      // Middleware wrapped around every LLM call, outermost first
+	toolMiddleware []ToolMiddleware      // Middleware wrapped around every tool call, outermost first
+	hooks          []RunHooks            // Hooks observing every run
+	checkpoints    CheckpointStore       // Store the progress of runs is checkpointed to, if any
+	logger         *slog.Logger          // Logger records are written to, the default logger when nil
 }
 
 // Config holds configuration options for Swarm
@@ -35,13 +49,24 @@ type Config struct {
 	MaxRetries        int
 	RetryBackoff      time.Duration
 	RequestTimeout    time.Duration
-	MaxTokens         int
+	MaxTokens         int // Tokens kept free for the completion when a request doesn't set MaxTokens
 	DefaultModel      string
 	Debug             bool
 	LogLevel          LogLevel
+	Logger            *slog.Logger   // Logger the Swarm and its clients write to, a text logger on stderr when nil
 	TokenLimits       map[string]int // Model-specific token limits
 	FailureHandlers   []FailureHandler
 	RateLimitStrategy RateLimitStrategy
+	// MaxParallelToolCalls limits how many tool calls run at once for agents with
+	// ParallelToolCalls enabled. Zero or less runs all calls of a turn at once.
+	MaxParallelToolCalls int
+	// Pricing maps model names to their prices, used to estimate the cost of a run
+	Pricing map[string]ModelPricing
+	// ContextStrategy decides what happens when a prompt exceeds the model's token limit
+	ContextStrategy ContextStrategy
+	// SummaryModel writes the summaries of the ContextSummarize strategy, defaults to the agent's model.
+	// It is called through the agent's client, so it must be served by the agent's provider.
+	SummaryModel string
 }
 
 // LogLevel represents the level of logging
@@ -56,7 +81,8 @@ const (
 	LogTrace
 )
 
-// FailureHandler defines a function to handle specific failures
+// FailureHandler defines a function to handle specific failures. It returns true if
+// it handled the error, along with nil to retry the call or an error to abort it.
 type FailureHandler func(error) (bool, error)
 
 // RateLimitStrategy defines how rate limits are handled
@@ -79,10 +105,29 @@ func DefaultConfig() *Config {
 		Debug:          false,
 		LogLevel:       LogError,
 		TokenLimits: map[string]int{
-			"gpt-3.5-turbo": 4096,
-			"gpt-4":         8192,
-			"gpt-4o":        128000,
-			"claude-3-opus": 200000,
+			"gpt-3.5-turbo":     16385,
+			"gpt-4":             8192,
+			"gpt-4-turbo":       128000,
+			"gpt-4o":            128000,
+			"gpt-4o-mini":       128000,
+			"gpt-4.1":           1047576,
+			"gpt-4.1-mini":      1047576,
+			"gpt-4.1-nano":      1047576,
+			"o1":                200000,
+			"o3":                200000,
+			"o3-mini":           200000,
+			"o4-mini":           200000,
+			"claude-3-opus":     200000,
+			"claude-3-sonnet":   200000,
+			"claude-3-haiku":    200000,
+			"claude-3-5-sonnet": 200000,
+			"claude-3-5-haiku":  200000,
+			"claude-3-7-sonnet": 200000,
+			"claude-sonnet-4":   200000,
+			"claude-opus-4":     200000,
+			"gemini-1.5-pro":    2097152,
+			"gemini-1.5-flash":  1048576,
+			"gemini-2.0-flash":  1048576,
 		},
 		RateLimitStrategy: RateLimitRetry,
 	}
@@ -95,89 +140,59 @@ func NewSwarm(apiKey string, provider llm.LLMProvider) *Swarm {
 
 // NewSwarmWithConfig initializes a new Swarm with custom configuration
 func NewSwarmWithConfig(apiKey string, provider llm.LLMProvider, config *Config) *Swarm {
-	if apiKey == "" {
-		log.Println("Warning: Empty API key provided")
-		return &Swarm{
-			initialized: false,
-			config:      config,
-		}
+	if config == nil {
+		config = DefaultConfig()
 	}
 
-	var client llm.LLM
-	var err error
-
-	switch provider {
-	case llm.OpenAI:
-		client = llm.NewOpenAILLM(apiKey)
-	case llm.Gemini:
-		client, err = llm.NewGeminiLLM(apiKey)
-		if err != nil {
-			log.Printf("Failed to create Gemini client: %v", err)
-			return &Swarm{
-				initialized: false,
-				config:      config,
-			}
-		}
-	case llm.Claude:
-		client = llm.NewClaudeLLM(apiKey)
-	case llm.Ollama:
-		client, err = llm.NewOllamaLLM()
-		if err != nil {
-			log.Printf("Failed to create Ollama client: %v", err)
-			return &Swarm{
-				initialized: false,
-				config:      config,
-			}
-		}
-	case llm.DeepSeek:
-		client = llm.NewDeepSeekLLM(apiKey)
-	default:
-		log.Printf("Unsupported LLM provider: %v", provider)
-		return &Swarm{
-			initialized: false,
-			config:      config,
-		}
+	swarm := &Swarm{
+		provider: provider,
+		config:   config,
 	}
-
-	// Verify the client was created properly
-	if client == nil {
-		log.Println("Warning: Failed to initialize LLM client")
-		return &Swarm{
-			initialized: false,
-			config:      config,
-		}
+	if apiKey == "" {
+		swarm.log(context.Background(), false, slog.LevelWarn, "empty API key provided", "provider", provider)
+		return swarm
 	}
+	swarm.apiKey = apiKey
 
-	return &Swarm{
-		client:      client,
-		initialized: true,
-		config:      config,
+	client, err := newLLMClient(clientKey{provider: provider}, apiKey, swarm.getLogger())
+	if err != nil {
+		swarm.log(context.Background(), false, slog.LevelError, "failed to create client",
+			"provider", provider, "error", err)
+		return swarm
 	}
+	swarm.client = client
+	swarm.initialized = true
+	return swarm
 }
 
 // NewSwarmWithHost creates a Swarm with a custom host
 func NewSwarmWithHost(apiKey, host string, provider llm.LLMProvider) *Swarm {
+	swarm := &Swarm{config: DefaultConfig()}
 	if provider == llm.OpenAI {
 		client := llm.NewOpenAILLMWithHost(apiKey, host)
 		if client == nil {
-			log.Println("Warning: Failed to initialize OpenAI client with custom host")
-			return &Swarm{
-				initialized: false,
-				config:      DefaultConfig(),
-			}
-		}
-		return &Swarm{
-			client:      client,
-			initialized: true,
-			config:      DefaultConfig(),
+			swarm.log(context.Background(), false, slog.LevelWarn,
+				"failed to initialize OpenAI client with custom host", "host", host)
+			return swarm
 		}
+		client.SetLogger(swarm.getLogger())
+		swarm.client = client
+		swarm.provider = provider
+		swarm.apiKey = apiKey
+		swarm.initialized = true
+		return swarm
 	}
-	log.Printf("Custom host not supported for provider: %v", provider)
+	swarm.log(context.Background(), false, slog.LevelWarn,
+		"custom host not supported for provider", "provider", provider, "host", host)
 	return NewSwarm(apiKey, provider)
 }
 
 // NewSwarmWithCustomProvider creates a Swarm with a custom LLM provider implementation
 func NewSwarmWithCustomProvider(providerImpl llm.LLM, config *Config) *Swarm {
+	if config == nil {
+		config = DefaultConfig()
+	}
+
 	return &Swarm{
 		client:      providerImpl,
 		initialized: true,
@@ -190,6 +205,40 @@ func (s *Swarm) SetTokenCounter(counter func(string) int) {
 	s.tokenCounter = counter
 }
 
+// Use adds middleware around every LLM call made by the Swarm, both sync and
+// streaming, whichever client serves the agent. Middleware added first is the
+// outermost. It applies to calls made after Use returns.
+func (s *Swarm) Use(middleware ...llm.Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.middleware = append(s.middleware, middleware...)
+}
+
+// callMiddlewareKey is the context key of middleware added to the LLM calls of a single run
+type callMiddlewareKey struct{}
+
+// withCallMiddleware returns a context whose LLM calls go through middleware, inside
+// the Swarm's own, e.g. for a graph running its agent nodes on a Swarm it doesn't own
+func withCallMiddleware(ctx context.Context, middleware ...llm.Middleware) context.Context {
+	if len(middleware) == 0 {
+		return ctx
+	}
+	existing, _ := ctx.Value(callMiddlewareKey{}).([]llm.Middleware)
+	combined := append(append([]llm.Middleware{}, existing...), middleware...)
+	return context.WithValue(ctx, callMiddlewareKey{}, combined)
+}
+
+// withMiddleware wraps client with the middleware added through Use, then that of ctx
+func (s *Swarm) withMiddleware(ctx context.Context, client llm.LLM) llm.LLM {
+	s.mu.Lock()
+	middleware := s.middleware
+	s.mu.Unlock()
+	if perCall, _ := ctx.Value(callMiddlewareKey{}).([]llm.Middleware); len(perCall) > 0 {
+		middleware = append(append([]llm.Middleware{}, middleware...), perCall...)
+	}
+	return llm.Chain(client, middleware...)
+}
+
 // IsInitialized returns whether the Swarm is properly initialized
 func (s *Swarm) IsInitialized() bool {
 	return s.initialized && s.client != nil
@@ -211,7 +260,7 @@ func (s *Swarm) ValidateConnection(ctx context.Context) error {
 	}
 
 	// Attempt to send the request
-	_, err := s.client.CreateChatCompletion(ctx, testRequest)
+	_, err := s.withMiddleware(ctx, s.client).CreateChatCompletion(ctx, testRequest)
 	if err != nil {
 		return fmt.Errorf("connection test failed: %w", err)
 	}
@@ -219,7 +268,7 @@ func (s *Swarm) ValidateConnection(ctx context.Context) error {
 	return nil
 }
 
-// getChatCompletion requests a chat completion from the LLM with retries and error handling
+// getChatCompletion requests the agent's next chat completion from the LLM with retries and error handling
 func (s *Swarm) getChatCompletion(
 	ctx context.Context,
 	agent *Agent,
@@ -228,157 +277,207 @@ func (s *Swarm) getChatCompletion(
 	modelOverride string,
 	stream bool,
 	debug bool,
+	opts runOptions,
 ) (llm.ChatCompletionResponse, error) {
-	// Validate inputs
-	if !s.IsInitialized() {
-		return llm.ChatCompletionResponse{}, ErrLLMClientNotReady
-	}
-
 	if agent == nil {
 		return llm.ChatCompletionResponse{}, ErrNilAgent
 	}
 
-	if len(history) == 0 {
-		// Instead of failing, create an empty initial message
-		history = []llm.Message{}
+	if contextVariables == nil {
+		contextVariables = make(map[string]interface{})
 	}
 
-	// Prepare the initial system message with agent instructions
-	instructions := agent.Instructions
-	if agent.InstructionsFunc != nil {
-		if contextVariables == nil {
-			contextVariables = make(map[string]interface{})
-		}
-		instructions = agent.InstructionsFunc(contextVariables)
-	}
+	req := s.buildRequest(agent, history, contextVariables, modelOverride)
 
-	if instructions != "" {
-		// Add system message at the beginning
-		messages := []llm.Message{
-			{
-				Role:    llm.RoleSystem,
-				Content: instructions,
-			},
-		}
-		messages = append(messages, history...)
-		history = messages
-	}
+	s.log(ctx, debug, slog.LevelDebug, "building chat completion",
+		"model", req.Model, "messages", len(req.Messages), "tools", len(req.Tools))
 
-	// Build tool definitions from agent's functions
-	var tools []llm.Tool
-	if agent.Functions != nil {
-		for _, af := range agent.Functions {
-			def := FunctionToDefinition(af)
-			tools = append(tools, llm.Tool{
-				Type: "function",
-				Function: &llm.Function{
-					Name:        def.Name,
-					Description: def.Description,
-					Parameters:  def.Parameters,
-				},
-			})
-		}
+	client, err := s.clientFor(agent)
+	if err != nil {
+		return llm.ChatCompletionResponse{}, err
 	}
 
-	// Prepare the chat completion request
-	model := agent.Model
-	if modelOverride != "" {
-		model = modelOverride
+	req, err = applyResponseFormat(req, client, opts.responseFormat)
+	if err != nil {
+		return llm.ChatCompletionResponse{}, err
 	}
 
-	// Use default model if none specified
-	if model == "" {
-		model = s.config.DefaultModel
+	req, err = s.fitContextWindow(ctx, client, req)
+	if err != nil {
+		return llm.ChatCompletionResponse{}, err
 	}
 
-	req := llm.ChatCompletionRequest{
-		Model:    model,
-		Messages: history,
-		Tools:    tools,
+	return s.createChatCompletion(ctx, client, req)
+}
+
+// createChatCompletion sends a request to the LLM through the middleware and retry
+// machinery. Every completion made by the Swarm should go through here.
+func (s *Swarm) createChatCompletion(ctx context.Context, client llm.LLM, req llm.ChatCompletionRequest) (llm.ChatCompletionResponse, error) {
+	if client == nil {
+		return llm.ChatCompletionResponse{}, ErrLLMClientNotReady
 	}
+	client = s.withMiddleware(ctx, client)
+	ctx = withLogFields(ctx, slog.String("model", req.Model))
+	s.emit(ctx, RunEvent{Type: EventLLMRequest, Request: &req})
+
+	var resp llm.ChatCompletionResponse
+	err := s.withRetries(ctx, true, func(requestCtx context.Context) error {
+		var err error
+		resp, err = client.CreateChatCompletion(requestCtx, req)
+		return err
+	})
+	if err != nil {
+		s.emit(ctx, RunEvent{Type: EventLLMResponse, Request: &req, Err: err})
+		return llm.ChatCompletionResponse{}, err
+	}
+	s.emit(ctx, RunEvent{Type: EventLLMResponse, Request: &req, Response: &resp})
+	return resp, nil
+}
 
-	if debug {
-		log.Printf("Debug - Model: %s, Messages: %d, Tools: %d\n",
-			model, len(history), len(tools))
+// createChatCompletionStream opens a stream through the middleware and retry machinery. The
+// request timeout is not applied, since it would cut the stream off mid-response.
+func (s *Swarm) createChatCompletionStream(ctx context.Context, client llm.LLM, req llm.ChatCompletionRequest) (llm.ChatCompletionStream, error) {
+	if client == nil {
+		return nil, ErrLLMClientNotReady
+	}
+	client = s.withMiddleware(ctx, client)
+	ctx = withLogFields(ctx, slog.String("model", req.Model), slog.Bool("stream", true))
+	s.emit(ctx, RunEvent{Type: EventLLMRequest, Request: &req})
+
+	var stream llm.ChatCompletionStream
+	err := s.withRetries(ctx, false, func(requestCtx context.Context) error {
+		var err error
+		stream, err = client.CreateChatCompletionStream(requestCtx, req)
+		return err
+	})
+	if err != nil {
+		s.emit(ctx, RunEvent{Type: EventLLMResponse, Request: &req, Err: err})
+		return nil, err
 	}
+	return stream, nil
+}
 
-	// Implement retry logic
+// withRetries runs call until it succeeds, applying the configured failure handlers,
+// rate limit strategy and backoff between attempts
+func (s *Swarm) withRetries(ctx context.Context, applyTimeout bool, call func(ctx context.Context) error) error {
 	var lastErr error
 	for attempt := 0; attempt <= s.config.MaxRetries; attempt++ {
-		if attempt > 0 && s.config.Debug {
-			log.Printf("Retry attempt %d after error: %v", attempt, lastErr)
-		}
-
 		// Create a timeout context for this request if none was provided
 		requestCtx := ctx
-		if _, hasDeadline := ctx.Deadline(); !hasDeadline {
-			var cancel context.CancelFunc
+		cancel := func() {}
+		if _, hasDeadline := ctx.Deadline(); applyTimeout && !hasDeadline && s.config.RequestTimeout > 0 {
 			requestCtx, cancel = context.WithTimeout(ctx, s.config.RequestTimeout)
-			defer cancel()
 		}
 
-		// Call the LLM to get a chat completion
-		resp, err := s.client.CreateChatCompletion(requestCtx, req)
+		err := call(requestCtx)
+		cancel()
 		if err == nil {
-			// Success
-			return resp, nil
+			return nil
 		}
 
 		// Handle the error
 		lastErr = err
 
-		// Check for rate limit errors and apply the rate limit strategy
-		if isRateLimitError(err) {
+		// Don't retry once the caller has given up
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// Give the failure handlers the first say on what to do with the error
+		handled, handlerErr := s.handleFailure(err)
+		if handlerErr != nil {
+			return handlerErr
+		}
+
+		var backoff time.Duration
+		switch {
+		case handled:
+			backoff = s.config.RetryBackoff * time.Duration(attempt+1)
+		case isRateLimitError(err):
+			// Check for rate limit errors and apply the rate limit strategy
 			switch s.config.RateLimitStrategy {
 			case RateLimitFail:
-				return llm.ChatCompletionResponse{}, fmt.Errorf("rate limit exceeded: %w", err)
+				return fmt.Errorf("rate limit exceeded: %w", err)
 			case RateLimitQueue:
 				// Implement exponential backoff
-				backoff := s.config.RetryBackoff * time.Duration(1<<uint(attempt))
-				if s.config.Debug {
-					log.Printf("Rate limit hit, backing off for %v", backoff)
-				}
-				select {
-				case <-ctx.Done():
-					return llm.ChatCompletionResponse{}, ctx.Err()
-				case <-time.After(backoff):
-					// Continue to next retry
-				}
+				backoff = s.config.RetryBackoff * time.Duration(1<<uint(attempt))
+				s.log(ctx, false, slog.LevelDebug, "rate limit hit, backing off exponentially",
+					"attempt", attempt+1, "backoff", backoff)
 			default: // RateLimitRetry
 				// Simple retry with backoff
-				backoff := s.config.RetryBackoff * time.Duration(attempt+1)
-				if s.config.Debug {
-					log.Printf("Backing off for %v before retry", backoff)
-				}
-				time.Sleep(backoff)
+				backoff = s.config.RetryBackoff * time.Duration(attempt+1)
+				s.log(ctx, false, slog.LevelDebug, "rate limit hit, backing off",
+					"attempt", attempt+1, "backoff", backoff)
 			}
-		} else if isFatalError(err) {
+		case isFatalError(err):
 			// Don't retry fatal errors
-			return llm.ChatCompletionResponse{}, err
-		} else {
+			return err
+		default:
 			// For other errors, apply backoff
-			backoff := s.config.RetryBackoff * time.Duration(attempt+1)
-			time.Sleep(backoff)
+			backoff = s.config.RetryBackoff * time.Duration(attempt+1)
+		}
+
+		// No point in waiting after the final attempt
+		if attempt == s.config.MaxRetries {
+			break
+		}
+
+		// Never retry sooner than the provider asked us to
+		if delay := retryAfter(err); delay > backoff {
+			s.log(ctx, false, slog.LevelDebug, "provider requested a later retry", "delay", delay)
+			backoff = delay
+		}
+
+		s.log(ctx, false, slog.LevelWarn, "retrying failed request",
+			"attempt", attempt+2, "delay", backoff, "error", err)
+		s.emit(ctx, RunEvent{Type: EventRetry, Attempt: attempt + 2, Delay: backoff, Err: err})
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+			// Continue to next retry
 		}
 	}
 
 	// All retries failed
-	return llm.ChatCompletionResponse{}, fmt.Errorf("max retries exceeded: %w", lastErr)
+	s.log(ctx, false, slog.LevelError, "request failed after retries",
+		"attempts", s.config.MaxRetries+1, "error", lastErr)
+	return fmt.Errorf("max retries exceeded: %w", lastErr)
+}
+
+// handleFailure passes an error to the configured failure handlers in order.
+// The first handler that reports the error as handled decides the outcome:
+// a nil error means retry, a non-nil error aborts the call with that error.
+func (s *Swarm) handleFailure(err error) (bool, error) {
+	for _, handler := range s.config.FailureHandlers {
+		if handled, handlerErr := handler(err); handled {
+			return true, handlerErr
+		}
+	}
+	return false, nil
 }
 
 // isRateLimitError checks if an error is related to rate limiting
 func isRateLimitError(err error) bool {
-	return err != nil && (strings.Contains(strings.ToLower(err.Error()), "rate limit") ||
-		strings.Contains(strings.ToLower(err.Error()), "too many requests") ||
-		strings.Contains(strings.ToLower(err.Error()), "429"))
+	var apiErr *llm.APIError
+	return errors.As(err, &apiErr) && apiErr.IsRateLimit()
 }
 
-// isFatalError checks if an error is fatal and should not be retried
+// isFatalError checks if an error is fatal and should not be retried.
+// Errors that don't come from the provider API, such as network failures, are retried.
 func isFatalError(err error) bool {
-	return err != nil && (strings.Contains(strings.ToLower(err.Error()), "invalid auth") ||
-		strings.Contains(strings.ToLower(err.Error()), "authentication") ||
-		strings.Contains(strings.ToLower(err.Error()), "not found") ||
-		strings.Contains(strings.ToLower(err.Error()), "invalid model"))
+	var apiErr *llm.APIError
+	return errors.As(err, &apiErr) && !apiErr.Retryable
+}
+
+// retryAfter returns the delay the provider asked for before retrying, if any
+func retryAfter(err error) time.Duration {
+	var apiErr *llm.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.RetryAfter
+	}
+	return 0
 }
 
 // Helper function to clone a slice of messages
@@ -400,6 +499,18 @@ func lastMessage(msgs []llm.Message) *llm.Message {
 	return &msgs[len(msgs)-1]
 }
 
+// newToolMessage creates the tool role message answering a tool call, marked as an
+// error when the call failed
+func newToolMessage(toolCall *llm.ToolCall, content string, isError bool) llm.Message {
+	return llm.Message{
+		Role:       llm.RoleTool,
+		Content:    content,
+		Name:       toolCall.Function.Name,
+		ToolCallID: toolCall.ID,
+		IsError:    isError,
+	}
+}
+
 // handleToolCall processes a tool call and ensures proper context
 func (s *Swarm) handleToolCall(
 	ctx context.Context,
@@ -408,202 +519,302 @@ func (s *Swarm) handleToolCall(
 	contextVariables map[string]interface{},
 	debug bool,
 ) (Response, error) {
+	toolResult, message, err := s.executeToolCall(ctx, toolCall, agent, contextVariables, debug)
+	if err != nil {
+		return Response{}, err
+	}
+
+	// Return the response with the tool result
+	return Response{
+		Messages:         []llm.Message{message},
+		Agent:            toolResult.Result.Agent,
+		ContextVariables: contextVariables,
+		ToolResults:      []ToolResult{toolResult},
+	}, nil
+}
+
+// executeToolCall runs a single tool call and returns its result along with the tool
+// message answering it. Tool failures are reported in the result; the returned error
+// is only set when ctx is done.
+func (s *Swarm) executeToolCall(
+	ctx context.Context,
+	toolCall *llm.ToolCall,
+	agent *Agent,
+	contextVariables map[string]interface{},
+	debug bool,
+) (ToolResult, llm.Message, error) {
 	toolName := toolCall.Function.Name
-	argsJSON := toolCall.Function.Arguments
+	ctx = withLogFields(ctx, slog.String("tool", toolName), slog.String("tool_call_id", toolCall.ID))
+	toolResult := ToolResult{
+		ToolName:   toolName,
+		ToolCallID: toolCall.ID,
+	}
+	s.emit(ctx, RunEvent{Type: EventToolStart, ToolCall: toolCall})
+
+	// fail records a call that never reached the tool's function
+	fail := func(kind ToolErrorKind, err error, content string) (ToolResult, llm.Message, error) {
+		s.log(ctx, debug, slog.LevelWarn, "tool call failed", "kind", kind, "error", err)
+		toolResult.Result = Result{Success: false, Error: err}
+		toolResult.ErrorKind = kind
+		s.emit(ctx, RunEvent{Type: EventToolEnd, ToolCall: toolCall, ToolResult: &toolResult, Err: err})
+		return toolResult, newToolMessage(toolCall, content, true), nil
+	}
 
 	// Parse the tool call arguments
 	var args map[string]interface{}
-	if err := json.Unmarshal([]byte(argsJSON), &args); err != nil {
-		errorMsg := fmt.Sprintf("Error parsing tool call arguments: %v", err)
-		if debug {
-			log.Println(errorMsg)
-		}
-		return Response{
-			Messages: []llm.Message{
-				{
-					Role:    llm.RoleFunction,
-					Content: errorMsg,
-					Name:    toolName,
-				},
-			},
-		}, nil
+	if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &args); err != nil {
+		return fail(ToolErrorParse, fmt.Errorf("%w: %v", ErrInvalidToolArgs, err),
+			fmt.Sprintf("Error parsing tool call arguments: %v", err))
 	}
+	toolResult.Args = args
 
-	if debug {
-		log.Printf("Processing tool call: %s with arguments %v\n", toolName, args)
-	}
+	s.log(ctx, debug, slog.LevelDebug, "processing tool call", "args", args)
 
 	// Find the corresponding function in the agent's functions
-	var functionFound *AgentFunction
-	for _, af := range agent.Functions {
-		if af.Name == toolName {
-			functionFound = &af
-			break
-		}
-	}
+	functionFound := findFunction(agent, toolName)
 
 	// Handle case where function is not found
 	if functionFound == nil {
-		errorMsg := fmt.Sprintf("Error: Tool %s not found", toolName)
-		if debug {
-			log.Println(errorMsg)
-		}
-		return Response{
-			Messages: []llm.Message{
-				{
-					Role:    llm.RoleFunction,
-					Content: errorMsg,
-					Name:    toolName,
-				},
-			},
-		}, nil
+		return fail(ToolErrorNotFound, fmt.Errorf("%w: %s", ErrToolNotFound, toolName),
+			fmt.Sprintf("Error: Tool %s not found.", toolName))
 	}
 
-	// Execute the function
-	result := functionFound.Function(args, contextVariables)
+	// Execute the function through the tool middleware, stopping if the run is cancelled
+	start := time.Now()
+	result, calledArgs, err := s.invokeTool(ctx, ToolInvocation{
+		Agent:            agent,
+		Function:         functionFound,
+		CallID:           toolCall.ID,
+		Args:             args,
+		ContextVariables: contextVariables,
+	})
+	toolResult.Duration = time.Since(start)
+	toolResult.Args = calledArgs
+	if err != nil {
+		s.log(ctx, debug, slog.LevelError, "tool call aborted", "error", err)
+		s.emit(ctx, RunEvent{Type: EventToolEnd, ToolCall: toolCall, Err: err})
+		return ToolResult{}, llm.Message{}, err
+	}
+	toolResult.Result = result
 
 	// Create a message with the tool result
 	var resultContent string
+	toolResult.ErrorKind = toolErrorKind(result.Error)
 	if result.Error != nil {
-		resultContent = fmt.Sprintf("Error: %v", result.Error)
+		s.log(ctx, debug, slog.LevelWarn, "tool returned an error",
+			"kind", toolResult.ErrorKind, "duration", toolResult.Duration, "error", result.Error)
+		resultContent = formatToolError(result.Error)
 	} else {
-		resultContent = fmt.Sprintf("%v", result.Data)
+		s.log(ctx, debug, slog.LevelDebug, "tool call succeeded", "duration", toolResult.Duration)
+		resultContent = formatToolContent(result.Data)
 	}
+	s.emit(ctx, RunEvent{Type: EventToolEnd, ToolCall: toolCall, ToolResult: &toolResult, Err: result.Error})
 
-	// Create function response message properly formatted for tool call
-	toolResultMessage := llm.Message{
-		Role:    llm.RoleFunction,
-		Content: resultContent,
-		Name:    toolName,
-	}
+	return toolResult, newToolMessage(toolCall, resultContent, result.Error != nil), nil
+}
 
-	// Return the response with the tool result
-	return Response{
-		Messages:         []llm.Message{toolResultMessage},
-		Agent:            result.Agent,
-		ContextVariables: contextVariables,
-	}, nil
+// findFunction returns the agent's function with the given name, or nil if it has none
+func findFunction(agent *Agent, name string) *AgentFunction {
+	for i := range agent.Functions {
+		if agent.Functions[i].Name == name {
+			return &agent.Functions[i]
+		}
+	}
+	if h := findHandoff(agent, name); h != nil {
+		fn := h.function()
+		return &fn
+	}
+	return nil
 }
 
-// handleToolCalls handles multiple tool calls with correct context forwarding
+// handleToolCalls executes the tool calls of a single model turn and appends their results
+// to history in call order. When parallel is set the calls run concurrently, at most
+// Config.MaxParallelToolCalls at a time. If several calls return an agent to hand off to,
+// the first one in call order wins and the others are ignored.
 func (s *Swarm) handleToolCalls(
 	ctx context.Context,
 	toolCalls []llm.ToolCall,
 	history []llm.Message,
 	agent *Agent,
 	contextVariables map[string]interface{},
-	modelOverride string,
-	stream bool,
 	debug bool,
 	parallel bool,
 ) ([]ToolResult, []llm.Message, *Agent, error) {
 	var toolResults []ToolResult
-	updatedAgent := agent
-	updatedHistory := make([]llm.Message, len(history))
-	copy(updatedHistory, history)
+	var messages []llm.Message
+	var err error
 
-	// Execute tools sequentially for now for simplicity
-	for _, toolCall := range toolCalls {
-		// Execute the tool call
-		toolResp, err := s.handleToolCall(ctx, &toolCall, updatedAgent, contextVariables, debug)
-		if err != nil {
-			if debug {
-				log.Printf("Error executing tool %s: %v", toolCall.Function.Name, err)
-			}
-			continue
-		}
-
-		// Parse arguments for the result
-		var args interface{}
-		_ = json.Unmarshal([]byte(toolCall.Function.Arguments), &args)
-
-		// Record the tool result
-		toolResults = append(toolResults, ToolResult{
-			ToolName: toolCall.Function.Name,
-			Args:     args,
-			Result: Result{
-				Success: true,
-				Data:    toolResp.Messages[0].Content,
-				Error:   nil,
-				Agent:   toolResp.Agent,
-			},
-		})
+	if parallel && len(toolCalls) > 1 {
+		toolResults, messages, err = s.executeToolCallsParallel(ctx, toolCalls, agent, contextVariables, debug)
+	} else {
+		toolResults, messages, err = s.executeToolCallsSequential(ctx, toolCalls, agent, contextVariables, debug)
+	}
+	if err != nil {
+		return nil, history, agent, err
+	}
 
-		// Add the function result to history with proper role and name
-		updatedHistory = append(updatedHistory, llm.Message{
-			Role:    llm.RoleFunction,
-			Content: toolResp.Messages[0].Content,
-			Name:    toolCall.Function.Name,
-		})
+	updatedHistory := make([]llm.Message, len(history), len(history)+len(messages))
+	copy(updatedHistory, history)
+	updatedHistory = append(updatedHistory, messages...)
+
+	var updatedAgent *Agent
+	for _, toolResult := range toolResults {
+		// Hand off to the first agent returned in call order
+		if next := toolResult.Result.Agent; next != nil {
+			if updatedAgent == nil {
+				updatedAgent = next
+			} else if next != updatedAgent {
+				s.log(ctx, debug, slog.LevelDebug, "ignoring handoff, already handing off",
+					"tool", toolResult.ToolName, "to", next.Name, "handing_off_to", updatedAgent.Name)
+			}
+		}
 
-		// Update agent if needed
-		if toolResp.Agent != nil {
-			updatedAgent = toolResp.Agent
+		// Store in memory
+		if agent.Memory != nil {
+			agent.Memory.AddMemory(Memory{
+				Content: fmt.Sprintf("Tool %s call with args: %v, result: %v",
+					toolResult.ToolName, toolResult.Args, formatToolContent(toolResult.Result.Data)),
+				Type:       "tool_call",
+				Context:    map[string]interface{}{"tool": toolResult.ToolName},
+				Timestamp:  time.Now(),
+				Importance: 0.7,
+			})
 		}
 	}
+	if updatedAgent == nil {
+		updatedAgent = agent
+	}
 
-	// Only try to get follow-up if we executed at least one tool successfully
-	if len(toolResults) > 0 {
-		// CRITICAL FIX: Create a new request with the updated history INCLUDING function results
-		followUpReq := llm.ChatCompletionRequest{
-			Model:    updatedAgent.Model,
-			Messages: updatedHistory,
-		}
+	return toolResults, updatedHistory, updatedAgent, nil
+}
 
-		if modelOverride != "" {
-			followUpReq.Model = modelOverride
+// executeToolCallsSequential executes tool calls one after another
+func (s *Swarm) executeToolCallsSequential(
+	ctx context.Context,
+	toolCalls []llm.ToolCall,
+	agent *Agent,
+	contextVariables map[string]interface{},
+	debug bool,
+) ([]ToolResult, []llm.Message, error) {
+	toolResults := make([]ToolResult, 0, len(toolCalls))
+	messages := make([]llm.Message, 0, len(toolCalls))
+
+	checkpoints := checkpointerFrom(ctx)
+	for i := range toolCalls {
+		var before map[string]interface{}
+		if checkpoints != nil {
+			before = copyContextVariables(contextVariables)
 		}
+		toolResult, message, err := s.executeToolCall(ctx, &toolCalls[i], agent, contextVariables, debug)
+		if err != nil {
+			s.log(ctx, debug, slog.LevelError, "error executing tool",
+				"tool", toolCalls[i].Function.Name, "error", err)
+			return nil, nil, err
+		}
+		checkpoints.toolResult(ctx, message, before, contextVariables)
+		toolResults = append(toolResults, toolResult)
+		messages = append(messages, message)
+	}
 
-		// Create a timeout for the follow-up request
-		followUpCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-		defer cancel()
-
-		if debug {
-			log.Printf("Getting follow-up with %d messages in history", len(updatedHistory))
+	return toolResults, messages, nil
+}
 
-			// Print last few messages for debugging
-			lastN := 3
-			if len(updatedHistory) < lastN {
-				lastN = len(updatedHistory)
+// executeToolCallsParallel executes tool calls concurrently, returning their results in call order.
+// Each call works on its own copy of the context variables, and the changes each call made
+// are applied to contextVariables in call order once all calls have finished, so later
+// calls win when two of them write the same key.
+func (s *Swarm) executeToolCallsParallel(
+	ctx context.Context,
+	toolCalls []llm.ToolCall,
+	agent *Agent,
+	contextVariables map[string]interface{},
+	debug bool,
+) ([]ToolResult, []llm.Message, error) {
+	toolResults := make([]ToolResult, len(toolCalls))
+	messages := make([]llm.Message, len(toolCalls))
+	variables := make([]map[string]interface{}, len(toolCalls))
+	errs := make([]error, len(toolCalls))
+
+	limit := s.config.MaxParallelToolCalls
+	if limit <= 0 || limit > len(toolCalls) {
+		limit = len(toolCalls)
+	}
+	s.log(ctx, debug, slog.LevelDebug, "executing tool calls in parallel",
+		"tool_calls", len(toolCalls), "limit", limit)
+
+	snapshot := copyContextVariables(contextVariables)
+	checkpoints := checkpointerFrom(ctx)
+	semaphore := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+
+	for i := range toolCalls {
+		variables[i] = copyContextVariables(snapshot)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			select {
+			case semaphore <- struct{}{}:
+				defer func() { <-semaphore }()
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
 			}
 
-			log.Printf("Last %d messages:", lastN)
-			for i := len(updatedHistory) - lastN; i < len(updatedHistory); i++ {
-				msg := updatedHistory[i]
-				log.Printf("[%s] %s: %s", msg.Role, msg.Name, truncateString(msg.Content, 50))
+			toolResults[i], messages[i], errs[i] = s.executeToolCall(ctx, &toolCalls[i], agent, variables[i], debug)
+			if errs[i] == nil {
+				checkpoints.toolResult(ctx, messages[i], snapshot, variables[i])
 			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			s.log(ctx, debug, slog.LevelError, "error executing tool",
+				"tool", toolCalls[i].Function.Name, "error", err)
+			return nil, nil, err
 		}
+	}
 
-		// Get the follow-up response with proper context
-		followUpResp, err := s.client.CreateChatCompletion(followUpCtx, followUpReq)
+	for _, vars := range variables {
+		mergeContextVariables(contextVariables, snapshot, vars)
+	}
 
-		if err != nil {
-			if debug {
-				log.Printf("Error getting follow-up: %v", err)
-			}
-			// Continue without follow-up rather than failing
-		} else if len(followUpResp.Choices) > 0 {
-			// Add follow-up response to history
-			followUpMessage := followUpResp.Choices[0].Message
-
-			// Only add if it has content and only append content (no tools)
-			if followUpMessage.Content != "" {
-				cleanedFollowUp := llm.Message{
-					Role:    followUpMessage.Role,
-					Content: followUpMessage.Content,
-				}
-				updatedHistory = append(updatedHistory, cleanedFollowUp)
-
-				if debug {
-					log.Printf("Added follow-up: %s", truncateString(followUpMessage.Content, 50))
-				}
-			} else if debug {
-				log.Println("Follow-up was empty")
-			}
+	return toolResults, messages, nil
+}
+
+// copyContextVariables returns a shallow copy of the context variables
+func copyContextVariables(contextVariables map[string]interface{}) map[string]interface{} {
+	vars := make(map[string]interface{}, len(contextVariables))
+	for k, v := range contextVariables {
+		vars[k] = v
+	}
+	return vars
+}
+
+// mergeContextVariables applies the keys a tool set or deleted in updated, relative to base, to target
+func mergeContextVariables(target, base, updated map[string]interface{}) {
+	for k, v := range updated {
+		if old, ok := base[k]; !ok || !reflect.DeepEqual(old, v) {
+			target[k] = v
 		}
 	}
+	for k := range base {
+		if _, ok := updated[k]; !ok {
+			delete(target, k)
+		}
+	}
+}
 
-	return toolResults, updatedHistory, updatedAgent, nil
+// ensureToolCallIDs assigns an ID to tool calls the provider returned without one,
+// so every tool result can be linked back to its call
+func ensureToolCallIDs(toolCalls []llm.ToolCall) {
+	for i := range toolCalls {
+		if toolCalls[i].ID == "" {
+			toolCalls[i].ID = llm.NewToolCallID()
+		}
+	}
 }
 
 // Helper function to truncate strings for debugging
@@ -614,34 +825,36 @@ func truncateString(s string, maxLen int) string {
 	return s[:maxLen] + "..."
 }
 
-// Run is the main entry point for agent execution
-func (s *Swarm) Run(
-	ctx context.Context,
-	agent *Agent,
-	messages []llm.Message,
-	contextVariables map[string]interface{},
-	modelOverride string,
-	stream bool,
-	debug bool,
-	maxTurns int,
-	executeTools bool,
-) (Response, error) {
-	// Validate inputs
-	if agent == nil {
-		return Response{}, fmt.Errorf("agent cannot be nil")
+// modelFor returns the model an agent's requests are sent to
+func (s *Swarm) modelFor(agent *Agent, modelOverride string) string {
+	model := agent.Model
+	if modelOverride != "" {
+		model = modelOverride
 	}
 
-	// Use a cloned copy of messages for history
-	history := make([]llm.Message, len(messages))
-	copy(history, messages)
+	// Use default model if none specified
+	if model == "" {
+		model = s.config.DefaultModel
+	}
 
-	if contextVariables == nil {
-		contextVariables = make(map[string]interface{})
+	// Map the model to a provider-specific deployment name if the agent's client needs one
+	if agent.Config != nil && agent.Config.ModelMapperFunc != nil {
+		model = agent.Config.ModelMapperFunc(model)
 	}
+	return model
+}
 
-	// Set up context variables
+// buildRequest assembles the chat completion request for one turn of the agent loop
+func (s *Swarm) buildRequest(
+	agent *Agent,
+	history []llm.Message,
+	contextVariables map[string]interface{},
+	modelOverride string,
+) llm.ChatCompletionRequest {
+	// Resolve instructions every turn, since tools may have changed the context variables
+	instructions := agent.Instructions
 	if agent.InstructionsFunc != nil {
-		agent.Instructions = agent.InstructionsFunc(contextVariables)
+		instructions = agent.InstructionsFunc(contextVariables)
 	}
 
 	// Add system instruction as first message if not already present
@@ -653,190 +866,348 @@ func (s *Swarm) Run(
 		}
 	}
 
-	if !hasSystemMessage && agent.Instructions != "" {
-		newHistory := make([]llm.Message, 0, len(history)+1)
-		newHistory = append(newHistory, llm.Message{
+	messages := history
+	if !hasSystemMessage && instructions != "" {
+		messages = make([]llm.Message, 0, len(history)+1)
+		messages = append(messages, llm.Message{
 			Role:    llm.RoleSystem,
-			Content: agent.Instructions,
+			Content: instructions,
 		})
-		newHistory = append(newHistory, history...)
-		history = newHistory
-	}
-
-	// Get chat completion from LLM
-	if debug {
-		log.Printf("Getting initial response with %d messages", len(history))
+		messages = append(messages, history...)
 	}
 
-	model := agent.Model
-	if modelOverride != "" {
-		model = modelOverride
-	}
+	model := s.modelFor(agent, modelOverride)
 
 	// Prepare tools for the request
 	var tools []llm.Tool
-	if agent.Functions != nil {
-		for _, fn := range agent.Functions {
-			tools = append(tools, llm.Tool{
-				Type: "function",
-				Function: &llm.Function{
-					Name:        fn.Name,
-					Description: fn.Description,
-					Parameters:  fn.Parameters,
-				},
-			})
-		}
+	for _, fn := range agent.tools() {
+		def := FunctionToDefinition(fn)
+		tools = append(tools, llm.Tool{
+			Type:     "function",
+			Function: &def,
+		})
 	}
 
-	// Create the request
-	req := llm.ChatCompletionRequest{
+	return llm.ChatCompletionRequest{
 		Model:    model,
-		Messages: history,
+		Messages: messages,
 		Tools:    tools,
 	}
+}
+
+// Run is the main entry point for agent execution. It calls the model in a loop,
+// executing any requested tools and feeding their results back, until the model
+// produces a final assistant message, a tool hands off to another agent, or
+// maxTurns model calls have been made. A maxTurns of zero or less uses DefaultMaxTurns.
+// Run works on a snapshot of agent and a copy of contextVariables, so both can be shared
+// by concurrent runs; the updated variables are returned in Response.ContextVariables.
+func (s *Swarm) Run(
+	ctx context.Context,
+	agent *Agent,
+	messages []llm.Message,
+	contextVariables map[string]interface{},
+	modelOverride string,
+	stream bool,
+	debug bool,
+	maxTurns int,
+	executeTools bool,
+) (Response, error) {
+	return s.runWithOptions(ctx, agent, messages, contextVariables, modelOverride, stream, debug, maxTurns, executeTools, runOptions{})
+}
+
+// runOptions holds the settings of a run that Run's parameters don't cover
+type runOptions struct {
+	responseFormat *llm.ResponseFormat         // Format of the replies requested by RunStructured
+	approvals      map[string]ApprovalDecision // Decisions for the pending tool calls of a resumed run
+	resumeTurns    int                         // Turns made by a resumed run before it paused
+	checkpoint     *Checkpoint                 // Checkpoint a run resumed by ResumeRun continues from
+}
+
+// runWithOptions starts a run with its own run ID and reports its start and end to the hooks
+func (s *Swarm) runWithOptions(
+	ctx context.Context,
+	agent *Agent,
+	messages []llm.Message,
+	contextVariables map[string]interface{},
+	modelOverride string,
+	stream bool,
+	debug bool,
+	maxTurns int,
+	executeTools bool,
+	opts runOptions,
+) (Response, error) {
+	// Validate inputs
+	if agent == nil {
+		return Response{}, ErrNilAgent
+	}
 
-	// Get initial response
-	resp, err := s.client.CreateChatCompletion(ctx, req)
+	ctx = startRunInfo(ctx, agent.Name)
+	ctx, nested, parent := withUsageCollector(ctx)
+	ctx, checkpoints := s.startCheckpoints(ctx, Checkpoint{
+		ModelOverride:  modelOverride,
+		Stream:         stream,
+		Debug:          debug,
+		MaxTurns:       maxTurns,
+		ExecuteTools:   executeTools,
+		ResponseFormat: opts.responseFormat,
+	}, opts.checkpoint)
+	s.emit(ctx, RunEvent{Type: EventRunStart})
+
+	// Count the usage of runs nested in this one, and report the total to the enclosing run
+	response, err := s.run(ctx, agent, messages, contextVariables, modelOverride, stream, debug, maxTurns, executeTools, opts)
+	checkpoints.finish(ctx, err)
+	response.Usage.Merge(nested.drain())
+	if parent != nil {
+		parent.add(response.Usage)
+	}
 	if err != nil {
-		return Response{}, fmt.Errorf("chat completion error: %v", err)
+		s.emit(ctx, RunEvent{Type: EventRunEnd, Err: err})
+		return response, err
 	}
+	s.emit(ctx, RunEvent{Type: EventRunEnd, Turn: response.Turns, Agent: response.Agent.Name, RunResult: &response})
+	return response, nil
+}
 
-	if len(resp.Choices) == 0 {
-		return Response{}, fmt.Errorf("no choices in response")
+// run implements Run, once the run's ID is set on ctx
+func (s *Swarm) run(
+	ctx context.Context,
+	agent *Agent,
+	messages []llm.Message,
+	contextVariables map[string]interface{},
+	modelOverride string,
+	stream bool,
+	debug bool,
+	maxTurns int,
+	executeTools bool,
+	opts runOptions,
+) (Response, error) {
+	if maxTurns <= 0 {
+		maxTurns = DefaultMaxTurns
 	}
 
-	// Extract the response
-	choice := resp.Choices[0]
-	history = append(history, choice.Message)
+	// Use a cloned copy of messages for history
+	history := cloneMessages(messages)
+	initLen := len(history)
 
-	// Handle tool calls if present and execution is enabled
-	if len(choice.Message.ToolCalls) > 0 && executeTools {
-		if debug {
-			log.Printf("Handling %d tool calls", len(choice.Message.ToolCalls))
+	// Work on copies of the context variables and the agent, so concurrent runs can
+	// share them. The response still reports the agent the caller passed in.
+	contextVariables = copyContextVariables(contextVariables)
+	response := Response{
+		Agent:            agent,
+		ContextVariables: contextVariables,
+	}
+	agent = agent.snapshot()
+	checkpoints := checkpointerFrom(ctx)
+
+	runCtx := ctx
+	if opts.checkpoint != nil {
+		// Continue from a checkpoint, running only the calls of its last turn that have no result yet
+		initLen = opts.checkpoint.InputMessages
+		response.Turns = opts.checkpoint.Turns
+		response.Messages = history[initLen:]
+		if pending := opts.checkpoint.PendingToolCalls; len(pending) > 0 {
+			ctx := withTurn(runCtx, response.Turns, agent.Name)
+			var err error
+			history, err = s.runToolCalls(ctx, agent, pending, pending, nil, nil, history, initLen, &response, debug)
+			if err != nil {
+				return Response{}, err
+			}
+			if response.StopReason == StopHandoff {
+				return response, nil
+			}
+			if err := checkpoints.save(ctx, agent.Name, history, initLen, nil, contextVariables, response.Turns); err != nil {
+				return Response{}, err
+			}
+		}
+	} else if opts.approvals != nil {
+		// Resume a paused run with the tool calls of its last message
+		initLen--
+		response.Turns = opts.resumeTurns
+		ctx := withTurn(runCtx, response.Turns, agent.Name)
+		approved, rejectedResults, rejectedMessages, err := s.applyApprovals(ctx, history, opts.approvals, debug)
+		if err != nil {
+			return Response{}, err
+		}
+		toolCalls := history[len(history)-1].ToolCalls
+		history, err = s.runToolCalls(ctx, agent, toolCalls, approved, rejectedResults, rejectedMessages,
+			history, initLen, &response, debug)
+		if err != nil {
+			return Response{}, err
+		}
+		if response.StopReason == StopHandoff {
+			return response, nil
 		}
+		if err := checkpoints.save(ctx, agent.Name, history, initLen, nil, contextVariables, response.Turns); err != nil {
+			return Response{}, err
+		}
+	} else if i := lastUserMessage(history); i >= 0 && len(agent.InputGuardrails) > 0 {
+		// Check the user's input before the agent sees it
+		if err := s.applyGuardrails(withTurn(ctx, 0, agent.Name), GuardrailStageInput,
+			agent.InputGuardrails, agent, history, i, contextVariables, &response, debug); err != nil {
+			return guardrailStop(response, nil, err)
+		}
+	}
 
-		// Execute tools and get the updated history including follow-up
-		toolResults, updatedHistory, updatedAgent, err := s.handleToolCalls(
-			ctx, choice.Message.ToolCalls, history, agent,
-			contextVariables, modelOverride, stream, debug,
-			agent.ParallelToolCalls)
+	for response.Turns < maxTurns {
+		response.Turns++
+		ctx := withTurn(runCtx, response.Turns, agent.Name)
 
+		s.log(ctx, debug, slog.LevelDebug, "requesting completion")
+
+		resp, err := s.getChatCompletion(ctx, agent, history, contextVariables, modelOverride, stream, debug, opts)
 		if err != nil {
-			return Response{}, fmt.Errorf("tool execution error: %v", err)
+			return Response{}, fmt.Errorf("chat completion error: %w", err)
+		}
+
+		response.Usage.AddTurn(s.turnUsage(response.Turns, agent.Name,
+			s.modelFor(agent, modelOverride), resp.Usage))
+
+		if len(resp.Choices) == 0 {
+			return Response{}, ErrNoChoicesInResp
+		}
+
+		// Extract the response
+		message := resp.Choices[0].Message
+		ensureToolCallIDs(message.ToolCalls)
+		history = append(history, message)
+		response.Messages = history[initLen:]
+
+		// A reply without tool calls is the final answer
+		if len(message.ToolCalls) == 0 {
+			if err := s.applyGuardrails(ctx, GuardrailStageOutput, agent.OutputGuardrails,
+				agent, history, len(history)-1, contextVariables, &response, debug); err != nil {
+				return guardrailStop(response, history[initLen:len(history)-1], err)
+			}
+			response.StopReason = StopFinalMessage
+			return response, nil
 		}
 
-		// Calculate which messages to return (only the new ones)
-		newMessages := updatedHistory[len(messages):]
+		if !executeTools {
+			response.StopReason = StopToolsNotExecuted
+			return response, nil
+		}
+
+		// Pause before calls that need approval, leaving the message making them out
+		// of Messages so it can be resumed with the decisions applied
+		if pending := pendingToolCalls(agent, message.ToolCalls, contextVariables); len(pending) > 0 {
+			s.log(ctx, debug, slog.LevelInfo, "pausing for approval", "tool_calls", len(pending))
+			response.Messages = history[initLen : len(history)-1]
+			response.StopReason = StopPendingApproval
+			opts.checkpoint = nil
+			response.PendingApproval = &PendingApproval{
+				Agent:            response.Agent,
+				Messages:         cloneMessages(history),
+				ContextVariables: contextVariables,
+				ToolCalls:        pending,
+				Turns:            response.Turns,
+				modelOverride:    modelOverride,
+				stream:           stream,
+				debug:            debug,
+				maxTurns:         maxTurns,
+				opts:             opts,
+			}
+			return response, nil
+		}
+
+		if err := checkpoints.save(ctx, agent.Name, history, initLen, message.ToolCalls, contextVariables, response.Turns); err != nil {
+			return Response{}, err
+		}
 
-		return Response{
-			Messages:         newMessages,
-			Agent:            updatedAgent,
-			ContextVariables: contextVariables,
-			ToolResults:      toolResults,
-		}, nil
+		s.log(ctx, debug, slog.LevelDebug, "handling tool calls", "tool_calls", len(message.ToolCalls))
+
+		history, err = s.runToolCalls(ctx, agent, message.ToolCalls, message.ToolCalls, nil, nil,
+			history, initLen, &response, debug)
+		if err != nil {
+			return Response{}, err
+		}
+		if response.StopReason == StopHandoff {
+			return response, nil
+		}
+		if err := checkpoints.save(ctx, agent.Name, history, initLen, nil, contextVariables, response.Turns); err != nil {
+			return Response{}, err
+		}
 	}
 
-	// No tool calls - just return the normal response
-	return Response{
-		Messages:         history[len(messages):],
-		Agent:            agent,
-		ContextVariables: contextVariables,
-	}, nil
+	s.log(runCtx, debug, slog.LevelInfo, "stopping after reaching max turns", "max_turns", maxTurns)
+	response.StopReason = StopMaxTurns
+	return response, nil
 }
 
-// handleToolCallsParallel executes multiple tool calls concurrently
-func (s *Swarm) handleToolCallsParallel(
+// runToolCalls executes the approved tool calls of a turn with agent, the run's snapshot
+// of response.Agent, and appends their results, along
+// with those of rejected calls, to history in the order of toolCalls. A handoff sets the
+// response's agent and ends the run with StopHandoff, so the caller can continue with the
+// new agent.
+func (s *Swarm) runToolCalls(
 	ctx context.Context,
+	agent *Agent,
 	toolCalls []llm.ToolCall,
+	approved []llm.ToolCall,
+	rejectedResults map[string]ToolResult,
+	rejectedMessages map[string]llm.Message,
 	history []llm.Message,
-	agent *Agent,
-	contextVariables map[string]interface{},
-	modelOverride string,
-	stream bool,
+	initLen int,
+	response *Response,
 	debug bool,
-) ([]ToolResult, []llm.Message, *Agent, error) {
-	type toolCallResult struct {
-		index  int
-		result Response
-		err    error
+) ([]llm.Message, error) {
+	contextVariables := response.ContextVariables
+	before := copyContextVariables(contextVariables)
+	toolResults, updatedHistory, nextAgent, err := s.handleToolCalls(
+		ctx, approved, history, agent,
+		contextVariables, debug, agent.ParallelToolCalls)
+	if err != nil {
+		return history, fmt.Errorf("tool execution error: %w", err)
 	}
-
-	resultChan := make(chan toolCallResult, len(toolCalls))
-	updatedHistory := make([]llm.Message, len(history))
-	copy(updatedHistory, history)
-	updatedAgent := agent
-
-	// Create a cancellable context for all tool calls
-	execCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
-
-	// Launch goroutines for each tool call
-	for i, toolCall := range toolCalls {
-		go func(idx int, tc llm.ToolCall) {
-			toolResp, err := s.handleToolCall(execCtx, &tc, agent, contextVariables, debug)
-			resultChan <- toolCallResult{index: idx, result: toolResp, err: err}
-		}(i, toolCall)
+	if err := checkpointerFrom(ctx).failure(); err != nil {
+		return history, err
 	}
+	s.emitContextVariableChanges(ctx, before, contextVariables)
 
-	// Collect results
-	var toolResults []ToolResult
-	agentTransferred := false
+	if len(rejectedResults) > 0 {
+		// Put the rejections back in call order
+		results := make(map[string]ToolResult, len(toolResults))
+		for _, r := range toolResults {
+			results[r.ToolCallID] = r
+		}
+		added := make(map[string]llm.Message, len(approved))
+		for _, msg := range updatedHistory[len(history):] {
+			added[msg.ToolCallID] = msg
+		}
 
-	for i := 0; i < len(toolCalls); i++ {
-		select {
-		case <-ctx.Done():
-			return nil, history, agent, ctx.Err()
-		case result := <-resultChan:
-			if result.err != nil {
-				if debug {
-					log.Printf("Error in tool call %d: %v", result.index, result.err)
-				}
-				continue
+		toolResults = toolResults[:0:0]
+		updatedHistory = updatedHistory[:len(history)]
+		for _, tc := range toolCalls {
+			if r, ok := rejectedResults[tc.ID]; ok {
+				toolResults = append(toolResults, r)
+				updatedHistory = append(updatedHistory, rejectedMessages[tc.ID])
+			} else {
+				toolResults = append(toolResults, results[tc.ID])
+				updatedHistory = append(updatedHistory, added[tc.ID])
 			}
+		}
+	}
 
-			// Get the original tool call
-			toolCall := toolCalls[result.index]
-			var args interface{}
-			_ = json.Unmarshal([]byte(toolCall.Function.Arguments), &args)
-
-			// Add to tool results
-			toolResults = append(toolResults, ToolResult{
-				ToolName: toolCall.Function.Name,
-				Args:     args,
-				Result: Result{
-					Success: true,
-					Data:    result.result.Messages[0].Content,
-					Error:   nil,
-					Agent:   result.result.Agent,
-				},
-			})
-
-			// Add to history
-			updatedHistory = append(updatedHistory, llm.Message{
-				Role:    llm.RoleFunction,
-				Content: result.result.Messages[0].Content,
-				Name:    toolCall.Function.Name,
-			})
+	// Results checkpointed before a resume precede those of the calls run now
+	orderToolResults(updatedHistory[initLen:])
+	response.Messages = updatedHistory[initLen:]
+	response.ToolResults = append(response.ToolResults, toolResults...)
 
-			// Only update agent if not already transferred
-			if result.result.Agent != nil && !agentTransferred {
-				updatedAgent = result.result.Agent
-				agentTransferred = true
+	if nextAgent != nil && nextAgent != agent && nextAgent != response.Agent {
+		s.log(ctx, debug, slog.LevelInfo, "handing off", "from", response.Agent.Name, "to", nextAgent.Name)
+		for _, toolResult := range toolResults {
+			if toolResult.Result.Agent != nextAgent {
+				continue
 			}
-
-			// Store in memory
-			if agent.Memory != nil {
-				agent.Memory.AddMemory(Memory{
-					Content: fmt.Sprintf("Tool %s call with args: %v, result: %s",
-						toolCall.Function.Name, args, result.result.Messages[0].Content),
-					Type:       "tool_call",
-					Context:    map[string]interface{}{"tool": toolCall.Function.Name},
-					Timestamp:  time.Now(),
-					Importance: 0.7,
-				})
+			record, err := s.recordHandoff(ctx, agent, toolResult, updatedHistory, response.Turns)
+			if err != nil {
+				return updatedHistory, err
 			}
+			response.Handoff = record
+			break
 		}
+		s.emit(ctx, RunEvent{Type: EventHandoff, FromAgent: response.Agent.Name, ToAgent: nextAgent.Name})
+		response.Agent = nextAgent
+		response.StopReason = StopHandoff
 	}
-
-	return toolResults, updatedHistory, updatedAgent, nil
+	return updatedHistory, nil
 }
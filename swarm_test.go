@@ -3,13 +3,26 @@ package swarmgo
 import (
 	"bytes"
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	_ "github.com/mattn/go-sqlite3"
 	"github.com/prathyushnallamothu/swarmgo/llm"
+	llmmock "github.com/prathyushnallamothu/swarmgo/llm/mock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -31,9 +44,9 @@ func (m *MockLLM) CreateChatCompletionStream(ctx context.Context, req llm.ChatCo
 
 // NewMockSwarm initializes a new Swarm instance with a mock LLM client
 func NewMockSwarm(mockClient *MockLLM) *Swarm {
-	return &Swarm{
-		client: mockClient,
-	}
+	config := DefaultConfig()
+	config.RetryBackoff = time.Millisecond
+	return NewSwarmWithCustomProvider(mockClient, config)
 }
 
 // TestNewSwarm tests the NewSwarm function
@@ -122,7 +135,8 @@ func TestHandleToolCall(t *testing.T) {
 
 	assert.NoError(t, err)
 	assert.Len(t, response.Messages, 1)
-	assert.Equal(t, llm.RoleAssistant, response.Messages[0].Role)
+	assert.Equal(t, llm.RoleTool, response.Messages[0].Role)
+	assert.Equal(t, "testFunction", response.Messages[0].ToolCallID)
 	assert.Equal(t, "Function executed successfully", response.Messages[0].Content)
 }
 
@@ -154,10 +168,174 @@ func TestHandleToolCallFunctionNotFound(t *testing.T) {
 
 	assert.NoError(t, err)
 	assert.Len(t, response.Messages, 1)
-	assert.Equal(t, llm.RoleAssistant, response.Messages[0].Role)
+	assert.Equal(t, llm.RoleTool, response.Messages[0].Role)
+	assert.Equal(t, "nonExistentFunction", response.Messages[0].ToolCallID)
 	assert.Contains(t, response.Messages[0].Content, "Error: Tool nonExistentFunction not found.")
 }
 
+type weatherArgs struct {
+	City  string `json:"city" jsonschema:"description=City to look up"`
+	Units string `json:"units,omitempty" jsonschema:"enum=celsius,enum=fahrenheit"`
+}
+
+type weatherReport struct {
+	City        string  `json:"city"`
+	Temperature float64 `json:"temperature"`
+}
+
+// TestNewTool tests that typed tools reflect their schema and decode their arguments
+func TestNewTool(t *testing.T) {
+	tool := NewTool("get_weather", "Get the weather", func(ctx context.Context, args weatherArgs, rc *RunContext) (weatherReport, error) {
+		rc.ContextVariables["last_city"] = args.City
+		return weatherReport{City: args.City, Temperature: 21.5}, nil
+	})
+
+	assert.Equal(t, "get_weather", tool.Name)
+	assert.Equal(t, "object", tool.Parameters["type"])
+	assert.Equal(t, []interface{}{"city"}, tool.Parameters["required"])
+	assert.Equal(t, false, tool.Parameters["additionalProperties"])
+	properties := tool.Parameters["properties"].(map[string]interface{})
+	assert.Equal(t, "City to look up", properties["city"].(map[string]interface{})["description"])
+	assert.NotContains(t, tool.Parameters, "$schema")
+
+	sw := NewSwarm("test-api-key", llm.OpenAI)
+	agent := &Agent{Name: "TestAgent", Functions: []AgentFunction{tool}}
+	contextVariables := map[string]interface{}{}
+	toolCall := llm.ToolCall{
+		ID:       "call_1",
+		Type:     "function",
+		Function: llm.ToolCallFunction{Name: "get_weather", Arguments: `{"city": "Paris"}`},
+	}
+
+	response, err := sw.handleToolCall(context.Background(), &toolCall, agent, contextVariables, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"city":"Paris","temperature":21.5}`, response.Messages[0].Content)
+	assert.Equal(t, "Paris", contextVariables["last_city"])
+}
+
+// TestNewToolInvalidArgs tests that arguments not matching the schema are rejected before the tool runs
+func TestNewToolInvalidArgs(t *testing.T) {
+	called := false
+	tool := NewTool("get_weather", "Get the weather", func(ctx context.Context, args weatherArgs, rc *RunContext) (weatherReport, error) {
+		called = true
+		return weatherReport{}, nil
+	})
+
+	tests := map[string]map[string]interface{}{
+		"missing required": {"units": "celsius"},
+		"unknown field":    {"city": "Paris", "country": "France"},
+		"wrong type":       {"city": 42},
+	}
+
+	for name, args := range tests {
+		t.Run(name, func(t *testing.T) {
+			result := tool.ContextFunction(context.Background(), args, map[string]interface{}{})
+			assert.False(t, result.Success)
+			assert.ErrorIs(t, result.Error, ErrInvalidToolArgs)
+		})
+	}
+	assert.False(t, called)
+}
+
+// TestHandleToolCallTimeout tests that a tool exceeding its Timeout reports a structured error
+func TestHandleToolCallTimeout(t *testing.T) {
+	sw := NewSwarm("test-api-key", llm.OpenAI)
+	release := make(chan struct{})
+	defer close(release)
+
+	agent := &Agent{
+		Name: "TestAgent",
+		Functions: []AgentFunction{
+			{
+				Name:    "slow",
+				Timeout: 10 * time.Millisecond,
+				Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+					<-release
+					return Result{Success: true, Data: "too late"}
+				},
+			},
+		},
+	}
+	toolCall := llm.ToolCall{ID: "call_1", Type: "function", Function: llm.ToolCallFunction{Name: "slow", Arguments: `{}`}}
+
+	response, err := sw.handleToolCall(context.Background(), &toolCall, agent, map[string]interface{}{}, false)
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"error": "timeout", "tool": "slow", "timeout_seconds": 0.01, "message": "tool slow timed out after 10ms"}`, response.Messages[0].Content)
+}
+
+// TestHandleToolCallRetries tests that failed calls are retried up to MaxRetries times
+func TestHandleToolCallRetries(t *testing.T) {
+	sw := NewSwarm("test-api-key", llm.OpenAI)
+	attempts := 0
+
+	agent := &Agent{
+		Name: "TestAgent",
+		Functions: []AgentFunction{
+			{
+				Name:       "flaky",
+				MaxRetries: 2,
+				ContextFunction: func(ctx context.Context, args map[string]interface{}, contextVariables map[string]interface{}) Result {
+					attempts++
+					if attempts < 3 {
+						return Result{Error: errors.New("temporary failure")}
+					}
+					return Result{Success: true, Data: "ok"}
+				},
+			},
+		},
+	}
+	toolCall := llm.ToolCall{ID: "call_1", Type: "function", Function: llm.ToolCallFunction{Name: "flaky", Arguments: `{}`}}
+
+	response, err := sw.handleToolCall(context.Background(), &toolCall, agent, map[string]interface{}{}, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, "ok", response.Messages[0].Content)
+
+	// Invalid arguments would fail again, so they aren't retried
+	attempts = 0
+	agent.Functions[0].ContextFunction = func(ctx context.Context, args map[string]interface{}, contextVariables map[string]interface{}) Result {
+		attempts++
+		return Result{Error: fmt.Errorf("%w: missing city", ErrInvalidToolArgs)}
+	}
+	response, err = sw.handleToolCall(context.Background(), &toolCall, agent, map[string]interface{}{}, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, attempts)
+	assert.Equal(t, ToolErrorParse, response.ToolResults[0].ErrorKind)
+}
+
+// TestRunCancelledDuringTool tests that cancelling the run's context stops a running tool
+func TestRunCancelledDuringTool(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	agent := &Agent{
+		Name:  "TestAgent",
+		Model: "test-model",
+		Functions: []AgentFunction{
+			{
+				Name: "wait",
+				ContextFunction: func(ctx context.Context, args map[string]interface{}, contextVariables map[string]interface{}) Result {
+					cancel()
+					<-ctx.Done()
+					return Result{Error: ctx.Err()}
+				},
+			},
+		},
+	}
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(toolCallResponse("call_1", "wait", `{}`), nil).Once()
+
+	_, err := sw.Run(ctx, agent, []llm.Message{{Role: llm.RoleUser, Content: "Hi"}}, nil, "", false, false, 5, true)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	mockClient.AssertExpectations(t)
+}
+
 // TestRun tests the Run method
 func TestRun(t *testing.T) {
 	mockClient := new(MockLLM)
@@ -296,3 +474,2180 @@ func TestProcessAndPrintResponse(t *testing.T) {
 	output := buf.String()
 	assert.NotNil(t, output)
 }
+
+// toolCallResponse builds a mock completion that requests a single tool call
+func toolCallResponse(id, name, args string) llm.ChatCompletionResponse {
+	return llm.ChatCompletionResponse{
+		Choices: []llm.Choice{
+			{
+				Message: llm.Message{
+					Role: llm.RoleAssistant,
+					ToolCalls: []llm.ToolCall{
+						{
+							ID:       id,
+							Type:     "function",
+							Function: llm.ToolCallFunction{Name: name, Arguments: args},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// TestRunMultiTurn tests that Run keeps calling the model while it chains tool calls
+func TestRunMultiTurn(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+	ctx := context.Background()
+
+	var calls []string
+	record := func(name string) func(map[string]interface{}, map[string]interface{}) Result {
+		return func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+			calls = append(calls, name)
+			return Result{Success: true, Data: name + " done"}
+		}
+	}
+
+	agent := &Agent{
+		Name:  "TestAgent",
+		Model: "test-model",
+		Functions: []AgentFunction{
+			{Name: "search", Function: record("search")},
+			{Name: "fetch", Function: record("fetch")},
+		},
+	}
+
+	final := llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "All done."}}},
+	}
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(toolCallResponse("call_1", "search", `{}`), nil).Once()
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(toolCallResponse("call_2", "fetch", `{}`), nil).Once()
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(final, nil).Once()
+
+	response, err := sw.Run(ctx, agent, []llm.Message{{Role: llm.RoleUser, Content: "Hi"}}, nil, "", false, false, 5, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"search", "fetch"}, calls)
+	assert.Equal(t, StopFinalMessage, response.StopReason)
+	assert.Equal(t, 3, response.Turns)
+	assert.Len(t, response.Messages, 5)
+	assert.Len(t, response.ToolResults, 2)
+	assert.Equal(t, "All done.", response.Messages[4].Content)
+	assert.Equal(t, llm.RoleTool, response.Messages[1].Role)
+	assert.Equal(t, "call_1", response.Messages[1].ToolCallID)
+	assert.Equal(t, "call_2", response.Messages[3].ToolCallID)
+	mockClient.AssertExpectations(t)
+}
+
+// TestRunAssignsMissingToolCallIDs tests that tool results stay linked to calls the provider left without an ID
+func TestRunAssignsMissingToolCallIDs(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+	ctx := context.Background()
+
+	agent := &Agent{
+		Name:  "TestAgent",
+		Model: "test-model",
+		Functions: []AgentFunction{
+			{Name: "search", Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+				return Result{Success: true, Data: "found"}
+			}},
+		},
+	}
+
+	final := llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "Done."}}},
+	}
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(toolCallResponse("", "search", `{}`), nil).Once()
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(final, nil).Once()
+
+	response, err := sw.Run(ctx, agent, []llm.Message{{Role: llm.RoleUser, Content: "Hi"}}, nil, "", false, false, 5, true)
+
+	assert.NoError(t, err)
+	assert.Len(t, response.Messages, 3)
+	callID := response.Messages[0].ToolCalls[0].ID
+	assert.NotEmpty(t, callID)
+	assert.Equal(t, callID, response.Messages[1].ToolCallID)
+	mockClient.AssertExpectations(t)
+}
+
+// TestRunToolResults tests that ToolResults keep each tool's original result and where failures happened
+func TestRunToolResults(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+	ctx := context.Background()
+
+	agent := &Agent{
+		Name:  "TestAgent",
+		Model: "test-model",
+		Functions: []AgentFunction{
+			{Name: "lookup", Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+				return Result{Success: true, Data: map[string]int{"count": 3}}
+			}},
+			{Name: "broken", Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+				return Result{Error: errors.New("backend unavailable")}
+			}},
+		},
+	}
+
+	calls := llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{
+			Role: llm.RoleAssistant,
+			ToolCalls: []llm.ToolCall{
+				{ID: "call_1", Type: "function", Function: llm.ToolCallFunction{Name: "lookup", Arguments: `{"q": "go"}`}},
+				{ID: "call_2", Type: "function", Function: llm.ToolCallFunction{Name: "broken", Arguments: `{}`}},
+				{ID: "call_3", Type: "function", Function: llm.ToolCallFunction{Name: "missing", Arguments: `{}`}},
+				{ID: "call_4", Type: "function", Function: llm.ToolCallFunction{Name: "lookup", Arguments: `{"q":`}},
+			},
+		}}},
+	}
+	final := llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "Done."}}},
+	}
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(calls, nil).Once()
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(final, nil).Once()
+
+	response, err := sw.Run(ctx, agent, []llm.Message{{Role: llm.RoleUser, Content: "Hi"}}, nil, "", false, false, 5, true)
+
+	assert.NoError(t, err)
+	assert.Len(t, response.ToolResults, 4)
+
+	ok := response.ToolResults[0]
+	assert.Equal(t, "call_1", ok.ToolCallID)
+	assert.Equal(t, map[string]interface{}{"q": "go"}, ok.Args)
+	assert.True(t, ok.Result.Success)
+	assert.Equal(t, map[string]int{"count": 3}, ok.Result.Data)
+	assert.Equal(t, ToolErrorNone, ok.ErrorKind)
+	assert.Equal(t, `{"count":3}`, response.Messages[1].Content)
+	assert.False(t, response.Messages[1].IsError)
+	for _, message := range response.Messages[2:5] {
+		assert.True(t, message.IsError, message.Content)
+	}
+
+	failed := response.ToolResults[1]
+	assert.Equal(t, "call_2", failed.ToolCallID)
+	assert.False(t, failed.Result.Success)
+	assert.EqualError(t, failed.Result.Error, "backend unavailable")
+	assert.Equal(t, ToolErrorFunction, failed.ErrorKind)
+
+	missing := response.ToolResults[2]
+	assert.ErrorIs(t, missing.Result.Error, ErrToolNotFound)
+	assert.Equal(t, ToolErrorNotFound, missing.ErrorKind)
+
+	unparsable := response.ToolResults[3]
+	assert.ErrorIs(t, unparsable.Result.Error, ErrInvalidToolArgs)
+	assert.Equal(t, ToolErrorParse, unparsable.ErrorKind)
+	assert.Nil(t, unparsable.Args)
+	mockClient.AssertExpectations(t)
+}
+
+// TestRunParallelToolCalls tests that parallel tool calls run concurrently within the limit,
+// keep call order in history, merge context variables in call order and resolve handoffs deterministically
+func TestRunParallelToolCalls(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+	sw.config.MaxParallelToolCalls = 2
+	ctx := context.Background()
+
+	billing := &Agent{Name: "Billing"}
+	support := &Agent{Name: "Support"}
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	tool := func(delay time.Duration, next *Agent) func(map[string]interface{}, map[string]interface{}) Result {
+		return func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+
+			time.Sleep(delay)
+			name := args["name"].(string)
+			contextVariables[name] = true
+			contextVariables["last"] = name
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return Result{Success: true, Data: name, Agent: next}
+		}
+	}
+
+	agent := &Agent{
+		Name:              "TestAgent",
+		Model:             "test-model",
+		ParallelToolCalls: true,
+		Functions: []AgentFunction{
+			{Name: "slow", Function: tool(30*time.Millisecond, billing)},
+			{Name: "fast", Function: tool(time.Millisecond, support)},
+			{Name: "plain", Function: tool(time.Millisecond, nil)},
+		},
+	}
+
+	calls := llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{
+			Role: llm.RoleAssistant,
+			ToolCalls: []llm.ToolCall{
+				{ID: "call_1", Type: "function", Function: llm.ToolCallFunction{Name: "slow", Arguments: `{"name": "first"}`}},
+				{ID: "call_2", Type: "function", Function: llm.ToolCallFunction{Name: "fast", Arguments: `{"name": "second"}`}},
+				{ID: "call_3", Type: "function", Function: llm.ToolCallFunction{Name: "plain", Arguments: `{"name": "third"}`}},
+			},
+		}}},
+	}
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(calls, nil).Once()
+
+	contextVariables := map[string]interface{}{"last": "none"}
+	response, err := sw.Run(ctx, agent, []llm.Message{{Role: llm.RoleUser, Content: "Hi"}}, contextVariables, "", false, false, 5, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, maxRunning)
+	assert.Len(t, response.Messages, 4)
+	for i, id := range []string{"call_1", "call_2", "call_3"} {
+		assert.Equal(t, id, response.Messages[i+1].ToolCallID)
+		assert.Equal(t, id, response.ToolResults[i].ToolCallID)
+	}
+	assert.Equal(t, "third", response.ContextVariables["last"])
+	assert.Equal(t, true, response.ContextVariables["first"])
+	assert.Equal(t, true, response.ContextVariables["second"])
+	assert.Equal(t, "none", contextVariables["last"])
+	assert.Equal(t, StopHandoff, response.StopReason)
+	assert.Equal(t, billing, response.Agent)
+	mockClient.AssertExpectations(t)
+}
+
+// TestConcurrentRunsShareAgent tests that runs sharing an agent and its context variables
+// don't race, including tools that keep running after their timeout. Run it with -race.
+func TestConcurrentRunsShareAgent(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+
+	agent := (&Agent{Name: "Counter", Model: "test-model", Memory: NewMemoryStore(10)}).
+		WithInstructionsFunc(func(contextVariables map[string]interface{}) string {
+			return fmt.Sprintf("Count is %v", contextVariables["count"])
+		}).
+		WithParallelToolCalls(true)
+	agent.Functions = []AgentFunction{
+		{
+			Name: "count",
+			Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+				n, _ := contextVariables["count"].(int)
+				contextVariables["count"] = n + 1
+				return Result{Success: true, Data: n + 1}
+			},
+		},
+		{
+			Name:    "stuck",
+			Timeout: time.Millisecond,
+			Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+				for i := 0; i < 20; i++ {
+					time.Sleep(time.Millisecond)
+					contextVariables["stuck"] = i
+				}
+				return Result{Success: true}
+			},
+		},
+	}
+
+	calls := toolCallResponse("call_1", "count", `{}`)
+	calls.Choices[0].Message.ToolCalls = append(calls.Choices[0].Message.ToolCalls,
+		llm.ToolCall{ID: "call_2", Type: "function", Function: llm.ToolCallFunction{Name: "count", Arguments: `{}`}},
+		llm.ToolCall{ID: "call_3", Type: "function", Function: llm.ToolCallFunction{Name: "stuck", Arguments: `{}`}})
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.MatchedBy(func(req llm.ChatCompletionRequest) bool {
+		return len(req.Messages) == 2
+	})).Return(calls, nil)
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "Done."}}},
+	}, nil)
+
+	shared := map[string]interface{}{"count": 0}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := sw.Run(context.Background(), agent, []llm.Message{{Role: llm.RoleUser, Content: "Count"}}, shared, "", false, false, 5, true)
+			assert.NoError(t, err)
+			assert.Equal(t, StopFinalMessage, response.StopReason)
+			assert.Equal(t, agent, response.Agent)
+			assert.Equal(t, 1, response.ContextVariables["count"])
+			assert.NotContains(t, response.ContextVariables, "stuck")
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 0, shared["count"])
+	time.Sleep(30 * time.Millisecond)
+}
+
+// TestRunMaxTurns tests that Run stops once maxTurns model calls have been made
+func TestRunMaxTurns(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+	ctx := context.Background()
+
+	agent := &Agent{
+		Name:  "TestAgent",
+		Model: "test-model",
+		Functions: []AgentFunction{
+			{
+				Name: "loop",
+				Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+					return Result{Success: true, Data: "again"}
+				},
+			},
+		},
+	}
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(toolCallResponse("call_1", "loop", `{}`), nil)
+
+	response, err := sw.Run(ctx, agent, []llm.Message{{Role: llm.RoleUser, Content: "Hi"}}, nil, "", false, false, 2, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, StopMaxTurns, response.StopReason)
+	assert.Equal(t, 2, response.Turns)
+	mockClient.AssertNumberOfCalls(t, "CreateChatCompletion", 2)
+}
+
+// TestRunHandoff tests that a tool returning a different agent ends the run
+func TestRunHandoff(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+	ctx := context.Background()
+
+	spanishAgent := &Agent{Name: "SpanishAgent", Model: "test-model"}
+	agent := &Agent{
+		Name:  "EnglishAgent",
+		Model: "test-model",
+		Functions: []AgentFunction{
+			{
+				Name: "transfer",
+				Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+					return Result{Success: true, Data: "transferring", Agent: spanishAgent}
+				},
+			},
+		},
+	}
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(toolCallResponse("call_1", "transfer", `{}`), nil).Once()
+
+	response, err := sw.Run(ctx, agent, []llm.Message{{Role: llm.RoleUser, Content: "Hola"}}, nil, "", false, false, 5, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, StopHandoff, response.StopReason)
+	assert.Equal(t, spanishAgent, response.Agent)
+	mockClient.AssertExpectations(t)
+}
+
+// TestRunAgentHandoffs tests the generated transfer tools, their input and history filters
+func TestRunAgentHandoffs(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+
+	billing := &Agent{Name: "BillingAgent", Model: "test-model"}
+	triage := (&Agent{Name: "Triage Agent", Model: "test-model"}).WithHandoffs(Handoff{
+		Agent:       billing,
+		Description: "Transfer billing questions.",
+		InputSchema: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"reason": map[string]interface{}{"type": "string"}},
+			"required":   []string{"reason"},
+		},
+		HistoryFilter: StripToolCalls,
+	})
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.MatchedBy(func(req llm.ChatCompletionRequest) bool {
+		return len(req.Tools) == 1 && req.Tools[0].Function.Name == "transfer_to_billing_agent" &&
+			req.Tools[0].Function.Description == "Transfer billing questions."
+	})).Return(toolCallResponse("call_1", "transfer_to_billing_agent", `{"reason": "refund"}`), nil).Once()
+
+	messages := []llm.Message{{Role: llm.RoleUser, Content: "I want a refund"}}
+	response, err := sw.Run(context.Background(), triage, messages, nil, "", false, false, 5, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, StopHandoff, response.StopReason)
+	assert.Equal(t, billing, response.Agent)
+	assert.Len(t, response.Messages, 2)
+	if assert.NotNil(t, response.Handoff) {
+		assert.Equal(t, "Triage Agent", response.Handoff.FromAgent)
+		assert.Equal(t, "BillingAgent", response.Handoff.ToAgent)
+		assert.Equal(t, "call_1", response.Handoff.ToolCallID)
+		assert.Equal(t, map[string]interface{}{"reason": "refund"}, response.Handoff.Args)
+		assert.Equal(t, messages, response.Handoff.Messages)
+	}
+	mockClient.AssertExpectations(t)
+}
+
+// TestSummarizeHistoryUsage tests that the summary written for a handoff is counted in the run's usage
+func TestSummarizeHistoryUsage(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+	sw.config.SummaryModel = "summary-model"
+
+	billing := &Agent{Name: "BillingAgent", Model: "test-model"}
+	triage := (&Agent{Name: "TriageAgent", Model: "test-model"}).WithHandoffs(Handoff{
+		Agent:         billing,
+		HistoryFilter: SummarizeHistory,
+	})
+
+	transfer := toolCallResponse("call_1", "transfer_to_billing_agent", `{}`)
+	transfer.Usage = llm.Usage{PromptTokens: 10, CompletionTokens: 2}
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.MatchedBy(func(req llm.ChatCompletionRequest) bool {
+		return req.Model == "test-model"
+	})).Return(transfer, nil).Once()
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.MatchedBy(func(req llm.ChatCompletionRequest) bool {
+		return req.Model == "summary-model"
+	})).Return(llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "Wants a refund."}}},
+		Usage:   llm.Usage{PromptTokens: 20, CompletionTokens: 4},
+	}, nil).Once()
+
+	response, err := sw.Run(context.Background(), triage, []llm.Message{
+		{Role: llm.RoleUser, Content: "Hi"},
+		{Role: llm.RoleAssistant, Content: "Hello"},
+		{Role: llm.RoleUser, Content: "I want a refund"},
+	}, nil, "", false, false, 5, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, StopHandoff, response.StopReason)
+	assert.Equal(t, 2, response.Usage.Requests)
+	assert.Equal(t, 36, response.Usage.TotalTokens)
+	if assert.Len(t, response.Usage.Turns, 2) {
+		assert.False(t, response.Usage.Turns[0].Summary)
+		assert.True(t, response.Usage.Turns[1].Summary)
+		assert.Equal(t, "summary-model", response.Usage.Turns[1].Model)
+	}
+	mockClient.AssertExpectations(t)
+}
+
+// TestHistoryFilters tests the built-in history filters and tool names
+func TestHistoryFilters(t *testing.T) {
+	history := []llm.Message{
+		{Role: llm.RoleUser, Content: "Hi"},
+		{Role: llm.RoleAssistant, Content: "Hello"},
+		{Role: llm.RoleUser, Content: "Refund please"},
+		{Role: llm.RoleAssistant, ToolCalls: []llm.ToolCall{{ID: "call_1", Function: llm.ToolCallFunction{Name: "transfer_to_billing"}}}},
+		{Role: llm.RoleTool, ToolCallID: "call_1", Content: "Transferred to Billing."},
+	}
+	input := HandoffInput{History: history}
+
+	last, err := KeepLastMessages(1)(context.Background(), input)
+	assert.NoError(t, err)
+	assert.Equal(t, history[3:], last)
+
+	stripped, err := StripToolCalls(context.Background(), input)
+	assert.NoError(t, err)
+	assert.Equal(t, history[:3], stripped)
+
+	assert.Equal(t, "billing_agent", snakeCase("BillingAgent"))
+	assert.Equal(t, "billing_agent", snakeCase("Billing Agent"))
+	assert.Equal(t, "http_api_agent", snakeCase("HTTPApiAgent"))
+}
+
+// TestAgentTool tests that an agent wrapped as a tool runs nested under the parent run
+func TestAgentTool(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+
+	researcher := &Agent{Name: "Researcher", Model: "worker-model"}
+	manager := &Agent{
+		Name:      "Manager",
+		Model:     "manager-model",
+		Functions: []AgentFunction{NewAgentTool(sw, researcher, AgentToolConfig{MaxTurns: 2})},
+	}
+
+	isModel := func(model string) interface{} {
+		return mock.MatchedBy(func(req llm.ChatCompletionRequest) bool { return req.Model == model })
+	}
+	first := toolCallResponse("call_1", "researcher", `{"input": "What is the answer?"}`)
+	first.Usage = llm.Usage{PromptTokens: 100, CompletionTokens: 10}
+	mockClient.On("CreateChatCompletion", mock.Anything, isModel("manager-model")).Return(first, nil).Once()
+	mockClient.On("CreateChatCompletion", mock.Anything, isModel("worker-model")).Return(llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "42"}}},
+		Usage:   llm.Usage{PromptTokens: 50, CompletionTokens: 5},
+	}, nil).Once()
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.MatchedBy(func(req llm.ChatCompletionRequest) bool {
+		last := req.Messages[len(req.Messages)-1]
+		return req.Model == "manager-model" && last.ToolCallID == "call_1" && last.Content == "42"
+	})).Return(llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "The answer is 42."}}},
+	}, nil).Once()
+
+	runIDs := make(map[string]string)
+	ctx := WithRunHooks(context.Background(), RunHooksFunc(func(ctx context.Context, event RunEvent) {
+		if event.Type == EventRunStart {
+			runIDs[event.Agent] = event.RunID
+			runIDs[event.Agent+".parent"] = event.ParentRunID
+		}
+	}))
+	response, err := sw.Run(ctx, manager, []llm.Message{{Role: llm.RoleUser, Content: "Ask the researcher"}}, nil, "", false, false, 5, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, StopFinalMessage, response.StopReason)
+	assert.Equal(t, manager, response.Agent)
+	assert.Equal(t, 2, response.Turns)
+	assert.Equal(t, 3, response.Usage.Requests)
+	assert.Equal(t, 150, response.Usage.PromptTokens)
+	assert.Equal(t, 15, response.Usage.CompletionTokens)
+	assert.Equal(t, 1, response.Usage.ByAgent["Researcher"].Requests)
+	assert.Equal(t, runIDs["Manager"], runIDs["Researcher.parent"])
+	mockClient.AssertExpectations(t)
+}
+
+// TestRunCrossProviderHandoff tests that agents on different providers can share one Swarm
+func TestRunCrossProviderHandoff(t *testing.T) {
+	triageClient := new(MockLLM)
+	specialistClient := new(MockLLM)
+	sw := NewMockSwarm(triageClient)
+	sw.RegisterClient(llm.Ollama, specialistClient)
+	ctx := context.Background()
+
+	specialist := &Agent{Name: "Specialist", Model: "llama3", Provider: llm.Ollama}
+	triage := &Agent{
+		Name:  "Triage",
+		Model: "test-model",
+		Functions: []AgentFunction{
+			{Name: "transfer", Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+				return Result{Success: true, Data: "Transferring", Agent: specialist}
+			}},
+		},
+	}
+
+	triageClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(toolCallResponse("call_1", "transfer", `{}`), nil).Once()
+	specialistClient.On("CreateChatCompletion", mock.Anything, mock.MatchedBy(func(req llm.ChatCompletionRequest) bool {
+		return req.Model == "llama3"
+	})).Return(llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "Specialist here."}}},
+	}, nil).Once()
+
+	history := []llm.Message{{Role: llm.RoleUser, Content: "Hi"}}
+	response, err := sw.Run(ctx, triage, history, nil, "", false, false, 5, true)
+	assert.NoError(t, err)
+	assert.Equal(t, StopHandoff, response.StopReason)
+
+	history = append(history, response.Messages...)
+	response, err = sw.Run(ctx, response.Agent, history, nil, "", false, false, 5, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "Specialist here.", response.Messages[0].Content)
+	triageClient.AssertExpectations(t)
+	specialistClient.AssertExpectations(t)
+}
+
+// TestClientForAgentConfig tests that clients built from an agent's config are created once and shared
+func TestClientForAgentConfig(t *testing.T) {
+	sw := NewMockSwarm(new(MockLLM))
+
+	config := &ClientConfig{Provider: llm.OpenAI, AuthToken: "agent-key"}
+	first, err := sw.clientFor(&Agent{Name: "A", Config: config})
+	assert.NoError(t, err)
+	second, err := sw.clientFor(&Agent{Name: "B", Config: &ClientConfig{Provider: llm.OpenAI, AuthToken: "agent-key"}})
+	assert.NoError(t, err)
+	other, err := sw.clientFor(&Agent{Name: "C", Config: &ClientConfig{Provider: llm.OpenAI, AuthToken: "other-key"}})
+	assert.NoError(t, err)
+
+	assert.IsType(t, &llm.OpenAILLM{}, first)
+	assert.Same(t, first, second)
+	assert.NotSame(t, first, other)
+
+	_, err = sw.clientFor(&Agent{Name: "D", Provider: "unknown", Config: &ClientConfig{}})
+	assert.ErrorIs(t, err, ErrInvalidProvider)
+
+	// Every setting is part of the client's identity
+	orgClient, err := sw.clientFor(&Agent{Name: "E", Config: &ClientConfig{Provider: llm.OpenAI, AuthToken: "agent-key", OrgID: "org-1"}})
+	assert.NoError(t, err)
+	assert.NotSame(t, first, orgClient)
+	httpClient, err := sw.clientFor(&Agent{Name: "F", Config: &ClientConfig{Provider: llm.OpenAI, AuthToken: "agent-key", HTTPClient: &http.Client{}}})
+	assert.NoError(t, err)
+	assert.NotSame(t, first, httpClient)
+
+	// Settings the provider's client can't apply are rejected rather than ignored
+	_, err = sw.clientFor(&Agent{Name: "G", Config: &ClientConfig{Provider: llm.Gemini, AuthToken: "key", BaseURL: "http://localhost"}})
+	assert.ErrorIs(t, err, ErrUnsupportedClientConfig)
+	_, err = sw.clientFor(&Agent{Name: "H", Config: &ClientConfig{Provider: llm.OpenAI, Options: map[string]interface{}{"beta": true}}})
+	assert.ErrorIs(t, err, ErrUnsupportedClientConfig)
+}
+
+// TestModelMapperConfig tests that a config setting only ModelMapperFunc maps the model on the usual client
+func TestModelMapperConfig(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+	agent := &Agent{
+		Name:   "AzureAgent",
+		Model:  "gpt-4o",
+		Config: &ClientConfig{ModelMapperFunc: func(model string) string { return "deployment-" + model }},
+	}
+
+	client, err := sw.clientFor(agent)
+	assert.NoError(t, err)
+	assert.Same(t, mockClient, client)
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.MatchedBy(func(req llm.ChatCompletionRequest) bool {
+		return req.Model == "deployment-gpt-4o"
+	})).Return(llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "Hi"}}},
+	}, nil).Once()
+	_, err = sw.Run(context.Background(), agent, []llm.Message{{Role: llm.RoleUser, Content: "Hi"}}, nil, "", false, false, 5, true)
+	assert.NoError(t, err)
+	mockClient.AssertExpectations(t)
+
+	// Alongside other settings it is accepted too
+	_, err = sw.clientFor(&Agent{Name: "B", Config: &ClientConfig{
+		Provider: llm.OpenAI, AuthToken: "key", ModelMapperFunc: strings.ToUpper,
+	}})
+	assert.NoError(t, err)
+}
+
+// TestRegisterClientKeepsSwarmClient tests that a registered client only serves agents naming its provider
+func TestRegisterClientKeepsSwarmClient(t *testing.T) {
+	sw := NewSwarm("test-api-key", llm.OpenAI)
+	registered := new(MockLLM)
+	sw.RegisterClient(llm.OpenAI, registered)
+
+	client, err := sw.clientFor(&Agent{Name: "Default"})
+	assert.NoError(t, err)
+	assert.Same(t, sw.client, client)
+
+	client, err = sw.clientFor(&Agent{Name: "Explicit", Provider: llm.OpenAI})
+	assert.NoError(t, err)
+	assert.Same(t, registered, client)
+}
+
+// TestRunUsage tests that Run reports token usage and cost per turn, agent and model
+func TestRunUsage(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+	sw.config.Pricing = map[string]ModelPricing{
+		"test-model": {PromptPerMillion: 2, CompletionPerMillion: 10},
+	}
+	ctx := context.Background()
+
+	agent := &Agent{
+		Name:  "TestAgent",
+		Model: "test-model-2024",
+		Functions: []AgentFunction{
+			{Name: "search", Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+				return Result{Success: true, Data: "found"}
+			}},
+		},
+	}
+
+	first := toolCallResponse("call_1", "search", `{}`)
+	first.Usage = llm.Usage{PromptTokens: 1000, CompletionTokens: 100, TotalTokens: 1100}
+	final := llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "Done."}}},
+		Usage:   llm.Usage{PromptTokens: 2000, CompletionTokens: 200},
+	}
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(first, nil).Once()
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(final, nil).Once()
+
+	response, err := sw.Run(ctx, agent, []llm.Message{{Role: llm.RoleUser, Content: "Hi"}}, nil, "", false, false, 5, true)
+
+	assert.NoError(t, err)
+	usage := response.Usage
+	assert.Equal(t, 2, usage.Requests)
+	assert.Equal(t, 3000, usage.PromptTokens)
+	assert.Equal(t, 300, usage.CompletionTokens)
+	assert.Equal(t, 3300, usage.TotalTokens)
+	assert.InDelta(t, 0.009, usage.Cost, 1e-9)
+	assert.Len(t, usage.Turns, 2)
+	assert.Equal(t, 2, usage.Turns[1].Turn)
+	assert.Equal(t, 2200, usage.Turns[1].TotalTokens)
+	assert.Equal(t, usage.Usage, usage.ByAgent["TestAgent"])
+	assert.Equal(t, usage.Usage, usage.ByModel["test-model-2024"])
+
+	var rollup RunUsage
+	rollup.Merge(usage)
+	rollup.Merge(usage)
+	assert.Equal(t, 6600, rollup.TotalTokens)
+	assert.Equal(t, 4, rollup.ByAgent["TestAgent"].Requests)
+	mockClient.AssertExpectations(t)
+}
+
+// TestRunContextWindow tests the strategies applied when the prompt exceeds the model's token limit
+func TestRunContextWindow(t *testing.T) {
+	history := []llm.Message{
+		{Role: llm.RoleUser, Content: "one two three four five six seven eight nine ten"},
+		{Role: llm.RoleAssistant, ToolCalls: []llm.ToolCall{
+			{ID: "call_1", Type: "function", Function: llm.ToolCallFunction{Name: "search", Arguments: "{}"}},
+		}},
+		{Role: llm.RoleTool, Name: "search", ToolCallID: "call_1", Content: "found it"},
+		{Role: llm.RoleUser, Content: "and now?"},
+	}
+	agent := &Agent{Name: "TestAgent", Model: "test-model", Instructions: "Be brief"}
+	final := llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "Done."}}},
+	}
+
+	// newSwarm counts one token per word, on top of four per message
+	newSwarm := func(mockClient *MockLLM, limit int, strategy ContextStrategy) *Swarm {
+		sw := NewMockSwarm(mockClient)
+		sw.SetTokenCounter(func(text string) int { return len(strings.Fields(text)) })
+		sw.config.TokenLimits = map[string]int{"test-model": limit}
+		sw.config.MaxTokens = 0
+		sw.config.ContextStrategy = strategy
+		sw.config.SummaryModel = "summary-model"
+		return sw
+	}
+	roles := func(messages []llm.Message) []llm.Role {
+		var roles []llm.Role
+		for _, msg := range messages {
+			roles = append(roles, msg.Role)
+		}
+		return roles
+	}
+
+	t.Run("fail", func(t *testing.T) {
+		mockClient := new(MockLLM)
+		sw := newSwarm(mockClient, 20, ContextFail)
+
+		_, err := sw.Run(context.Background(), agent, history, nil, "", false, false, 5, true)
+
+		assert.ErrorIs(t, err, ErrMessageTooLong)
+		mockClient.AssertNotCalled(t, "CreateChatCompletion", mock.Anything, mock.Anything)
+	})
+
+	t.Run("keeps room for the completion", func(t *testing.T) {
+		mockClient := new(MockLLM)
+		sw := newSwarm(mockClient, 40, ContextFail)
+		mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(final, nil).Once()
+
+		// The 39 token prompt fits the window on its own
+		_, err := sw.Run(context.Background(), agent, history, nil, "", false, false, 5, true)
+		assert.NoError(t, err)
+
+		// but not with 10 tokens kept for the completion
+		sw.config.MaxTokens = 10
+		_, err = sw.Run(context.Background(), agent, history, nil, "", false, false, 5, true)
+		assert.ErrorIs(t, err, ErrMessageTooLong)
+		mockClient.AssertNumberOfCalls(t, "CreateChatCompletion", 1)
+	})
+
+	t.Run("drop oldest keeps tool pairs", func(t *testing.T) {
+		for limit, expected := range map[int][]llm.Role{
+			30: {llm.RoleSystem, llm.RoleAssistant, llm.RoleTool, llm.RoleUser},
+			15: {llm.RoleSystem, llm.RoleUser},
+		} {
+			mockClient := new(MockLLM)
+			sw := newSwarm(mockClient, limit, ContextDropOldest)
+			var sent []llm.Message
+			mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				sent = args.Get(1).(llm.ChatCompletionRequest).Messages
+			}).Return(final, nil).Once()
+
+			response, err := sw.Run(context.Background(), agent, history, nil, "", false, false, 5, true)
+
+			assert.NoError(t, err)
+			assert.Equal(t, expected, roles(sent))
+			assert.Equal(t, "and now?", sent[len(sent)-1].Content)
+			assert.Len(t, response.Messages, 1)
+		}
+	})
+
+	t.Run("streaming", func(t *testing.T) {
+		m := llmmock.New()
+		m.Enqueue(llmmock.ToolCalls(llmmock.Call("search", `{}`)), llmmock.Text("Done."))
+		sw := NewSwarmWithCustomProvider(m, DefaultConfig())
+		sw.SetTokenCounter(func(text string) int { return len(strings.Fields(text)) })
+		sw.config.TokenLimits = map[string]int{"test-model": 44}
+		sw.config.MaxTokens = 0
+		sw.config.ContextStrategy = ContextDropOldest
+		searching := &Agent{Name: "TestAgent", Model: "test-model", Instructions: "Be brief", Functions: []AgentFunction{{
+			Name: "search",
+			Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+				return Result{Success: true, Data: strings.Repeat("result ", 12)}
+			},
+		}}}
+		// The tool definition takes tokens of its own, so count them in
+		def := FunctionToDefinition(searching.Functions[0])
+		sw.config.TokenLimits["test-model"] += sw.countRequestTokens(llm.ChatCompletionRequest{Tools: []llm.Tool{{
+			Type: "function", Function: &llm.Function{Name: def.Name, Description: def.Description, Parameters: def.Parameters},
+		}}})
+
+		err := sw.StreamingResponse(context.Background(), searching, history, nil, "", &recordingStreamHandler{}, false)
+
+		assert.NoError(t, err)
+		requests := m.Requests()
+		if assert.Len(t, requests, 2) {
+			// The first request fits, the second drops the oldest turns to make room for the tool result
+			assert.Len(t, requests[0].Messages, 5)
+			assert.Equal(t, []llm.Role{llm.RoleSystem, llm.RoleUser, llm.RoleAssistant, llm.RoleTool}, roles(requests[1].Messages))
+		}
+
+		sw.config.ContextStrategy = ContextFail
+		sw.config.TokenLimits["test-model"] = 20
+		err = sw.StreamingResponse(context.Background(), searching, history, nil, "", &recordingStreamHandler{}, false)
+		assert.ErrorIs(t, err, ErrMessageTooLong)
+		assert.Len(t, m.Requests(), 2)
+	})
+
+	t.Run("summarize", func(t *testing.T) {
+		mockClient := new(MockLLM)
+		sw := newSwarm(mockClient, 36, ContextSummarize)
+		summary := llm.ChatCompletionResponse{
+			Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "Counted."}}},
+			Usage:   llm.Usage{PromptTokens: 30, CompletionTokens: 5},
+		}
+		mockClient.On("CreateChatCompletion", mock.Anything, mock.MatchedBy(func(req llm.ChatCompletionRequest) bool {
+			return req.Model == "summary-model" && strings.Contains(req.Messages[1].Content, "one two three")
+		})).Return(summary, nil).Once()
+		var sent []llm.Message
+		mockClient.On("CreateChatCompletion", mock.Anything, mock.MatchedBy(func(req llm.ChatCompletionRequest) bool {
+			return req.Model == "test-model"
+		})).Run(func(args mock.Arguments) {
+			sent = args.Get(1).(llm.ChatCompletionRequest).Messages
+		}).Return(final, nil).Once()
+
+		response, err := sw.Run(context.Background(), agent, history, nil, "", false, false, 5, true)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []llm.Role{llm.RoleSystem, llm.RoleAssistant, llm.RoleTool, llm.RoleUser}, roles(sent))
+		// The summary's call is billed to the run
+		assert.Equal(t, 2, response.Usage.Requests)
+		assert.Equal(t, 35, response.Usage.TotalTokens)
+		assert.Equal(t, 35, response.Usage.ByModel["summary-model"].TotalTokens)
+		if assert.Len(t, response.Usage.Turns, 2) {
+			assert.True(t, response.Usage.Turns[1].Summary)
+			assert.Equal(t, 1, response.Usage.Turns[1].Turn)
+			assert.Equal(t, "TestAgent", response.Usage.Turns[1].Agent)
+		}
+		assert.True(t, strings.HasPrefix(sent[0].Content, "Be brief"))
+		assert.Contains(t, sent[0].Content, "Counted.")
+		mockClient.AssertExpectations(t)
+	})
+}
+
+// TestTokenLimitFor tests that limits match dated versions of a model but not other models
+// sharing its name, and that models without a limit aren't checked
+func TestTokenLimitFor(t *testing.T) {
+	sw := NewMockSwarm(new(MockLLM))
+
+	for model, limit := range map[string]int{
+		"gpt-4":                      8192,
+		"gpt-4-0613":                 8192,
+		"gpt-4-turbo-2024-04-09":     128000,
+		"gpt-4o-mini":                128000,
+		"gpt-4.1":                    1047576,
+		"claude-3-5-sonnet-20241022": 200000,
+		"claude-3-5-sonnet-latest":   200000,
+		"gpt-4-custom":               0,
+		"llama3":                     0,
+	} {
+		assert.Equal(t, limit, sw.tokenLimitFor(model), model)
+	}
+
+	agent := &Agent{Name: "TestAgent", Model: "llama3"}
+	mockClient := sw.client.(*MockLLM)
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "Done."}}},
+	}, nil).Once()
+	long := strings.Repeat("word ", 50000)
+	_, err := sw.Run(context.Background(), agent, []llm.Message{{Role: llm.RoleUser, Content: long}}, nil, "", false, false, 5, true)
+	assert.NoError(t, err)
+}
+
+// eofStream is a stream that ends immediately
+type eofStream struct{}
+
+func (eofStream) Recv() (llm.ChatCompletionResponse, error) {
+	return llm.ChatCompletionResponse{}, io.EOF
+}
+
+func (eofStream) Close() error { return nil }
+
+// TestSwarmUseMiddleware tests that middleware wraps both sync and streaming calls in order
+func TestSwarmUseMiddleware(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+	agent := &Agent{Name: "TestAgent", Model: "test-model"}
+
+	var calls []string
+	record := func(name string) llm.Middleware {
+		return llm.MiddlewareFuncs(
+			func(next llm.CompletionFunc) llm.CompletionFunc {
+				return func(ctx context.Context, req llm.ChatCompletionRequest) (llm.ChatCompletionResponse, error) {
+					calls = append(calls, name)
+					return next(ctx, req)
+				}
+			},
+			func(next llm.StreamFunc) llm.StreamFunc {
+				return func(ctx context.Context, req llm.ChatCompletionRequest) (llm.ChatCompletionStream, error) {
+					calls = append(calls, name+" stream")
+					return next(ctx, req)
+				}
+			},
+		)
+	}
+	rewrite := llm.MiddlewareFuncs(func(next llm.CompletionFunc) llm.CompletionFunc {
+		return func(ctx context.Context, req llm.ChatCompletionRequest) (llm.ChatCompletionResponse, error) {
+			req.Model = "rewritten-model"
+			return next(ctx, req)
+		}
+	}, nil)
+	sw.Use(record("outer"), rewrite)
+	sw.Use(record("inner"))
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.MatchedBy(func(req llm.ChatCompletionRequest) bool {
+		return req.Model == "rewritten-model"
+	})).Return(llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "Hi"}}},
+	}, nil).Once()
+	mockClient.On("CreateChatCompletionStream", mock.Anything, mock.Anything).Return(eofStream{}, nil).Once()
+
+	_, err := sw.Run(context.Background(), agent, []llm.Message{{Role: llm.RoleUser, Content: "Hello"}}, nil, "", false, false, 5, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"outer", "inner"}, calls)
+
+	calls = nil
+	err = sw.StreamingResponse(context.Background(), agent, []llm.Message{{Role: llm.RoleUser, Content: "Hello"}}, nil, "", nil, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"outer stream", "inner stream"}, calls)
+	mockClient.AssertExpectations(t)
+}
+
+// TestGraphAgentNodeMiddleware tests that agent nodes run on the graph's Swarm and middleware
+func TestGraphAgentNodeMiddleware(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "Done"}}},
+	}, nil).Once()
+
+	requests := 0
+	graph := NewGraph("test", "middleware test")
+	graph.Use(llm.MiddlewareFuncs(func(next llm.CompletionFunc) llm.CompletionFunc {
+		return func(ctx context.Context, req llm.ChatCompletionRequest) (llm.ChatCompletionResponse, error) {
+			requests++
+			return next(ctx, req)
+		}
+	}, nil))
+	graph.SetSwarm(sw)
+	graph.SetSwarm(sw)
+	graph.AddAgentNode("agent", "Agent", &Agent{Name: "Agent", Model: "test-model"})
+	assert.NoError(t, graph.SetEntryPoint("agent"))
+	assert.NoError(t, graph.AddExitPoint("agent"))
+
+	// Another graph sharing the Swarm doesn't add the first graph's middleware to it
+	other := NewGraph("other", "shares the Swarm")
+	other.SetSwarm(sw)
+
+	state, err := graph.ExecuteGraph(context.Background(), GraphState{
+		MessageKey: []llm.Message{{Role: llm.RoleUser, Content: "Hello"}},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, requests)
+	assert.Len(t, state[MessageKey], 2)
+	assert.Empty(t, sw.middleware)
+	mockClient.AssertExpectations(t)
+}
+
+// TestUseToolMiddleware tests that tool middleware can rewrite arguments, short-circuit and transform results
+func TestUseToolMiddleware(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+
+	deleted := false
+	agent := &Agent{
+		Name:  "TestAgent",
+		Model: "test-model",
+		Functions: []AgentFunction{
+			{
+				Name: "greet",
+				Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+					return Result{Success: true, Data: "hello " + args["name"].(string)}
+				},
+			},
+			{
+				Name: "delete",
+				Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+					deleted = true
+					return Result{Success: true, Data: "deleted"}
+				},
+			},
+		},
+	}
+
+	// authorize blocks delete unless the run belongs to an admin
+	authorize := func(next ToolHandler) ToolHandler {
+		return func(ctx context.Context, call ToolInvocation) Result {
+			if call.Function.Name == "delete" && call.ContextVariables["role"] != "admin" {
+				return Result{Error: errors.New("not authorized")}
+			}
+			return next(ctx, call)
+		}
+	}
+	// rewrite normalizes the arguments and the result
+	rewrite := func(next ToolHandler) ToolHandler {
+		return func(ctx context.Context, call ToolInvocation) Result {
+			call.Args = map[string]interface{}{"name": strings.TrimSpace(call.Args["name"].(string))}
+			result := next(ctx, call)
+			if data, ok := result.Data.(string); ok {
+				result.Data = strings.ToUpper(data)
+			}
+			return result
+		}
+	}
+	sw.UseTool(authorize, rewrite)
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{
+			Role: llm.RoleAssistant,
+			ToolCalls: []llm.ToolCall{
+				{ID: "call_1", Type: "function", Function: llm.ToolCallFunction{Name: "greet", Arguments: `{"name":"  bob "}`}},
+				{ID: "call_2", Type: "function", Function: llm.ToolCallFunction{Name: "delete", Arguments: `{"name":"all"}`}},
+			},
+		}}},
+	}, nil).Once()
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "Done"}}},
+	}, nil).Once()
+
+	response, err := sw.Run(context.Background(), agent, []llm.Message{{Role: llm.RoleUser, Content: "Hi"}},
+		map[string]interface{}{"role": "guest"}, "", false, false, 5, true)
+
+	assert.NoError(t, err)
+	assert.False(t, deleted)
+	assert.Len(t, response.ToolResults, 2)
+	assert.Equal(t, "HELLO BOB", response.Messages[1].Content)
+	assert.Equal(t, map[string]interface{}{"name": "bob"}, response.ToolResults[0].Args)
+	assert.Equal(t, "Error: not authorized", response.Messages[2].Content)
+	assert.Equal(t, map[string]interface{}{"name": "all"}, response.ToolResults[1].Args)
+	assert.Equal(t, ToolErrorFunction, response.ToolResults[1].ErrorKind)
+	mockClient.AssertExpectations(t)
+}
+
+// TestRunHooks tests that hooks receive the lifecycle events of a run in order
+func TestRunHooks(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+
+	spanishAgent := &Agent{Name: "SpanishAgent", Model: "test-model"}
+	agent := &Agent{
+		Name:  "EnglishAgent",
+		Model: "test-model",
+		Functions: []AgentFunction{
+			{
+				Name: "transfer",
+				Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+					contextVariables["language"] = "es"
+					return Result{Success: true, Data: "transferring", Agent: spanishAgent}
+				},
+			},
+		},
+	}
+
+	var swarmEvents, callEvents []RunEvent
+	sw.AddHooks(RunHooksFunc(func(ctx context.Context, event RunEvent) {
+		swarmEvents = append(swarmEvents, event)
+	}))
+	ctx := WithRunHooks(context.Background(), RunHooksFunc(func(ctx context.Context, event RunEvent) {
+		callEvents = append(callEvents, event)
+	}))
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(llm.ChatCompletionResponse{}, errors.New("connection reset")).Once()
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(toolCallResponse("call_1", "transfer", `{}`), nil).Once()
+
+	_, err := sw.Run(ctx, agent, []llm.Message{{Role: llm.RoleUser, Content: "Hola"}}, nil, "", false, false, 5, true)
+	assert.NoError(t, err)
+
+	var types []RunEventType
+	for _, event := range swarmEvents {
+		types = append(types, event.Type)
+		assert.NotEmpty(t, event.RunID)
+		assert.Equal(t, swarmEvents[0].RunID, event.RunID)
+		if event.Type != EventRunStart {
+			assert.Equal(t, 1, event.Turn, event.Type)
+		}
+	}
+	assert.Equal(t, []RunEventType{
+		EventRunStart, EventLLMRequest, EventRetry, EventLLMResponse, EventToolStart, EventToolEnd,
+		EventContextVariableChange, EventHandoff, EventRunEnd,
+	}, types)
+	assert.Equal(t, swarmEvents, callEvents)
+
+	assert.Equal(t, 2, swarmEvents[2].Attempt)
+	assert.Equal(t, "transfer", swarmEvents[5].ToolResult.ToolName)
+	assert.Equal(t, "language", swarmEvents[6].Key)
+	assert.Equal(t, "es", swarmEvents[6].Value)
+	assert.Equal(t, "SpanishAgent", swarmEvents[7].ToAgent)
+	assert.Equal(t, StopHandoff, swarmEvents[8].RunResult.StopReason)
+}
+
+// TestGraphRunHooks tests that graph events and the runs of agent nodes share the graph's run
+func TestGraphRunHooks(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "Done"}}},
+	}, nil).Once()
+
+	var events []RunEvent
+	sw.AddHooks(RunHooksFunc(func(ctx context.Context, event RunEvent) {
+		events = append(events, event)
+	}))
+
+	graph := NewGraph("test", "hooks test")
+	graph.SetSwarm(sw)
+	graph.AddAgentNode("agent", "Agent", &Agent{Name: "Agent", Model: "test-model"})
+	assert.NoError(t, graph.SetEntryPoint("agent"))
+	assert.NoError(t, graph.AddExitPoint("agent"))
+
+	_, err := graph.ExecuteGraph(context.Background(), GraphState{
+		MessageKey: []llm.Message{{Role: llm.RoleUser, Content: "Hello"}},
+	})
+	assert.NoError(t, err)
+
+	graphRunID := events[0].RunID
+	var names []string
+	for _, event := range events {
+		if event.Type == EventGraph {
+			names = append(names, event.Name)
+			assert.Equal(t, graphRunID, event.RunID)
+		} else {
+			assert.Equal(t, graphRunID, event.ParentRunID)
+			assert.Equal(t, "Agent", event.Agent)
+		}
+	}
+	assert.Equal(t, []string{"graph_start", "node_enter_agent", "node_exit_agent", "graph_complete"}, names)
+}
+
+// structuredMockLLM is a MockLLM with a native structured output mode
+type structuredMockLLM struct {
+	*MockLLM
+}
+
+func (m structuredMockLLM) SupportsStructuredOutput() bool { return true }
+
+// TestRunStructured tests that RunStructured uses the native mode and re-prompts invalid replies
+func TestRunStructured(t *testing.T) {
+	mockClient := new(MockLLM)
+	config := DefaultConfig()
+	config.RetryBackoff = time.Millisecond
+	sw := NewSwarmWithCustomProvider(structuredMockLLM{mockClient}, config)
+	agent := &Agent{Name: "TestAgent", Model: "test-model", Instructions: "Report the weather"}
+
+	reply := func(content string) llm.ChatCompletionResponse {
+		return llm.ChatCompletionResponse{
+			Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: content}}},
+		}
+	}
+	var requests []llm.ChatCompletionRequest
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		requests = append(requests, args.Get(1).(llm.ChatCompletionRequest))
+	}).Return(reply(`{"city": "Paris"}`), nil).Once()
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		requests = append(requests, args.Get(1).(llm.ChatCompletionRequest))
+	}).Return(reply(`{"city": "Paris", "temperature": 21.5}`), nil).Once()
+
+	report, response, err := RunStructured[weatherReport](context.Background(), sw, agent,
+		[]llm.Message{{Role: llm.RoleUser, Content: "Weather in Paris?"}}, nil, "", 0, 2)
+
+	assert.NoError(t, err)
+	assert.Equal(t, weatherReport{City: "Paris", Temperature: 21.5}, report)
+	assert.Equal(t, 2, response.Turns)
+	assert.Len(t, response.Messages, 3)
+
+	assert.Len(t, requests, 2)
+	assert.Equal(t, "weatherReport", requests[0].ResponseFormat.Name)
+	assert.Equal(t, []interface{}{"city", "temperature"}, requests[0].ResponseFormat.Schema["required"])
+	assert.Equal(t, "Report the weather", requests[0].Messages[0].Content)
+	retry := requests[1].Messages[len(requests[1].Messages)-1]
+	assert.Equal(t, llm.RoleUser, retry.Role)
+	assert.Contains(t, retry.Content, "missing required fields: temperature")
+}
+
+// TestRunStructuredPromptFallback tests that the schema is prompted for when the client has no native mode
+func TestRunStructuredPromptFallback(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+	agent := &Agent{Name: "TestAgent", Model: "test-model", Instructions: "Report the weather"}
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.MatchedBy(func(req llm.ChatCompletionRequest) bool {
+		return req.ResponseFormat == nil &&
+			strings.HasPrefix(req.Messages[0].Content, "Report the weather\n\n") &&
+			strings.Contains(req.Messages[0].Content, `"temperature"`)
+	})).Return(llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{
+			Role:    llm.RoleAssistant,
+			Content: "```json\n{\"city\": \"Oslo\", \"temperature\": -3}\n```",
+		}}},
+	}, nil).Once()
+
+	report, _, err := RunStructured[weatherReport](context.Background(), sw, agent,
+		[]llm.Message{{Role: llm.RoleUser, Content: "Weather in Oslo?"}}, nil, "", 0, 0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, weatherReport{City: "Oslo", Temperature: -3}, report)
+	mockClient.AssertExpectations(t)
+}
+
+// TestRunStructuredGivesUp tests that RunStructured fails once the retries are used up
+func TestRunStructuredGivesUp(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+	agent := &Agent{Name: "TestAgent", Model: "test-model"}
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "It is sunny."}}},
+	}, nil).Times(2)
+
+	_, _, err := RunStructured[weatherReport](context.Background(), sw, agent,
+		[]llm.Message{{Role: llm.RoleUser, Content: "Weather?"}}, nil, "", 0, 1)
+
+	assert.ErrorIs(t, err, ErrInvalidStructuredOutput)
+	mockClient.AssertExpectations(t)
+}
+
+// TestRunInputGuardrails tests that input guardrails rewrite or block the user's message
+func TestRunInputGuardrails(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+
+	redact := NewGuardrail("redact", func(ctx context.Context, check GuardrailCheck) (GuardrailResult, error) {
+		if strings.Contains(check.Content, "hunter2") {
+			return GuardrailResult{Action: GuardrailRewrite, Reason: "password", Content: strings.ReplaceAll(check.Content, "hunter2", "[redacted]")}, nil
+		}
+		return GuardrailResult{Action: GuardrailAllow}, nil
+	})
+	topic := NewGuardrail("topic", func(ctx context.Context, check GuardrailCheck) (GuardrailResult, error) {
+		if strings.Contains(check.Content, "stocks") {
+			return GuardrailResult{Action: GuardrailBlock, Reason: "off topic"}, nil
+		}
+		return GuardrailResult{Action: GuardrailAllow}, nil
+	})
+	agent := (&Agent{Name: "TestAgent", Model: "test-model"}).WithInputGuardrails(redact, topic)
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.MatchedBy(func(req llm.ChatCompletionRequest) bool {
+		return req.Messages[len(req.Messages)-1].Content == "my password is [redacted]"
+	})).Return(llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "Noted."}}},
+	}, nil).Once()
+
+	messages := []llm.Message{{Role: llm.RoleUser, Content: "my password is hunter2"}}
+	response, err := sw.Run(context.Background(), agent, messages, nil, "", false, false, 0, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "my password is hunter2", messages[0].Content)
+	assert.Equal(t, []GuardrailEvent{{
+		Guardrail: "redact", Stage: GuardrailStageInput, Agent: "TestAgent",
+		Action: GuardrailRewrite, Reason: "password", Original: "my password is hunter2",
+	}}, response.GuardrailEvents)
+
+	var events []RunEvent
+	ctx := WithRunHooks(context.Background(), RunHooksFunc(func(ctx context.Context, event RunEvent) {
+		if event.Type == EventGuardrail {
+			events = append(events, event)
+		}
+	}))
+	response, err = sw.Run(ctx, agent, []llm.Message{{Role: llm.RoleUser, Content: "buy stocks?"}}, nil, "", false, false, 0, true)
+
+	var tripped *GuardrailError
+	assert.ErrorIs(t, err, ErrGuardrailTripped)
+	assert.True(t, errors.As(err, &tripped))
+	assert.Equal(t, "topic", tripped.Event.Guardrail)
+	assert.Equal(t, StopGuardrail, response.StopReason)
+	assert.Equal(t, 0, response.Turns)
+	assert.Len(t, response.GuardrailEvents, 1)
+	assert.Len(t, events, 1)
+	mockClient.AssertExpectations(t)
+}
+
+// TestRunOutputGuardrails tests that output guardrails flag and block the final reply
+func TestRunOutputGuardrails(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+
+	length := NewGuardrail("length", func(ctx context.Context, check GuardrailCheck) (GuardrailResult, error) {
+		if len(check.Content) > 10 {
+			return GuardrailResult{Action: GuardrailFlag, Reason: "long reply"}, nil
+		}
+		return GuardrailResult{}, nil
+	})
+	secrets := NewGuardrail("secrets", func(ctx context.Context, check GuardrailCheck) (GuardrailResult, error) {
+		if strings.Contains(check.Content, "sk-") {
+			return GuardrailResult{Action: GuardrailBlock, Reason: "leaked key"}, nil
+		}
+		return GuardrailResult{}, nil
+	})
+	agent := (&Agent{Name: "TestAgent", Model: "test-model"}).WithOutputGuardrails(length, secrets)
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "Your key is sk-123"}}},
+	}, nil).Once()
+
+	response, err := sw.Run(context.Background(), agent, []llm.Message{{Role: llm.RoleUser, Content: "key?"}}, nil, "", false, false, 0, true)
+
+	assert.ErrorIs(t, err, ErrGuardrailTripped)
+	assert.Equal(t, StopGuardrail, response.StopReason)
+	assert.Empty(t, response.Messages)
+	assert.Equal(t, 1, response.Turns)
+	if assert.Len(t, response.GuardrailEvents, 2) {
+		assert.Equal(t, GuardrailFlag, response.GuardrailEvents[0].Action)
+		assert.Equal(t, 1, response.GuardrailEvents[0].Turn)
+		assert.Equal(t, GuardrailBlock, response.GuardrailEvents[1].Action)
+	}
+	mockClient.AssertExpectations(t)
+}
+
+// TestAgentGuardrail tests a guardrail backed by a classifier agent
+func TestAgentGuardrail(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+	classifier := &Agent{Name: "Classifier", Model: "cheap-model", Instructions: "Block anything about competitors"}
+	agent := (&Agent{Name: "TestAgent", Model: "test-model"}).
+		WithInputGuardrails(NewAgentGuardrail("competitors", sw, classifier))
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.MatchedBy(func(req llm.ChatCompletionRequest) bool {
+		return req.Model == "cheap-model"
+	})).Return(llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: `{"action": "block", "reason": "mentions a competitor"}`}}},
+	}, nil).Once()
+
+	_, err := sw.Run(context.Background(), agent, []llm.Message{{Role: llm.RoleUser, Content: "Is Acme better?"}}, nil, "", false, false, 0, true)
+
+	var tripped *GuardrailError
+	if assert.True(t, errors.As(err, &tripped)) {
+		assert.Equal(t, "mentions a competitor", tripped.Event.Reason)
+	}
+	mockClient.AssertExpectations(t)
+}
+
+// TestRunPendingApproval tests that Run pauses before sensitive tools and Resume applies the decisions
+func TestRunPendingApproval(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+
+	var refunds []interface{}
+	var lookups int
+	agent := &Agent{
+		Name:  "ShopperAgent",
+		Model: "test-model",
+		Functions: []AgentFunction{
+			{
+				Name:             "refund",
+				RequiresApproval: true,
+				Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+					refunds = append(refunds, args["amount"])
+					return Result{Success: true, Data: "refunded"}
+				},
+			},
+			{
+				Name: "lookup_order",
+				Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+					lookups++
+					return Result{Success: true, Data: "order 42"}
+				},
+			},
+		},
+	}
+
+	calls := toolCallResponse("call_refund", "refund", `{"amount": 100}`)
+	calls.Choices[0].Message.ToolCalls = append(calls.Choices[0].Message.ToolCalls, llm.ToolCall{
+		ID: "call_lookup", Type: "function", Function: llm.ToolCallFunction{Name: "lookup_order", Arguments: `{}`},
+	})
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(calls, nil).Once()
+
+	messages := []llm.Message{{Role: llm.RoleUser, Content: "Refund order 42"}}
+	response, err := sw.Run(context.Background(), agent, messages, nil, "", false, false, 5, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, StopPendingApproval, response.StopReason)
+	assert.Empty(t, response.Messages)
+	assert.Empty(t, refunds)
+	assert.Equal(t, 0, lookups)
+	pending := response.PendingApproval
+	if !assert.NotNil(t, pending) || !assert.Len(t, pending.ToolCalls, 1) {
+		return
+	}
+	assert.Equal(t, "call_refund", pending.ToolCalls[0].ToolCall.ID)
+	assert.Equal(t, map[string]interface{}{"amount": float64(100)}, pending.ToolCalls[0].Args)
+
+	_, err = sw.Resume(context.Background(), pending)
+	assert.ErrorIs(t, err, ErrMissingApproval)
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.MatchedBy(func(req llm.ChatCompletionRequest) bool {
+		n := len(req.Messages)
+		return n >= 3 && req.Messages[n-3].ToolCalls[0].Function.Arguments == `{"amount":50}` &&
+			req.Messages[n-2].ToolCallID == "call_refund" && req.Messages[n-1].ToolCallID == "call_lookup"
+	})).Return(llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "Refunded 50."}}},
+	}, nil).Once()
+
+	response, err = sw.Resume(context.Background(), pending, ApproveWithArgs("call_refund", map[string]interface{}{"amount": 50}))
+
+	assert.NoError(t, err)
+	assert.Equal(t, StopFinalMessage, response.StopReason)
+	assert.Equal(t, []interface{}{float64(50)}, refunds)
+	assert.Equal(t, 1, lookups)
+	assert.Equal(t, 2, response.Turns)
+	assert.Len(t, response.Messages, 4)
+	assert.Equal(t, `{"amount": 100}`, pending.Messages[len(pending.Messages)-1].ToolCalls[0].Function.Arguments)
+	mockClient.AssertExpectations(t)
+}
+
+// TestResumeRejected tests that a rejected call is answered with a tool result and never runs
+func TestResumeRejected(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+
+	agent := &Agent{
+		Name:  "ShopperAgent",
+		Model: "test-model",
+		Functions: []AgentFunction{
+			{
+				Name: "refund",
+				ApprovalPolicy: func(args map[string]interface{}, contextVariables map[string]interface{}) bool {
+					return args["amount"].(float64) > 20
+				},
+				Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+					t.Fatal("rejected refund was executed")
+					return Result{}
+				},
+			},
+		},
+	}
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).
+		Return(toolCallResponse("call_1", "refund", `{"amount": 100}`), nil).Once()
+	response, err := sw.Run(context.Background(), agent, []llm.Message{{Role: llm.RoleUser, Content: "Refund"}}, nil, "", false, false, 5, true)
+	assert.NoError(t, err)
+	assert.Equal(t, StopPendingApproval, response.StopReason)
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "The refund was not approved."}}},
+	}, nil).Once()
+	response, err = sw.Resume(context.Background(), response.PendingApproval, Reject("call_1", "over the limit"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, StopFinalMessage, response.StopReason)
+	if assert.Len(t, response.ToolResults, 1) {
+		assert.Equal(t, ToolErrorRejected, response.ToolResults[0].ErrorKind)
+	}
+	result := response.Messages[1]
+	assert.Equal(t, llm.RoleTool, result.Role)
+	assert.Equal(t, "call_1", result.ToolCallID)
+	assert.Contains(t, result.Content, `"reason":"over the limit"`)
+	mockClient.AssertExpectations(t)
+}
+
+// TestSessionStores tests that every session store saves, loads and deletes sessions
+func TestSessionStores(t *testing.T) {
+	ctx := context.Background()
+	fileStore, err := NewFileSessionStore(t.TempDir())
+	assert.NoError(t, err)
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "sessions.db"))
+	assert.NoError(t, err)
+	defer db.Close()
+	sqliteStore, err := NewSQLiteSessionStore(ctx, db)
+	assert.NoError(t, err)
+
+	stores := map[string]SessionStore{
+		"memory": NewMemorySessionStore(),
+		"file":   fileStore,
+		"sqlite": sqliteStore,
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			_, err := store.Load(ctx, "s1")
+			assert.ErrorIs(t, err, ErrSessionNotFound)
+
+			session := NewSession("s1")
+			session.AgentName = "SupportAgent"
+			session.ContextVariables["user"] = "alice"
+			session.Messages = []llm.Message{{Role: llm.RoleUser, Content: "Hi"}}
+			assert.NoError(t, store.Save(ctx, session))
+
+			session.Messages = append(session.Messages, llm.Message{
+				Role:      llm.RoleAssistant,
+				ToolCalls: []llm.ToolCall{{ID: "call_1", Type: "function", Function: llm.ToolCallFunction{Name: "lookup", Arguments: "{}"}}},
+			})
+			assert.NoError(t, store.Save(ctx, session))
+
+			loaded, err := store.Load(ctx, "s1")
+			assert.NoError(t, err)
+			assert.Equal(t, "SupportAgent", loaded.AgentName)
+			assert.Equal(t, "alice", loaded.ContextVariables["user"])
+			assert.Equal(t, session.Messages, loaded.Messages)
+			assert.Nil(t, loaded.History)
+
+			session.History = []llm.Message{{Role: llm.RoleUser, Content: "Hi"}}
+			session.Pending = &SessionApproval{
+				Message:   session.Messages[1],
+				ToolCalls: []PendingToolCall{{ToolCall: session.Messages[1].ToolCalls[0], Args: map[string]interface{}{"id": float64(7)}}},
+				Turns:     1,
+			}
+			assert.NoError(t, store.Save(ctx, session))
+			loaded, err = store.Load(ctx, "s1")
+			assert.NoError(t, err)
+			assert.Equal(t, session.History, loaded.History)
+			assert.Equal(t, session.Pending, loaded.Pending)
+			assert.Len(t, loaded.Messages, 2)
+
+			assert.NoError(t, store.Delete(ctx, "s1"))
+			_, err = store.Load(ctx, "s1")
+			assert.ErrorIs(t, err, ErrSessionNotFound)
+		})
+	}
+
+	_, err = fileStore.Load(ctx, "../s1")
+	assert.Error(t, err)
+}
+
+// TestRunSession tests that a session carries messages, the active agent and context variables between runs
+func TestRunSession(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+	store := NewMemorySessionStore()
+
+	billing := &Agent{Name: "BillingAgent", Model: "test-model"}
+	triage := &Agent{
+		Name:     "TriageAgent",
+		Model:    "test-model",
+		Handoffs: []Handoff{HandoffTo(billing)},
+		Functions: []AgentFunction{{
+			Name: "remember",
+			Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+				contextVariables["topic"] = "billing"
+				return Result{Success: true, Data: "ok"}
+			},
+		}},
+	}
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).
+		Return(toolCallResponse("call_1", "remember", `{}`), nil).Once()
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).
+		Return(toolCallResponse("call_2", "transfer_to_billing_agent", `{}`), nil).Once()
+	response, err := sw.RunSession(context.Background(), store, "s1", []*Agent{triage},
+		[]llm.Message{{Role: llm.RoleUser, Content: "My invoice is wrong"}}, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, StopHandoff, response.StopReason)
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.MatchedBy(func(req llm.ChatCompletionRequest) bool {
+		return req.Messages[0].Role == llm.RoleUser && req.Messages[0].Content == "My invoice is wrong"
+	})).Return(llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "Let me fix it."}}},
+	}, nil).Once()
+	response, err = sw.RunSession(context.Background(), store, "s1", []*Agent{triage},
+		[]llm.Message{{Role: llm.RoleUser, Content: "Can you fix it?"}}, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, billing, response.Agent)
+
+	session, err := store.Load(context.Background(), "s1")
+	assert.NoError(t, err)
+	assert.Equal(t, "BillingAgent", session.AgentName)
+	assert.Equal(t, "billing", session.ContextVariables["topic"])
+	assert.Len(t, session.Messages, 7)
+	assert.Equal(t, "Let me fix it.", session.Messages[6].Content)
+	mockClient.AssertExpectations(t)
+
+	_, err = sw.RunSession(context.Background(), store, "s1", []*Agent{{Name: "Other"}}, nil, 5)
+	assert.ErrorIs(t, err, ErrUnknownSessionAgent)
+}
+
+// TestRunSessionHandoffHistory tests that the next agent of a session continues with the
+// history its handoff filter kept, while the session keeps every message
+func TestRunSessionHandoffHistory(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+	store := NewMemorySessionStore()
+
+	billing := &Agent{Name: "BillingAgent", Model: "test-model"}
+	triage := &Agent{
+		Name:     "TriageAgent",
+		Model:    "test-model",
+		Handoffs: []Handoff{{Agent: billing, HistoryFilter: StripToolCalls}},
+	}
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).
+		Return(toolCallResponse("call_1", "transfer_to_billing_agent", `{}`), nil).Once()
+	response, err := sw.RunSession(context.Background(), store, "s1", []*Agent{triage},
+		[]llm.Message{{Role: llm.RoleUser, Content: "My invoice is wrong"}}, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, StopHandoff, response.StopReason)
+	assert.True(t, response.Handoff.Filtered)
+
+	var request llm.ChatCompletionRequest
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { request = args.Get(1).(llm.ChatCompletionRequest) }).
+		Return(llm.ChatCompletionResponse{
+			Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "Let me fix it."}}},
+		}, nil).Once()
+	_, err = sw.RunSession(context.Background(), store, "s1", []*Agent{triage},
+		[]llm.Message{{Role: llm.RoleUser, Content: "Can you fix it?"}}, 5)
+	assert.NoError(t, err)
+
+	var sent []llm.Message
+	for _, msg := range request.Messages {
+		if msg.Role != llm.RoleSystem {
+			sent = append(sent, msg)
+		}
+	}
+	assert.Equal(t, []llm.Message{
+		{Role: llm.RoleUser, Content: "My invoice is wrong"},
+		{Role: llm.RoleUser, Content: "Can you fix it?"},
+	}, sent)
+
+	session, err := store.Load(context.Background(), "s1")
+	assert.NoError(t, err)
+	assert.Len(t, session.Messages, 5)
+	assert.Len(t, session.History, 3)
+	assert.Equal(t, "Let me fix it.", session.History[2].Content)
+	mockClient.AssertExpectations(t)
+}
+
+// savedCheckpointStore calls saved with every checkpoint it saves
+type savedCheckpointStore struct {
+	CheckpointStore
+	saved func(checkpoint *Checkpoint)
+}
+
+func (s *savedCheckpointStore) Save(ctx context.Context, checkpoint *Checkpoint) error {
+	s.saved(checkpoint)
+	return s.CheckpointStore.Save(ctx, checkpoint)
+}
+
+// TestResumeRunParallelToolCalls tests that results of parallel calls finishing out of
+// order are checkpointed and resumed in call order
+func TestResumeRunParallelToolCalls(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+	twoSaved := make(chan struct{})
+	var once sync.Once
+	store := &savedCheckpointStore{CheckpointStore: NewMemoryCheckpointStore(), saved: func(checkpoint *Checkpoint) {
+		if len(checkpoint.PendingToolCalls) == 1 {
+			once.Do(func() { close(twoSaved) })
+		}
+	}}
+	sw.SetCheckpointStore(store)
+
+	ctx, crash := context.WithCancel(WithCheckpointID(context.Background(), "order-1"))
+	shipped := make(chan struct{})
+	var notifications atomic.Int32
+	agent := &Agent{
+		Name:              "OrderAgent",
+		Model:             "test-model",
+		ParallelToolCalls: true,
+		Functions: []AgentFunction{
+			{
+				Name: "reserve",
+				Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+					<-shipped
+					return Result{Success: true, Data: "reserved"}
+				},
+			},
+			{
+				Name: "ship",
+				Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+					close(shipped)
+					return Result{Success: true, Data: "shipped"}
+				},
+			},
+			{
+				Name: "notify",
+				Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+					if notifications.Add(1) == 1 {
+						<-twoSaved
+						crash()
+					}
+					return Result{Success: true, Data: "notified"}
+				},
+			},
+		},
+	}
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{
+			Role: llm.RoleAssistant,
+			ToolCalls: []llm.ToolCall{
+				{ID: "call_1", Type: "function", Function: llm.ToolCallFunction{Name: "reserve", Arguments: "{}"}},
+				{ID: "call_2", Type: "function", Function: llm.ToolCallFunction{Name: "ship", Arguments: "{}"}},
+				{ID: "call_3", Type: "function", Function: llm.ToolCallFunction{Name: "notify", Arguments: "{}"}},
+			},
+		}}},
+	}, nil).Once()
+	_, err := sw.Run(ctx, agent, []llm.Message{{Role: llm.RoleUser, Content: "Order"}}, nil, "", false, false, 5, true)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	checkpoint, err := store.Load(context.Background(), "order-1")
+	assert.NoError(t, err)
+	if assert.Len(t, checkpoint.Messages, 4) {
+		assert.Equal(t, "call_1", checkpoint.Messages[2].ToolCallID)
+		assert.Equal(t, "call_2", checkpoint.Messages[3].ToolCallID)
+	}
+	if assert.Len(t, checkpoint.PendingToolCalls, 1) {
+		assert.Equal(t, "call_3", checkpoint.PendingToolCalls[0].ID)
+	}
+
+	var request llm.ChatCompletionRequest
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { request = args.Get(1).(llm.ChatCompletionRequest) }).
+		Return(llm.ChatCompletionResponse{
+			Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "Your order is on its way."}}},
+		}, nil).Once()
+	response, err := sw.ResumeRun(context.Background(), "order-1", agent)
+	assert.NoError(t, err)
+	assert.Equal(t, StopFinalMessage, response.StopReason)
+	assert.Equal(t, int32(2), notifications.Load())
+
+	var ids []string
+	for _, msg := range request.Messages {
+		if msg.Role == llm.RoleTool {
+			ids = append(ids, msg.ToolCallID)
+		}
+	}
+	assert.Equal(t, []string{"call_1", "call_2", "call_3"}, ids)
+	if assert.Len(t, response.Messages, 5) {
+		assert.Equal(t, "notified", response.Messages[3].Content)
+	}
+	mockClient.AssertExpectations(t)
+}
+
+// TestRunSessionApproval tests that a session paused for approval keeps the calls waiting
+// and continues with ResumeSession
+func TestRunSessionApproval(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+	store, err := NewFileSessionStore(t.TempDir())
+	assert.NoError(t, err)
+
+	var refunds int
+	agent := &Agent{
+		Name:  "BillingAgent",
+		Model: "test-model",
+		Functions: []AgentFunction{{
+			Name:             "refund",
+			RequiresApproval: true,
+			Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+				refunds++
+				return Result{Success: true, Data: "refunded"}
+			},
+		}},
+	}
+	agents := []*Agent{agent}
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).
+		Return(toolCallResponse("call_1", "refund", `{"amount": 50}`), nil).Once()
+	response, err := sw.RunSession(context.Background(), store, "s1", agents,
+		[]llm.Message{{Role: llm.RoleUser, Content: "Refund my order"}}, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, StopPendingApproval, response.StopReason)
+
+	session, err := store.Load(context.Background(), "s1")
+	assert.NoError(t, err)
+	assert.Len(t, session.Messages, 1)
+	if !assert.NotNil(t, session.Pending) {
+		return
+	}
+	assert.Equal(t, "call_1", session.Pending.Message.ToolCalls[0].ID)
+	assert.Equal(t, float64(50), session.Pending.ToolCalls[0].Args["amount"])
+	assert.Equal(t, 1, session.Pending.Turns)
+
+	_, err = sw.RunSession(context.Background(), store, "s1", agents,
+		[]llm.Message{{Role: llm.RoleUser, Content: "Hello?"}}, 5)
+	assert.ErrorIs(t, err, ErrSessionPendingApproval)
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.MatchedBy(func(req llm.ChatCompletionRequest) bool {
+		return lastMessage(req.Messages).Role == llm.RoleTool
+	})).Return(llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "Your refund is on its way."}}},
+	}, nil).Once()
+	response, err = sw.ResumeSession(context.Background(), store, "s1", agents, 5, Approve("call_1"))
+	assert.NoError(t, err)
+	assert.Equal(t, StopFinalMessage, response.StopReason)
+	assert.Equal(t, 1, refunds)
+	assert.Equal(t, 2, response.Turns)
+
+	session, err = store.Load(context.Background(), "s1")
+	assert.NoError(t, err)
+	assert.Nil(t, session.Pending)
+	if !assert.Len(t, session.Messages, 4) {
+		return
+	}
+	assert.Equal(t, "call_1", session.Messages[1].ToolCalls[0].ID)
+	assert.Equal(t, "refunded", session.Messages[2].Content)
+	assert.Equal(t, "Your refund is on its way.", session.Messages[3].Content)
+	mockClient.AssertExpectations(t)
+
+	_, err = sw.ResumeSession(context.Background(), store, "s1", agents, 5, Approve("call_1"))
+	assert.ErrorIs(t, err, ErrNoPendingApproval)
+}
+
+// TestResumeRunFromCheckpoint tests that a run resumed from its checkpoint doesn't repeat completed tool calls
+func TestResumeRunFromCheckpoint(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+	store, err := NewFileCheckpointStore(t.TempDir())
+	assert.NoError(t, err)
+	sw.SetCheckpointStore(store)
+
+	ctx, crash := context.WithCancel(WithCheckpointID(context.Background(), "order-1"))
+	var charges, shipments int
+	agent := &Agent{
+		Name:  "OrderAgent",
+		Model: "test-model",
+		Functions: []AgentFunction{
+			{
+				Name: "charge",
+				Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+					charges++
+					contextVariables["charged"] = true
+					return Result{Success: true, Data: "charged"}
+				},
+			},
+			{
+				Name: "ship",
+				Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+					shipments++
+					if shipments == 1 {
+						crash()
+					}
+					return Result{Success: true, Data: "shipped"}
+				},
+			},
+		},
+	}
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{
+			Role: llm.RoleAssistant,
+			ToolCalls: []llm.ToolCall{
+				{ID: "call_1", Type: "function", Function: llm.ToolCallFunction{Name: "charge", Arguments: "{}"}},
+				{ID: "call_2", Type: "function", Function: llm.ToolCallFunction{Name: "ship", Arguments: "{}"}},
+			},
+		}}},
+	}, nil).Once()
+	_, err = sw.Run(ctx, agent, []llm.Message{{Role: llm.RoleUser, Content: "Order"}}, nil, "", false, false, 5, true)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	checkpoint, err := store.Load(context.Background(), "order-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "OrderAgent", checkpoint.AgentName)
+	assert.Equal(t, 1, checkpoint.Turns)
+	assert.Len(t, checkpoint.Messages, 3)
+	if assert.Len(t, checkpoint.PendingToolCalls, 1) {
+		assert.Equal(t, "call_2", checkpoint.PendingToolCalls[0].ID)
+	}
+	assert.Equal(t, true, checkpoint.ContextVariables["charged"])
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.MatchedBy(func(req llm.ChatCompletionRequest) bool {
+		return len(req.Messages) == 4
+	})).Return(llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "Your order is on its way."}}},
+	}, nil).Once()
+	response, err := sw.ResumeRun(context.Background(), "order-1", agent)
+
+	assert.NoError(t, err)
+	assert.Equal(t, StopFinalMessage, response.StopReason)
+	assert.Equal(t, 1, charges)
+	assert.Equal(t, 2, shipments)
+	assert.Equal(t, 2, response.Turns)
+	assert.Len(t, response.Messages, 4)
+	assert.Equal(t, true, response.ContextVariables["charged"])
+	mockClient.AssertExpectations(t)
+
+	_, err = store.Load(context.Background(), "order-1")
+	assert.ErrorIs(t, err, ErrCheckpointNotFound)
+}
+
+// recordingStreamHandler records what a streaming response reports
+type recordingStreamHandler struct {
+	DefaultStreamHandler
+	tokens    []string
+	toolCalls []llm.ToolCall
+	complete  *llm.Message
+	err       error
+}
+
+func (h *recordingStreamHandler) OnToken(token string) {
+	h.tokens = append(h.tokens, token)
+}
+
+func (h *recordingStreamHandler) OnToolCall(toolCall llm.ToolCall) {
+	h.toolCalls = append(h.toolCalls, toolCall)
+}
+
+func (h *recordingStreamHandler) OnComplete(message llm.Message) {
+	h.complete = &message
+}
+
+func (h *recordingStreamHandler) OnError(err error) {
+	h.err = err
+}
+
+// TestStreamingResponseWithMock tests streaming a tool call and the answer after it
+func TestStreamingResponseWithMock(t *testing.T) {
+	m := llmmock.New()
+	m.Enqueue(
+		llmmock.ToolCalls(llmmock.Call("get_weather", `{"city": "Paris"}`)),
+		llmmock.Text("It's sunny in Paris.").WithChunks("It's ", "sunny ", "in Paris."),
+	)
+	var calls int
+	agent := &Agent{
+		Name:  "WeatherAgent",
+		Model: "test-model",
+		Functions: []AgentFunction{{
+			Name: "get_weather",
+			Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+				calls++
+				return Result{Success: true, Data: "Sunny"}
+			},
+		}},
+	}
+	sw := NewSwarmWithCustomProvider(m, DefaultConfig())
+
+	handler := &recordingStreamHandler{}
+	err := sw.StreamingResponse(context.Background(), agent, []llm.Message{{Role: llm.RoleUser, Content: "Weather in Paris?"}}, nil, "", handler, false)
+
+	assert.NoError(t, err)
+	assert.NoError(t, handler.err)
+	assert.Equal(t, 1, calls)
+	if assert.Len(t, handler.toolCalls, 1) {
+		assert.Equal(t, "get_weather", handler.toolCalls[0].Function.Name)
+	}
+	assert.Equal(t, []string{"It's ", "sunny ", "in Paris."}, handler.tokens)
+	if assert.NotNil(t, handler.complete) {
+		assert.Contains(t, handler.complete.Content, "It's sunny in Paris.")
+	}
+	requests := m.Requests()
+	if assert.Len(t, requests, 2) {
+		assert.Equal(t, llm.RoleTool, requests[1].Messages[len(requests[1].Messages)-1].Role)
+	}
+
+	m.Enqueue(llmmock.Text("Partial answer").WithStreamError(errors.New("connection reset")))
+	handler = &recordingStreamHandler{}
+	err = sw.StreamingResponse(context.Background(), agent, []llm.Message{{Role: llm.RoleUser, Content: "Hi"}}, nil, "", handler, false)
+	assert.EqualError(t, err, "connection reset")
+	assert.Equal(t, []string{"Partial ", "answer"}, handler.tokens)
+
+	// Models resolve as in Run, falling back to the default model and applying the agent's mapping
+	m.Enqueue(llmmock.Text("Hi"))
+	mapped := &Agent{Name: "Mapped", Config: &ClientConfig{ModelMapperFunc: func(model string) string { return "deployment-" + model }}}
+	err = sw.StreamingResponse(context.Background(), mapped, []llm.Message{{Role: llm.RoleUser, Content: "Hi"}}, nil, "", &recordingStreamHandler{}, false)
+	assert.NoError(t, err)
+	last, _ := m.LastRequest()
+	assert.Equal(t, "deployment-"+DefaultConfig().DefaultModel, last.Model)
+}
+
+// decodeLogRecords decodes the records a JSON slog handler wrote, one per line
+func decodeLogRecords(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]interface{}
+		if assert.NoError(t, json.Unmarshal([]byte(line), &record)) {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// TestLoggingHonorsLogLevel tests that runs log structured records at the configured level
+func TestLoggingHonorsLogLevel(t *testing.T) {
+	newRun := func(level LogLevel) (*Swarm, *Agent, *bytes.Buffer) {
+		m := llmmock.New()
+		m.Enqueue(
+			llmmock.Error(errors.New("connection reset")),
+			llmmock.ToolCalls(llmmock.Call("lookup", `{"id": 1}`)),
+			llmmock.Text("Done."),
+		)
+		config := DefaultConfig()
+		config.LogLevel = level
+		config.RetryBackoff = time.Millisecond
+		sw := NewSwarmWithCustomProvider(m, config)
+
+		var buf bytes.Buffer
+		sw.SetLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: LevelTrace})))
+		agent := &Agent{
+			Name:  "LookupAgent",
+			Model: "test-model",
+			Functions: []AgentFunction{{
+				Name: "lookup",
+				Function: func(args map[string]interface{}, contextVariables map[string]interface{}) Result {
+					return Result{Success: true, Data: "found"}
+				},
+			}},
+		}
+		return sw, agent, &buf
+	}
+	messages := []llm.Message{{Role: llm.RoleUser, Content: "Look it up"}}
+
+	sw, agent, buf := newRun(LogDebug)
+	_, err := sw.Run(context.Background(), agent, messages, nil, "", false, false, 5, true)
+	assert.NoError(t, err)
+
+	byMessage := make(map[string]map[string]interface{})
+	for _, record := range decodeLogRecords(t, buf) {
+		byMessage[record["msg"].(string)] = record
+	}
+	if retry, ok := byMessage["retrying failed request"]; assert.True(t, ok) {
+		assert.Equal(t, "WARN", retry["level"])
+		assert.Equal(t, "test-model", retry["model"])
+		assert.Equal(t, float64(2), retry["attempt"])
+		assert.Equal(t, "LookupAgent", retry["agent"])
+		assert.NotEmpty(t, retry["run_id"])
+	}
+	if tool, ok := byMessage["tool call succeeded"]; assert.True(t, ok) {
+		assert.Equal(t, "DEBUG", tool["level"])
+		assert.Equal(t, "lookup", tool["tool"])
+		assert.Equal(t, float64(1), tool["turn"])
+	}
+
+	// Debug records are dropped at the warning level, unless the run is debugged
+	sw, agent, buf = newRun(LogWarning)
+	_, err = sw.Run(context.Background(), agent, messages, nil, "", false, false, 5, true)
+	assert.NoError(t, err)
+	records := decodeLogRecords(t, buf)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "retrying failed request", records[0]["msg"])
+	}
+
+	sw, agent, buf = newRun(LogWarning)
+	_, err = sw.Run(context.Background(), agent, messages, nil, "", false, true, 5, true)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), `"msg":"tool call succeeded"`)
+
+	sw, agent, buf = newRun(LogSilent)
+	_, err = sw.Run(context.Background(), agent, messages, nil, "", false, false, 5, true)
+	assert.NoError(t, err)
+	assert.Empty(t, buf.String())
+}
+
+// TestConfigLogger tests that Config.Logger applies from construction, including to the
+// clients the Swarm builds, and that every logger honours Config.LogLevel
+func TestConfigLogger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": {"message": "bad model", "type": "invalid_request_error"}}`))
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	config := DefaultConfig()
+	config.LogLevel = LogWarning
+	config.Logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: LevelTrace}))
+
+	sw := NewSwarmWithConfig("", llm.OpenAI, config)
+	assert.Contains(t, buf.String(), `"msg":"empty API key provided"`)
+
+	agent := &Agent{Name: "LocalAgent", Model: "test-model", Config: &ClientConfig{
+		Provider: llm.OpenAI, AuthToken: "key", BaseURL: server.URL,
+	}}
+	_, err := sw.Run(context.Background(), agent, []llm.Message{{Role: llm.RoleUser, Content: "Hi"}}, nil, "", false, false, 5, true)
+	assert.Error(t, err)
+	assert.Contains(t, buf.String(), `"msg":"chat completion failed","provider":"OPEN_AI","model":"test-model"`)
+
+	// Records below the level are dropped, also by the default logger on stderr
+	config.LogLevel = LogError
+	buf.Reset()
+	_, err = sw.Run(context.Background(), agent, []llm.Message{{Role: llm.RoleUser, Content: "Hi"}}, nil, "", false, false, 5, true)
+	assert.Error(t, err)
+	assert.NotContains(t, buf.String(), "chat completion failed")
+	assert.False(t, newLogger(config, nil).Enabled(context.Background(), slog.LevelWarn))
+	assert.True(t, newLogger(config, nil).Enabled(context.Background(), slog.LevelError))
+}
+
+// TestRunRetriesTransientErrors tests that Run goes through the retry machinery
+func TestRunRetriesTransientErrors(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+	ctx := context.Background()
+
+	agent := &Agent{Name: "TestAgent", Model: "test-model"}
+
+	final := llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "Hello!"}}},
+	}
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(llm.ChatCompletionResponse{}, errors.New("connection reset")).Once()
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(final, nil).Once()
+
+	response, err := sw.Run(ctx, agent, []llm.Message{{Role: llm.RoleUser, Content: "Hi"}}, nil, "", false, false, 5, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello!", response.Messages[0].Content)
+	mockClient.AssertNumberOfCalls(t, "CreateChatCompletion", 2)
+}
+
+// TestFailureHandlerAbortsRetries tests that a failure handler can stop further retries
+func TestFailureHandlerAbortsRetries(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+	ctx := context.Background()
+
+	errQuota := errors.New("quota exhausted")
+	sw.config.FailureHandlers = []FailureHandler{
+		func(err error) (bool, error) {
+			return true, errQuota
+		},
+	}
+
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(llm.ChatCompletionResponse{}, errors.New("API error"))
+
+	_, err := sw.Run(ctx, &Agent{Name: "TestAgent"}, []llm.Message{{Role: llm.RoleUser, Content: "Hi"}}, nil, "", false, false, 5, true)
+
+	assert.ErrorIs(t, err, errQuota)
+	mockClient.AssertNumberOfCalls(t, "CreateChatCompletion", 1)
+}
+
+// TestRunDoesNotRetryFatalAPIErrors tests that non-retryable provider errors fail immediately
+func TestRunDoesNotRetryFatalAPIErrors(t *testing.T) {
+	mockClient := new(MockLLM)
+	sw := NewMockSwarm(mockClient)
+	ctx := context.Background()
+
+	// A tool named "not found" must not make an otherwise retryable error fatal
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(llm.ChatCompletionResponse{}, errors.New("tool not found")).Once()
+	mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(llm.ChatCompletionResponse{},
+		llm.NewAPIError(llm.OpenAI, 401, "invalid_api_key", "Incorrect API key provided", nil)).Once()
+
+	_, err := sw.Run(ctx, &Agent{Name: "TestAgent"}, []llm.Message{{Role: llm.RoleUser, Content: "Hi"}}, nil, "", false, false, 5, true)
+
+	var apiErr *llm.APIError
+	assert.ErrorAs(t, err, &apiErr)
+	assert.True(t, apiErr.IsAuth())
+	mockClient.AssertNumberOfCalls(t, "CreateChatCompletion", 2)
+}
+
+// TestRunRateLimitStrategy tests rate limit handling, including Retry-After
+func TestRunRateLimitStrategy(t *testing.T) {
+	rateLimited := llm.NewAPIError(llm.Claude, 429, "rate_limit_error", "slow down", nil)
+	rateLimited.RetryAfter = 20 * time.Millisecond
+
+	final := llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: llm.RoleAssistant, Content: "Hello!"}}},
+	}
+	messages := []llm.Message{{Role: llm.RoleUser, Content: "Hi"}}
+
+	t.Run("fail", func(t *testing.T) {
+		mockClient := new(MockLLM)
+		sw := NewMockSwarm(mockClient)
+		sw.config.RateLimitStrategy = RateLimitFail
+		mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(llm.ChatCompletionResponse{}, rateLimited)
+
+		_, err := sw.Run(context.Background(), &Agent{Name: "TestAgent"}, messages, nil, "", false, false, 5, true)
+
+		assert.ErrorIs(t, err, rateLimited)
+		mockClient.AssertNumberOfCalls(t, "CreateChatCompletion", 1)
+	})
+
+	t.Run("retry honors Retry-After", func(t *testing.T) {
+		mockClient := new(MockLLM)
+		sw := NewMockSwarm(mockClient)
+		mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(llm.ChatCompletionResponse{}, rateLimited).Once()
+		mockClient.On("CreateChatCompletion", mock.Anything, mock.Anything).Return(final, nil).Once()
+
+		start := time.Now()
+		_, err := sw.Run(context.Background(), &Agent{Name: "TestAgent"}, messages, nil, "", false, false, 5, true)
+
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), rateLimited.RetryAfter)
+		mockClient.AssertNumberOfCalls(t, "CreateChatCompletion", 2)
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"strings"
 	"sync"
 
@@ -78,6 +79,9 @@ func (s GraphState) GetBool(key StateKey) (bool, bool) {
 // MessageKey is the default key for storing messages in state
 const MessageKey StateKey = "messages"
 
+// UsageKey is the key under which agent nodes accumulate the RunUsage of the graph execution
+const UsageKey StateKey = "usage"
+
 // NodeFunc is a function that processes state and returns updates
 type NodeFunc func(ctx context.Context, state GraphState) (GraphState, error)
 
@@ -114,6 +118,9 @@ type Graph struct {
 	ExitPoints  []NodeID // Optional exit points
 	mutex       sync.RWMutex
 	eventHooks  map[string][]func(state GraphState)
+	swarm       *Swarm           // Swarm running the agent nodes, see SetSwarm
+	middleware  []llm.Middleware // Middleware around the LLM calls of agent nodes, inside the Swarm's own
+	logger      *slog.Logger     // Logger for agent nodes without a Swarm, see SetLogger
 }
 
 // NewGraph creates a new workflow graph
@@ -128,6 +135,78 @@ func NewGraph(name string, description string) *Graph {
 	}
 }
 
+// SetSwarm sets the Swarm that runs the graph's agent nodes, so its configuration and
+// middleware apply to them. The graph's own middleware is applied per call, so the Swarm
+// can be shared. Without one, each agent node run creates a Swarm from the "api_key"
+// and "provider" state values.
+func (g *Graph) SetSwarm(swarm *Swarm) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.logger != nil {
+		swarm.SetLogger(g.logger)
+	}
+	g.swarm = swarm
+}
+
+// SetLogger sets the logger the graph and its agent nodes write to. Records below the
+// LogLevel of the graph's Swarm, or of DefaultConfig without one, are dropped.
+func (g *Graph) SetLogger(logger *slog.Logger) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.logger = logger
+	if g.swarm != nil {
+		g.swarm.SetLogger(logger)
+	}
+}
+
+// log writes a record through the graph's Swarm, or its own logger without one
+func (g *Graph) log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	g.mutex.RLock()
+	swarm, logger := g.swarm, g.logger
+	g.mutex.RUnlock()
+
+	if swarm != nil {
+		swarm.log(ctx, false, level, msg, append([]any{"graph", g.Name}, args...)...)
+		return
+	}
+	logTo(ctx, newLogger(nil, logger), level, msg, append([]any{"graph", g.Name}, args...)...)
+}
+
+// Use adds middleware around every LLM call made by the graph's agent nodes
+func (g *Graph) Use(middleware ...llm.Middleware) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.middleware = append(g.middleware, middleware...)
+}
+
+// swarmFor returns the Swarm that runs an agent node, and a context whose LLM calls go
+// through the graph's middleware
+func (g *Graph) swarmFor(ctx context.Context, state GraphState) (*Swarm, context.Context) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	ctx = withCallMiddleware(ctx, g.middleware...)
+	if g.swarm != nil {
+		return g.swarm, ctx
+	}
+
+	apiKey, _ := state.GetString("api_key")
+	providerStr, _ := state.GetString("provider")
+	provider := llm.LLMProvider(providerStr)
+	if provider == "" {
+		provider = llm.OpenAI
+	}
+
+	swarm := NewSwarm(apiKey, provider)
+	if g.logger != nil {
+		swarm.SetLogger(g.logger)
+	}
+	return swarm, ctx
+}
+
 // AddNode adds a node to the graph
 func (g *Graph) AddNode(id NodeID, name string, process NodeFunc) *Node {
 	g.mutex.Lock()
@@ -169,15 +248,7 @@ func (g *Graph) AddAgentNode(id NodeID, name string, agent *Agent) *Node {
 			return state, fmt.Errorf("error unmarshaling messages: %w", err)
 		}
 
-		// Create swarm client if needed
-		apiKey, _ := state.GetString("api_key")
-		providerStr, _ := state.GetString("provider")
-		provider := llm.LLMProvider(providerStr)
-		if provider == "" {
-			provider = llm.OpenAI
-		}
-
-		client := NewSwarm(apiKey, provider)
+		client, ctx := g.swarmFor(ctx, state)
 
 		// Extract context variables
 		contextVars := make(map[string]interface{})
@@ -189,7 +260,7 @@ func (g *Graph) AddAgentNode(id NodeID, name string, agent *Agent) *Node {
 		}
 
 		// Run the agent
-		response, err := client.Run(ctx, agent, messages, contextVars, "", false, false, 1, true)
+		response, err := client.Run(ctx, agent, messages, contextVars, "", false, false, 0, true)
 		if err != nil {
 			return state, fmt.Errorf("error running agent: %w", err)
 		}
@@ -215,6 +286,14 @@ func (g *Graph) AddAgentNode(id NodeID, name string, agent *Agent) *Node {
 			newState[StateKey("var_"+k)] = v
 		}
 
+		// Roll the agent's usage up into the graph's usage
+		var usage RunUsage
+		if previous, ok := state[UsageKey].(RunUsage); ok {
+			usage.Merge(previous)
+		}
+		usage.Merge(response.Usage)
+		newState[UsageKey] = usage
+
 		return newState, nil
 	}
 
@@ -311,10 +390,12 @@ func (g *Graph) AddEventHook(event string, hook func(state GraphState)) {
 	g.eventHooks[event] = append(g.eventHooks[event], hook)
 }
 
-// fireEvent triggers event hooks
-func (g *Graph) fireEvent(event string, state GraphState) {
+// fireEvent triggers event hooks and reports the event to the run hooks of the
+// graph's Swarm and of ctx
+func (g *Graph) fireEvent(ctx context.Context, event string, state GraphState) {
 	g.mutex.RLock()
 	hooks, exists := g.eventHooks[event]
+	swarm := g.swarm
 	g.mutex.RUnlock()
 
 	if exists {
@@ -322,6 +403,7 @@ func (g *Graph) fireEvent(event string, state GraphState) {
 			hook(state)
 		}
 	}
+	swarm.emit(ctx, RunEvent{Type: EventGraph, Name: event, State: state})
 }
 
 // ExecuteGraph runs the workflow graph from the entry point
@@ -334,8 +416,12 @@ func (g *Graph) ExecuteGraph(ctx context.Context, initialState GraphState) (Grap
 	currentState := initialState
 	visited := make(map[NodeID]int) // Track visited nodes to detect cycles
 
+	// The agent nodes' runs are nested under the graph execution's run ID
+	ctx = startRunInfo(ctx, "")
+	step := 0
+
 	// Start execution event
-	g.fireEvent("graph_start", currentState)
+	g.fireEvent(ctx, "graph_start", currentState)
 
 	for {
 		// Check for cancellation
@@ -361,20 +447,29 @@ func (g *Graph) ExecuteGraph(ctx context.Context, initialState GraphState) (Grap
 			return currentState, fmt.Errorf("node %s not found", currentNodeID)
 		}
 
+		step++
+		agentName := ""
+		if node.Agent != nil {
+			agentName = node.Agent.Name
+		}
+		ctx := withTurn(ctx, step, agentName)
+
 		// Fire node entry event
-		g.fireEvent(fmt.Sprintf("node_enter_%s", currentNodeID), currentState)
+		g.fireEvent(ctx, fmt.Sprintf("node_enter_%s", currentNodeID), currentState)
 
 		// Execute node process
+		g.log(ctx, slog.LevelDebug, "executing node", "node", currentNodeID)
 		newState, err := node.Process(ctx, currentState)
 		if err != nil {
-			g.fireEvent("node_error", currentState)
+			g.log(ctx, slog.LevelError, "error processing node", "node", currentNodeID, "error", err)
+			g.fireEvent(ctx, "node_error", currentState)
 			return currentState, fmt.Errorf("error processing node %s: %w", currentNodeID, err)
 		}
 
 		currentState = newState
 
 		// Fire node exit event
-		g.fireEvent(fmt.Sprintf("node_exit_%s", currentNodeID), currentState)
+		g.fireEvent(ctx, fmt.Sprintf("node_exit_%s", currentNodeID), currentState)
 
 		// Check if we've reached an exit point
 		isExitPoint := false
@@ -386,7 +481,8 @@ func (g *Graph) ExecuteGraph(ctx context.Context, initialState GraphState) (Grap
 		}
 
 		if isExitPoint {
-			g.fireEvent("graph_complete", currentState)
+			g.log(ctx, slog.LevelInfo, "graph complete", "node", currentNodeID, "steps", step)
+			g.fireEvent(ctx, "graph_complete", currentState)
 			return currentState, nil
 		}
 
@@ -541,8 +637,22 @@ func CreateParallelNode(g *Graph, id NodeID, parallelProcesses []NodeFunc) *Node
 
 		// Merge results
 		mergedState := state.Clone()
+		baseUsage, _ := state[UsageKey].(RunUsage)
 		for _, result := range results {
 			for k, v := range result {
+				// Special handling for usage - add the turns each branch made
+				if k == UsageKey {
+					branchUsage, ok := v.(RunUsage)
+					mergedUsage, _ := mergedState[UsageKey].(RunUsage)
+					if ok && len(branchUsage.Turns) >= len(baseUsage.Turns) {
+						var usage RunUsage
+						usage.Merge(mergedUsage)
+						usage.Merge(RunUsage{Turns: branchUsage.Turns[len(baseUsage.Turns):]})
+						mergedState[UsageKey] = usage
+					}
+					continue
+				}
+
 				// Special handling for messages - combine them
 				if k == MessageKey {
 					// Combine message arrays
@@ -648,6 +758,12 @@ func (b *GraphBuilder) WithConditionalEdge(from NodeID, to NodeID, condition Con
 	return b
 }
 
+// WithSwarm sets the Swarm that runs the graph's agent nodes
+func (b *GraphBuilder) WithSwarm(swarm *Swarm) *GraphBuilder {
+	b.graph.SetSwarm(swarm)
+	return b
+}
+
 // WithEntryPoint sets the entry point for the graph
 func (b *GraphBuilder) WithEntryPoint(nodeID NodeID) *GraphBuilder {
 	b.graph.SetEntryPoint(nodeID)
@@ -0,0 +1,361 @@
+package swarmgo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/invopop/jsonschema"
+	"github.com/prathyushnallamothu/swarmgo/llm"
+)
+
+var (
+	// ErrInvalidToolArgs is reported when the arguments sent by the model don't match a tool's schema
+	ErrInvalidToolArgs = errors.New("invalid tool arguments")
+	// ErrToolNotFound is reported when the model calls a tool the agent doesn't have
+	ErrToolNotFound = errors.New("tool not found")
+)
+
+// ToolFunc is a context-aware agent function. Its context is cancelled when the
+// run is cancelled or the function's Timeout expires. The run stops waiting for it
+// then, so a function that ignores its context is abandoned and keeps running in
+// the background until it returns.
+type ToolFunc func(ctx context.Context, args map[string]interface{}, contextVariables map[string]interface{}) Result
+
+// AdaptFunction wraps a function with the original, context-free signature as a ToolFunc.
+// The wrapped function can't observe cancellation, so it is abandoned, still running, when the
+// run stops waiting for it. Tools that may time out should use a ToolFunc that respects ctx.
+func AdaptFunction(fn func(args map[string]interface{}, contextVariables map[string]interface{}) Result) ToolFunc {
+	return func(ctx context.Context, args map[string]interface{}, contextVariables map[string]interface{}) Result {
+		return fn(args, contextVariables)
+	}
+}
+
+// ToolTimeoutError is reported when a tool call doesn't finish within the function's Timeout
+type ToolTimeoutError struct {
+	Tool    string        // Name of the tool that timed out
+	Timeout time.Duration // Timeout that was exceeded
+}
+
+func (e *ToolTimeoutError) Error() string {
+	return fmt.Sprintf("tool %s timed out after %s", e.Tool, e.Timeout)
+}
+
+func (e *ToolTimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// RunContext gives a tool access to the state of the run that called it
+type RunContext struct {
+	ContextVariables map[string]interface{} // Context variables shared by the run
+}
+
+// NewTool creates an AgentFunction from a typed Go function.
+// The parameter schema is reflected from Args, whose json and jsonschema struct tags
+// control property names, descriptions and which fields are required. The arguments
+// sent by the model are validated and decoded into Args, and the returned Out is
+// marshaled to JSON for the model. NewTool panics if Args is not a struct type.
+func NewTool[Args any, Out any](
+	name, description string,
+	fn func(ctx context.Context, args Args, rc *RunContext) (Out, error),
+) AgentFunction {
+	parameters, err := reflectParameters(reflect.TypeOf((*Args)(nil)).Elem())
+	if err != nil {
+		panic(fmt.Sprintf("swarmgo: tool %s: %v", name, err))
+	}
+
+	return AgentFunction{
+		Name:        name,
+		Description: description,
+		Parameters:  parameters,
+		ContextFunction: func(ctx context.Context, raw map[string]interface{}, contextVariables map[string]interface{}) Result {
+			var args Args
+			if err := decodeToolArgs(raw, parameters, &args); err != nil {
+				return Result{Error: fmt.Errorf("%w for %s: %v", ErrInvalidToolArgs, name, err)}
+			}
+
+			out, err := fn(ctx, args, &RunContext{ContextVariables: contextVariables})
+			if err != nil {
+				return Result{Error: err}
+			}
+			return Result{Success: true, Data: out}
+		},
+	}
+}
+
+// ToolInvocation describes a tool call as it passes through tool middleware
+type ToolInvocation struct {
+	Agent            *Agent                 // Agent that owns the tool
+	Function         *AgentFunction         // Function being called
+	CallID           string                 // ID of the model's tool call
+	Args             map[string]interface{} // Arguments decoded from the model's call
+	ContextVariables map[string]interface{} // Context variables of the run
+}
+
+// ToolHandler executes a tool call and returns its result
+type ToolHandler func(ctx context.Context, call ToolInvocation) Result
+
+// ToolMiddleware wraps tool execution. It can rewrite the call's arguments before
+// passing it to next, return its own Result without calling next, or transform
+// the Result before it is sent back to the model.
+type ToolMiddleware func(next ToolHandler) ToolHandler
+
+// UseTool adds middleware around every tool call made by the Swarm, in Run and
+// StreamingResponse alike. Middleware added first is the outermost. The function's
+// Timeout and MaxRetries apply to the function itself, inside the middleware.
+func (s *Swarm) UseTool(middleware ...ToolMiddleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.toolMiddleware = append(s.toolMiddleware, middleware...)
+}
+
+// invokeTool executes a tool call through the tool middleware, returning the arguments the
+// function received, which middleware may have rewritten, or those of call when middleware
+// answered without calling it. The returned error is only set when ctx is done, in which
+// case the run should stop.
+func (s *Swarm) invokeTool(ctx context.Context, call ToolInvocation) (Result, map[string]interface{}, error) {
+	args := call.Args
+	var argsMu sync.Mutex
+	var handler ToolHandler = func(ctx context.Context, call ToolInvocation) Result {
+		argsMu.Lock()
+		args = call.Args
+		argsMu.Unlock()
+		onRetry := func(attempt int, err error) {
+			s.emit(ctx, RunEvent{
+				Type:     EventRetry,
+				ToolCall: &llm.ToolCall{ID: call.CallID, Type: "function", Function: llm.ToolCallFunction{Name: call.Function.Name}},
+				Attempt:  attempt,
+				Err:      err,
+			})
+		}
+		result, err := executeFunction(ctx, call.Function, call.Args, call.ContextVariables, onRetry)
+		if err != nil && result.Error == nil {
+			result.Error = err
+		}
+		return result
+	}
+
+	s.mu.Lock()
+	middleware := s.toolMiddleware
+	s.mu.Unlock()
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	result := handler(ctx, call)
+	argsMu.Lock()
+	defer argsMu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return result, args, err
+	}
+	return result, args, nil
+}
+
+// toolFunc returns the function to execute, adapting the original signature when needed
+func (af *AgentFunction) toolFunc() ToolFunc {
+	if af.ContextFunction != nil {
+		return af.ContextFunction
+	}
+	if af.Function != nil {
+		return AdaptFunction(af.Function)
+	}
+	return nil
+}
+
+// executeFunction runs an agent function, applying its Timeout to each attempt and
+// retrying failed attempts up to MaxRetries times, calling onRetry, if set, before each
+// retry. Invalid arguments are never retried, since the same call would fail again.
+// The returned error is only set when ctx is done, in which case the run should stop.
+func executeFunction(
+	ctx context.Context,
+	af *AgentFunction,
+	args map[string]interface{},
+	contextVariables map[string]interface{},
+	onRetry func(attempt int, err error),
+) (Result, error) {
+	fn := af.toolFunc()
+	if fn == nil {
+		return Result{Error: fmt.Errorf("tool %s has no implementation", af.Name)}, nil
+	}
+
+	var result Result
+	for attempt := 0; attempt <= af.MaxRetries; attempt++ {
+		result = callFunction(ctx, af, fn, args, contextVariables)
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if result.Error == nil || errors.Is(result.Error, ErrInvalidToolArgs) {
+			break
+		}
+		if onRetry != nil && attempt < af.MaxRetries {
+			onRetry(attempt+2, result.Error)
+		}
+	}
+	return result, nil
+}
+
+// callFunction makes a single call to fn, giving up once ctx is done or the timeout expires
+func callFunction(
+	ctx context.Context,
+	af *AgentFunction,
+	fn ToolFunc,
+	args map[string]interface{},
+	contextVariables map[string]interface{},
+) Result {
+	callCtx := ctx
+	if af.Timeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, af.Timeout)
+		defer cancel()
+	}
+
+	// The function works on its own copy of the context variables, whose changes are only
+	// applied once it returns, so a call abandoned after a timeout can't race with the run
+	base := copyContextVariables(contextVariables)
+	vars := copyContextVariables(base)
+	done := make(chan Result, 1)
+	go func() {
+		done <- fn(callCtx, args, vars)
+	}()
+
+	select {
+	case result := <-done:
+		if contextVariables != nil {
+			mergeContextVariables(contextVariables, base, vars)
+		}
+		// A context-aware function that gave up on its own deadline still timed out
+		if result.Error != nil && ctx.Err() == nil && errors.Is(callCtx.Err(), context.DeadlineExceeded) {
+			result.Error = &ToolTimeoutError{Tool: af.Name, Timeout: af.Timeout}
+		}
+		return result
+	case <-callCtx.Done():
+		if err := ctx.Err(); err != nil {
+			return Result{Error: err}
+		}
+		return Result{Error: &ToolTimeoutError{Tool: af.Name, Timeout: af.Timeout}}
+	}
+}
+
+// toolErrorKind classifies the error returned by a tool's function
+func toolErrorKind(err error) ToolErrorKind {
+	switch {
+	case err == nil:
+		return ToolErrorNone
+	case errors.Is(err, ErrInvalidToolArgs):
+		return ToolErrorParse
+	default:
+		return ToolErrorFunction
+	}
+}
+
+// formatToolError renders a failed tool call as the content sent back to the model.
+// Timeouts and rejections are reported as JSON objects so the model can tell them apart from tool errors.
+func formatToolError(err error) string {
+	var timeoutErr *ToolTimeoutError
+	if errors.As(err, &timeoutErr) {
+		content, _ := json.Marshal(map[string]interface{}{
+			"error":           "timeout",
+			"tool":            timeoutErr.Tool,
+			"timeout_seconds": timeoutErr.Timeout.Seconds(),
+			"message":         timeoutErr.Error(),
+		})
+		return string(content)
+	}
+	var rejectedErr *ToolRejectedError
+	if errors.As(err, &rejectedErr) {
+		content, _ := json.Marshal(map[string]interface{}{
+			"error":   "rejected",
+			"tool":    rejectedErr.Tool,
+			"reason":  rejectedErr.Reason,
+			"message": "The user did not approve this call. Do not retry it unchanged.",
+		})
+		return string(content)
+	}
+	return fmt.Sprintf("Error: %v", err)
+}
+
+// reflectParameters generates the JSON schema describing a tool's arguments
+func reflectParameters(t reflect.Type) (map[string]interface{}, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("arguments must be a struct, got %s", t)
+	}
+
+	reflector := jsonschema.Reflector{
+		AllowAdditionalProperties: false,
+		DoNotReference:            true,
+	}
+	data, err := json.Marshal(reflector.ReflectFromType(t))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal schema: %w", err)
+	}
+
+	var parameters map[string]interface{}
+	if err := json.Unmarshal(data, &parameters); err != nil {
+		return nil, fmt.Errorf("failed to decode schema: %w", err)
+	}
+
+	// Providers expect a bare object schema
+	delete(parameters, "$schema")
+	delete(parameters, "$id")
+	if _, ok := parameters["properties"]; !ok {
+		parameters["properties"] = map[string]interface{}{}
+	}
+	return parameters, nil
+}
+
+// decodeToolArgs checks the raw arguments against the schema's top-level
+// properties and decodes them into target
+func decodeToolArgs(raw map[string]interface{}, parameters map[string]interface{}, target interface{}) error {
+	var missing []string
+	if required, ok := parameters["required"].([]interface{}); ok {
+		for _, r := range required {
+			if field, ok := r.(string); ok {
+				if _, present := raw[field]; !present {
+					missing = append(missing, field)
+				}
+			}
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("missing required fields: %s", strings.Join(missing, ", "))
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(target)
+}
+
+// formatToolContent renders a tool's result data as the content sent back to the model.
+// Strings are sent as is, other values are marshaled to JSON.
+func formatToolContent(data interface{}) string {
+	switch v := data.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case nil:
+		return ""
+	}
+
+	content, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Sprintf("%v", data)
+	}
+	return string(content)
+}
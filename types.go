@@ -1,22 +1,56 @@
 package swarmgo
 
 import (
+	"time"
+
 	"github.com/prathyushnallamothu/swarmgo/llm"
 )
 
+// StopReason describes why a Run stopped calling the model
+type StopReason string
+
+const (
+	StopFinalMessage     StopReason = "final_message"      // The model replied without requesting any tools
+	StopHandoff          StopReason = "handoff"            // A tool handed the conversation to another agent
+	StopMaxTurns         StopReason = "max_turns"          // The turn limit was reached
+	StopToolsNotExecuted StopReason = "tools_not_executed" // The model requested tools but tool execution was disabled
+	StopGuardrail        StopReason = "guardrail"          // A guardrail blocked the input or the final reply
+	StopPendingApproval  StopReason = "pending_approval"   // The model called tools that require approval, see Response.PendingApproval
+)
+
 // Response represents the response from an agent
 type Response struct {
 	Messages         []llm.Message
 	Agent            *Agent
 	ContextVariables map[string]interface{}
-	ToolResults      []ToolResult // Results from tool calls
+	ToolResults      []ToolResult     // Results from tool calls
+	StopReason       StopReason       // Why the run ended
+	Turns            int              // Number of model calls made during the run
+	Usage            RunUsage         // Token usage and estimated cost of the run, including runs nested in it
+	GuardrailEvents  []GuardrailEvent // Guardrails that blocked, rewrote or flagged content
+	PendingApproval  *PendingApproval // State to resume from when StopReason is StopPendingApproval
+	Handoff          *HandoffRecord   // The handoff that ended the run when StopReason is StopHandoff
 }
 
+// ToolErrorKind identifies which stage of a tool call failed
+type ToolErrorKind string
+
+const (
+	ToolErrorNone     ToolErrorKind = ""          // The tool call succeeded
+	ToolErrorParse    ToolErrorKind = "parse"     // The arguments were not valid JSON or didn't match the tool's schema
+	ToolErrorNotFound ToolErrorKind = "not_found" // The agent has no tool with the requested name
+	ToolErrorFunction ToolErrorKind = "function"  // The tool ran and returned an error
+	ToolErrorRejected ToolErrorKind = "rejected"  // The application rejected the call instead of approving it
+)
+
 // ToolResult represents the result of a tool call
 type ToolResult struct {
-	ToolName string      // Name of the tool that was called
-	Args     interface{} // Arguments passed to the tool
-	Result   Result      // Result returned by the tool
+	ToolName   string        // Name of the tool that was called
+	ToolCallID string        // ID of the tool call this result answers
+	Args       interface{}   // Arguments the tool was called with, after any rewrite by tool middleware
+	Result     Result        // Result returned by the tool
+	Duration   time.Duration // Time spent executing the tool
+	ErrorKind  ToolErrorKind // Where the call failed, empty on success
 }
 
 // Result represents the result of a function execution
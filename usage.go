@@ -0,0 +1,126 @@
+package swarmgo
+
+import (
+	"strings"
+
+	"github.com/prathyushnallamothu/swarmgo/llm"
+)
+
+// ModelPricing is the price of a model in some currency per million tokens
+type ModelPricing struct {
+	PromptPerMillion     float64 // Price of one million prompt tokens
+	CompletionPerMillion float64 // Price of one million completion tokens
+}
+
+// Cost estimates the cost of the given token usage
+func (p ModelPricing) Cost(usage llm.Usage) float64 {
+	return (float64(usage.PromptTokens)*p.PromptPerMillion +
+		float64(usage.CompletionTokens)*p.CompletionPerMillion) / 1e6
+}
+
+// Usage aggregates the token usage and estimated cost of one or more model calls
+type Usage struct {
+	Requests         int     // Number of model calls
+	PromptTokens     int     // Tokens sent to the model
+	CompletionTokens int     // Tokens generated by the model
+	TotalTokens      int     // Prompt and completion tokens
+	Cost             float64 // Estimated cost, 0 when the model has no pricing
+}
+
+// Add adds other to the usage
+func (u *Usage) Add(other Usage) {
+	u.Requests += other.Requests
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+	u.Cost += other.Cost
+}
+
+// TurnUsage is the usage of a single model call
+type TurnUsage struct {
+	Turn    int    // Turn of the run the call was made in, starting at 1
+	Agent   string // Agent that made the call
+	Model   string // Model that was called
+	Summary bool   // Whether the call summarized the conversation rather than ran the turn
+	Usage
+}
+
+// RunUsage is the usage of a run, broken down per turn, per agent and per model
+type RunUsage struct {
+	Usage                    // Totals across all turns
+	Turns   []TurnUsage      // Usage of each model call, in order
+	ByAgent map[string]Usage // Totals per agent name
+	ByModel map[string]Usage // Totals per model
+}
+
+// AddTurn records the usage of a model call
+func (u *RunUsage) AddTurn(turn TurnUsage) {
+	if u.ByAgent == nil {
+		u.ByAgent = make(map[string]Usage)
+	}
+	if u.ByModel == nil {
+		u.ByModel = make(map[string]Usage)
+	}
+
+	u.Turns = append(u.Turns, turn)
+	u.Usage.Add(turn.Usage)
+
+	agentUsage := u.ByAgent[turn.Agent]
+	agentUsage.Add(turn.Usage)
+	u.ByAgent[turn.Agent] = agentUsage
+
+	modelUsage := u.ByModel[turn.Model]
+	modelUsage.Add(turn.Usage)
+	u.ByModel[turn.Model] = modelUsage
+}
+
+// Merge adds all turns of other, e.g. to roll up the runs of a workflow
+func (u *RunUsage) Merge(other RunUsage) {
+	for _, turn := range other.Turns {
+		u.AddTurn(turn)
+	}
+}
+
+// turnUsage converts the usage reported by a provider for one model call
+func (s *Swarm) turnUsage(turn int, agent, model string, usage llm.Usage) TurnUsage {
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+
+	var cost float64
+	if pricing, ok := s.pricingFor(model); ok {
+		cost = pricing.Cost(usage)
+	}
+
+	return TurnUsage{
+		Turn:  turn,
+		Agent: agent,
+		Model: model,
+		Usage: Usage{
+			Requests:         1,
+			PromptTokens:     usage.PromptTokens,
+			CompletionTokens: usage.CompletionTokens,
+			TotalTokens:      usage.TotalTokens,
+			Cost:             cost,
+		},
+	}
+}
+
+// pricingFor looks up the pricing of a model, falling back to the longest
+// configured prefix so dated versions such as "gpt-4o-2024-08-06" match "gpt-4o"
+func (s *Swarm) pricingFor(model string) (ModelPricing, bool) {
+	if pricing, ok := s.config.Pricing[model]; ok {
+		return pricing, true
+	}
+
+	var best string
+	for name := range s.config.Pricing {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return ModelPricing{}, false
+	}
+	return s.config.Pricing[best], true
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"errors"
 	"fmt"
+	"log/slog"
 	"regexp"
 	"strings"
 	"time"
@@ -61,7 +62,17 @@ type Workflow struct {
 
 // NewWorkflow initializes a new Workflow instance.
 func NewWorkflow(apikey string, provider llm.LLMProvider, workflowType WorkflowType) *Workflow {
-	swarm := NewSwarm(apikey, provider)
+	return newWorkflowWithSwarm(NewSwarm(apikey, provider), workflowType)
+}
+
+// NewWorkflowWithConfig initializes a new Workflow whose agents run on a Swarm with custom
+// configuration, e.g. to set Config.Logger from the start
+func NewWorkflowWithConfig(apikey string, provider llm.LLMProvider, workflowType WorkflowType, config *Config) *Workflow {
+	return newWorkflowWithSwarm(NewSwarmWithConfig(apikey, provider, config), workflowType)
+}
+
+// newWorkflowWithSwarm initializes a Workflow whose agents run on swarm
+func newWorkflowWithSwarm(swarm *Swarm, workflowType WorkflowType) *Workflow {
 	return &Workflow{
 		swarm:         swarm,
 		agents:        make(map[string]*Agent),
@@ -76,6 +87,16 @@ func NewWorkflow(apikey string, provider llm.LLMProvider, workflowType WorkflowT
 	}
 }
 
+// Use adds middleware around every LLM call made by the workflow's agents
+func (wf *Workflow) Use(middleware ...llm.Middleware) {
+	wf.swarm.Use(middleware...)
+}
+
+// SetLogger sets the logger the workflow and its agents write to
+func (wf *Workflow) SetLogger(logger *slog.Logger) {
+	wf.swarm.SetLogger(logger)
+}
+
 // SetCycleCallback sets a callback function to be called when a cycle is detected
 func (wf *Workflow) SetCycleCallback(callback func(from, to string) (bool, error)) {
 	wf.cycleCallback = callback
@@ -87,11 +108,19 @@ func (wf *Workflow) SetCycleHandling(handling CycleHandling) {
 }
 
 
-// logTransition logs agent transitions for debugging
-func (wf *Workflow) logTransition(from, to string, reason string) {
+// logTransition logs agent transitions for debugging and reports them to the Swarm's hooks
+func (wf *Workflow) logTransition(ctx context.Context, from, to string, reason string) {
 	log := fmt.Sprintf("Transition: %s -> %s (%s)", from, to, reason)
 	wf.routingLog = append(wf.routingLog, log)
-	fmt.Printf("\033[93m%s\033[0m\n", log)
+	wf.swarm.log(ctx, false, slog.LevelInfo, "workflow transition", "from", from, "to", to, "reason", reason)
+	wf.swarm.emit(ctx, RunEvent{
+		Type:      EventWorkflowTransition,
+		Turn:      wf.currentStep,
+		Agent:     from,
+		FromAgent: from,
+		ToAgent:   to,
+		Reason:    reason,
+	})
 }
 
 // GetCurrentAgent returns the currently active agent
@@ -163,7 +192,10 @@ func (wf *Workflow) Execute(startAgent string, userRequest string) (*WorkflowRes
 	cycleCount := make(map[string]int)
 	wf.currentAgent = startAgent
 	wf.currentStep = 0
-	wf.logTransition("start", startAgent, "workflow initialization")
+
+	// The agents' runs are nested under the workflow's run ID
+	ctx := startRunInfo(context.Background(), startAgent)
+	wf.logTransition(ctx, "start", startAgent, "workflow initialization")
 
 	for {
 		// Start new step
@@ -176,9 +208,12 @@ func (wf *Workflow) Execute(startAgent string, userRequest string) (*WorkflowRes
 
 
 		// Execute current agent
-		fmt.Printf("\033[96mExecuting agent: %s (Step %d)\033[0m\n", wf.currentAgent, stepResult.StepNumber)
-		response, err := wf.executeAgent(wf.currentAgent, messageHistory)
+		stepCtx := withTurn(ctx, stepResult.StepNumber, wf.currentAgent)
+		wf.swarm.log(stepCtx, false, slog.LevelInfo, "executing workflow step")
+		response, usage, err := wf.executeAgent(stepCtx, wf.currentAgent, messageHistory)
 		stepResult.EndTime = time.Now()
+		stepResult.Usage = usage
+		result.Usage.Merge(usage)
 
 
 		if err != nil {
@@ -203,7 +238,7 @@ func (wf *Workflow) Execute(startAgent string, userRequest string) (*WorkflowRes
 		wf.currentStep++
 
 		if !shouldContinue {
-			wf.logTransition(wf.currentAgent, "end", "workflow complete")
+			wf.logTransition(ctx, wf.currentAgent, "end", "workflow complete")
 			break
 		}
 
@@ -211,7 +246,7 @@ func (wf *Workflow) Execute(startAgent string, userRequest string) (*WorkflowRes
 		if visited[nextAgent] {
 			cycleCount[nextAgent]++
 			reason := fmt.Sprintf("cycle detected (%d times)", cycleCount[nextAgent])
-			wf.logTransition(wf.currentAgent, nextAgent, reason)
+			wf.logTransition(ctx, wf.currentAgent, nextAgent, reason)
 
 			switch wf.cycleHandling {
 			case StopOnCycle:
@@ -238,7 +273,7 @@ func (wf *Workflow) Execute(startAgent string, userRequest string) (*WorkflowRes
 		}
 
 		// Log transition and update current agent
-		wf.logTransition(wf.currentAgent, nextAgent, "normal routing")
+		wf.logTransition(ctx, wf.currentAgent, nextAgent, "normal routing")
 		wf.currentAgent = nextAgent
 		visited[nextAgent] = true
 	}
@@ -271,9 +306,9 @@ func (wf *Workflow) GetLastStepResult() (*StepResult, error) {
 }
 
 // executeAgent executes a single agent and manages its state
-func (wf *Workflow) executeAgent(agentName string, messageHistory []llm.Message) ([]llm.Message, error) {
+func (wf *Workflow) executeAgent(ctx context.Context, agentName string, messageHistory []llm.Message) ([]llm.Message, RunUsage, error) {
 	agent := wf.agents[agentName]
-	fmt.Printf("\033[95mAgent %s processing message...\033[0m\n", agentName)
+	wf.swarm.log(ctx, false, slog.LevelDebug, "agent processing messages", "messages", len(messageHistory))
 
 	// Prepare agent state
 	var state map[string]interface{}
@@ -285,7 +320,7 @@ func (wf *Workflow) executeAgent(agentName string, messageHistory []llm.Message)
 
 	// Execute agent
 	response, err := wf.swarm.Run(
-		context.Background(),
+		ctx,
 		agent,
 		messageHistory,
 		state,
@@ -296,20 +331,20 @@ func (wf *Workflow) executeAgent(agentName string, messageHistory []llm.Message)
 		true,
 	)
 	if err != nil {
-		fmt.Printf("\033[91mError executing agent %s: %v\033[0m\n", agentName, err)
-		return nil, err
+		wf.swarm.log(ctx, false, slog.LevelError, "error executing agent", "error", err)
+		return nil, RunUsage{}, err
 	}
 
-	fmt.Printf("\033[92mAgent %s completed processing\033[0m\n", agentName)
+	wf.swarm.log(ctx, false, slog.LevelDebug, "agent completed processing", "messages", len(response.Messages))
 
-	// Update state
+	// Keep the context variables the run ended with
 	if wf.workflowType == CollaborativeWorkflow {
-		wf.sharedState = state
+		wf.sharedState = response.ContextVariables
 	} else {
-		wf.agentStates[agentName] = state
+		wf.agentStates[agentName] = response.ContextVariables
 	}
 
-	return response.Messages, nil
+	return response.Messages, response.Usage, nil
 }
 
 // routeToNextAgent determines the next agent based on workflow type and message content
@@ -651,6 +686,7 @@ type StepResult struct {
 	EndTime    time.Time
 	NextAgent  string
 	StepNumber int
+	Usage      RunUsage // Token usage and estimated cost of the step
 }
 
 // WorkflowResult represents the complete workflow execution result
@@ -660,4 +696,5 @@ type WorkflowResult struct {
 	Error       error
 	StartTime   time.Time
 	EndTime     time.Time
+	Usage       RunUsage // Token usage and estimated cost of all steps
 }